@@ -0,0 +1,515 @@
+package spf
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// AuthenticationResults is the value of an RFC 8601 Authentication-Results
+// header field.
+type AuthenticationResults struct {
+	AuthServID string // the host that did the checks
+	Version    int    // zero if not given, which means version 1
+	Results    []AuthResult
+}
+
+// AuthResult is the result of a single authentication method in an
+// Authentication-Results header field.
+type AuthResult struct {
+	Method     string // e.g. "spf"
+	Result     string // e.g. "pass"
+	Reason     string // an explanation of the result, for humans
+	Comment    string // a comment after the result, for humans
+	Properties []AuthProperty
+}
+
+// AuthProperty is a property of an AuthResult, such as smtp.mailfrom.
+type AuthProperty struct {
+	Type  string // e.g. "smtp"
+	Name  string // e.g. "mailfrom"
+	Value string
+}
+
+// Property returns the value of the first property with the given type and
+// name, or "" if there isn't one.
+func (a AuthResult) Property(ptype, name string) string {
+	for _, p := range a.Properties {
+		if strings.EqualFold(p.Type, ptype) && strings.EqualFold(p.Name, name) {
+			return p.Value
+		}
+	}
+	return ""
+}
+
+// AuthResult returns the Result as the resinfo part of an
+// Authentication-Results header field. The reason given for a failed DNS
+// lookup names the query but not the resolver's error, as in SMTPReply.
+//
+// 2.7.2.  SPF and Sender ID (RFC 8601)
+//
+//	SPF uses the "smtp.mailfrom" or "smtp.helo" ptype.property to
+//	identify the domain that was checked.
+func (r *Result) AuthResult() AuthResult {
+	ret := AuthResult{
+		Method: "spf",
+		Result: r.Type.String(),
+	}
+	if (r.Type == Temperror || r.Type == Permerror) && r.Error != nil {
+		ret.Reason = publicError(r.Error)
+	}
+	if r.UsedHelo {
+		ret.Properties = append(ret.Properties, AuthProperty{Type: "smtp", Name: "helo", Value: r.helo})
+	} else if r.sender != "" {
+		ret.Properties = append(ret.Properties, AuthProperty{Type: "smtp", Name: "mailfrom", Value: r.sender})
+	}
+	return ret
+}
+
+// NewAuthenticationResults builds an Authentication-Results header field
+// from the results of SPF checks, such as one of the HELO identity and one
+// of the MAIL FROM identity.
+func NewAuthenticationResults(authServID string, results ...Result) *AuthenticationResults {
+	ret := &AuthenticationResults{
+		AuthServID: authServID,
+	}
+	for _, r := range results {
+		ret.Results = append(ret.Results, r.AuthResult())
+	}
+	return ret
+}
+
+// String returns the header field value, without the field name.
+//
+//	authres-header-field = "Authentication-Results:" authres-payload
+//	authres-payload = [CFWS] authserv-id
+//	         [ CFWS authres-version ]
+//	         ( no-result / 1*resinfo ) [CFWS] CRLF
+func (a *AuthenticationResults) String() string {
+	var sb strings.Builder
+	sb.WriteString(quoteValue(a.AuthServID))
+	if a.Version != 0 {
+		sb.WriteString(" ")
+		sb.WriteString(strconv.Itoa(a.Version))
+	}
+	if len(a.Results) == 0 {
+		sb.WriteString("; none")
+		return sb.String()
+	}
+	for _, r := range a.Results {
+		sb.WriteString("; ")
+		sb.WriteString(r.String())
+	}
+	return sb.String()
+}
+
+// String returns the resinfo, without the leading ";".
+//
+//	resinfo = [CFWS] ";" methodspec [ CFWS reasonspec ]
+//	          [ 1*( CFWS propspec ) ]
+func (a AuthResult) String() string {
+	var sb strings.Builder
+	sb.WriteString(a.Method)
+	sb.WriteString("=")
+	sb.WriteString(a.Result)
+	if a.Comment != "" {
+		sb.WriteString(" (")
+		sb.WriteString(escapeComment(a.Comment))
+		sb.WriteString(")")
+	}
+	if a.Reason != "" {
+		sb.WriteString(" reason=")
+		sb.WriteString(quoteValue(a.Reason))
+	}
+	for _, p := range a.Properties {
+		sb.WriteString(" ")
+		sb.WriteString(p.Type)
+		sb.WriteString(".")
+		sb.WriteString(p.Name)
+		sb.WriteString("=")
+		sb.WriteString(quotePropertyValue(p.Value))
+	}
+	return sb.String()
+}
+
+// tspecials from RFC 2045, which can't appear in a token
+const tspecials = `()<>@,;:\"/[]?=`
+
+// isToken returns whether s is an RFC 2045 token
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range []byte(s) {
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(tspecials, c) != -1 {
+			return false
+		}
+	}
+	return true
+}
+
+// quoteValue returns s as a token if possible, otherwise as an RFC 5322
+// quoted-string.
+func quoteValue(s string) string {
+	if isToken(s) {
+		return s
+	}
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, c := range []byte(s) {
+		switch c {
+		case '"', '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case '\r', '\n':
+			sb.WriteByte(' ')
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// quotePropertyValue quotes a property value, which may also be a mailbox
+// or domain name.
+//
+//	pvalue = [CFWS] ( value / [ [ local-part ] "@" ] domain-name )
+//	         [CFWS]
+func quotePropertyValue(s string) string {
+	at := strings.LastIndex(s, "@")
+	if at == -1 {
+		if validDomainName(s) {
+			return s
+		}
+		return quoteValue(s)
+	}
+	local, domain := s[:at], s[at+1:]
+	if !validDomainName(domain) {
+		return quoteValue(s)
+	}
+	if local == "" || isDotAtom(local) {
+		return s
+	}
+	if len(local) > 1 && strings.HasPrefix(local, `"`) && strings.HasSuffix(local, `"`) {
+		// Already quoted, as it would be in an SMTP MAIL FROM
+		return s
+	}
+	return quoteValue(local) + "@" + domain
+}
+
+// atext characters, from RFC 5322, other than alphanumerics
+const atextSpecials = "!#$%&'*+-/=?^_`{|}~"
+
+// isDotAtom returns whether s is an RFC 5322 dot-atom-text
+func isDotAtom(s string) bool {
+	for _, atom := range strings.Split(s, ".") {
+		if atom == "" {
+			return false
+		}
+		for _, c := range []byte(atom) {
+			isAlnum := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+			if !isAlnum && strings.IndexByte(atextSpecials, c) == -1 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// escapeComment quotes the characters that can't appear as-is in an RFC
+// 5322 comment.
+func escapeComment(s string) string {
+	var sb strings.Builder
+	for _, c := range []byte(s) {
+		switch c {
+		case '(', ')', '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case '\r', '\n':
+			sb.WriteByte(' ')
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// headerToken is a lexical token of an Authentication-Results or Received-SPF
+// header
+type headerToken struct {
+	kind  byte // 'w' for a word, 'q' for a quoted-string, 'c' for a comment, or ';' '=' '.' '/'
+	value string
+}
+
+// lexHeader splits a header field value into tokens
+func lexHeader(s string) ([]headerToken, error) {
+	var ret []headerToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == ';' || c == '=' || c == '.' || c == '/':
+			ret = append(ret, headerToken{kind: c})
+			i++
+		case c == '(':
+			var sb strings.Builder
+			depth := 0
+			for ; i < len(s); i++ {
+				c = s[i]
+				if c == '\\' && i+1 < len(s) {
+					i++
+					sb.WriteByte(s[i])
+					continue
+				}
+				if c == '(' {
+					depth++
+					if depth == 1 {
+						continue
+					}
+				}
+				if c == ')' {
+					depth--
+					if depth == 0 {
+						break
+					}
+				}
+				sb.WriteByte(c)
+			}
+			if depth != 0 {
+				return nil, errors.New("unterminated comment")
+			}
+			i++
+			ret = append(ret, headerToken{kind: 'c', value: sb.String()})
+		case c == '"':
+			var sb strings.Builder
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				sb.WriteByte(s[i])
+			}
+			if i == len(s) {
+				return nil, errors.New("unterminated quoted-string")
+			}
+			i++
+			ret = append(ret, headerToken{kind: 'q', value: sb.String()})
+		default:
+			start := i
+			for ; i < len(s) && strings.IndexByte(" \t\r\n();=./\"", s[i]) == -1; i++ {
+			}
+			ret = append(ret, headerToken{kind: 'w', value: s[start:i]})
+		}
+	}
+	return ret, nil
+}
+
+// headerParser parses a sequence of tokens
+type headerParser struct {
+	tokens   []headerToken
+	comments []string // comments skipped since the last call to takeComments
+}
+
+// peek returns the next token that isn't a comment, or a zero token at the
+// end of input
+func (p *headerParser) peek() headerToken {
+	for len(p.tokens) > 0 && p.tokens[0].kind == 'c' {
+		p.comments = append(p.comments, p.tokens[0].value)
+		p.tokens = p.tokens[1:]
+	}
+	if len(p.tokens) == 0 {
+		return headerToken{}
+	}
+	return p.tokens[0]
+}
+
+func (p *headerParser) next() headerToken {
+	t := p.peek()
+	if len(p.tokens) > 0 {
+		p.tokens = p.tokens[1:]
+	}
+	return t
+}
+
+func (p *headerParser) takeComments() string {
+	ret := strings.Join(p.comments, " ")
+	p.comments = nil
+	return ret
+}
+
+func (p *headerParser) expect(kind byte) error {
+	if t := p.next(); t.kind != kind {
+		return fmt.Errorf("expected '%c', found %q", kind, t.value)
+	}
+	return nil
+}
+
+// word reads a token that must be a word
+func (p *headerParser) word(what string) (string, error) {
+	t := p.next()
+	if t.kind != 'w' {
+		return "", fmt.Errorf("expected %s", what)
+	}
+	return t.value, nil
+}
+
+// value reads a property value, which may be a quoted-string, a dotted
+// domain name or a mailbox, or a slash separated value.
+func (p *headerParser) value() (string, error) {
+	t := p.next()
+	switch t.kind {
+	case 'q':
+		var sb strings.Builder
+		sb.WriteString(t.value)
+		// A quoted local-part, followed by @domain
+		if next := p.peek(); next.kind == 'w' && strings.HasPrefix(next.value, "@") {
+			rest, err := p.value()
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(rest)
+		}
+		return sb.String(), nil
+	case 'w', '.', '/':
+		var sb strings.Builder
+		if t.kind == 'w' {
+			sb.WriteString(t.value)
+		} else {
+			sb.WriteByte(t.kind)
+		}
+		for {
+			next := p.peek()
+			if next.kind != '.' && next.kind != '/' {
+				break
+			}
+			p.next()
+			sb.WriteByte(next.kind)
+			if w := p.peek(); w.kind == 'w' {
+				p.next()
+				sb.WriteString(w.value)
+			}
+		}
+		return sb.String(), nil
+	}
+	return "", errors.New("expected a value")
+}
+
+// ParseAuthenticationResults parses the value of an Authentication-Results
+// header field, with or without the field name. Comments are attached to
+// the result they follow.
+func ParseAuthenticationResults(s string) (*AuthenticationResults, error) {
+	if colon := strings.Index(s, ":"); colon != -1 && strings.EqualFold(strings.TrimSpace(s[:colon]), "Authentication-Results") {
+		s = s[colon+1:]
+	}
+	tokens, err := lexHeader(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &headerParser{tokens: tokens}
+	ret := &AuthenticationResults{}
+
+	ret.AuthServID, err = p.value()
+	if err != nil {
+		return nil, fmt.Errorf("in authserv-id: %w", err)
+	}
+	if t := p.peek(); t.kind == 'w' {
+		p.next()
+		ret.Version, err = strconv.Atoi(t.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version '%s'", t.value)
+		}
+	}
+	if err := p.expect(';'); err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind == 'w' && strings.EqualFold(t.value, "none") {
+		p.next()
+		if t := p.peek(); t.kind != 0 {
+			return nil, fmt.Errorf("unexpected %q after none", t.value)
+		}
+		return ret, nil
+	}
+	p.takeComments()
+
+	for {
+		r, err := p.resinfo()
+		if err != nil {
+			return nil, err
+		}
+		ret.Results = append(ret.Results, r)
+		t := p.next()
+		if t.kind == 0 {
+			return ret, nil
+		}
+		if t.kind != ';' {
+			return nil, fmt.Errorf("unexpected %q", t.value)
+		}
+		if p.peek().kind == 0 {
+			// Trailing semicolon
+			return ret, nil
+		}
+	}
+}
+
+// resinfo parses a single method result
+func (p *headerParser) resinfo() (AuthResult, error) {
+	var r AuthResult
+	var err error
+	r.Method, err = p.word("method")
+	if err != nil {
+		return r, err
+	}
+	if p.peek().kind == '/' {
+		// Method version, which we ignore
+		p.next()
+		if _, err := p.word("method version"); err != nil {
+			return r, err
+		}
+	}
+	if err := p.expect('='); err != nil {
+		return r, err
+	}
+	r.Result, err = p.word("result")
+	if err != nil {
+		return r, err
+	}
+
+	for {
+		t := p.peek()
+		if t.kind != 'w' {
+			break
+		}
+		p.next()
+		if strings.EqualFold(t.value, "reason") {
+			if err := p.expect('='); err != nil {
+				return r, err
+			}
+			r.Reason, err = p.value()
+			if err != nil {
+				return r, err
+			}
+			continue
+		}
+		prop := AuthProperty{Type: t.value}
+		if err := p.expect('.'); err != nil {
+			return r, fmt.Errorf("in property %s: %w", t.value, err)
+		}
+		prop.Name, err = p.word("property name")
+		if err != nil {
+			return r, err
+		}
+		if err := p.expect('='); err != nil {
+			return r, err
+		}
+		prop.Value, err = p.value()
+		if err != nil {
+			return r, fmt.Errorf("in property %s.%s: %w", prop.Type, prop.Name, err)
+		}
+		r.Properties = append(r.Properties, prop)
+	}
+	r.Comment = p.takeComments()
+	return r, nil
+}
@@ -0,0 +1,136 @@
+package spf_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/wttw/spf"
+	"github.com/wttw/spf/spftest"
+)
+
+func TestAuthenticationResults(t *testing.T) {
+	res := spftest.NewResolver().
+		TXT("example.com", "v=spf1 ip4:192.0.2.0/24 -all").
+		TXT("mail.example.com", "v=spf1 a -all").
+		A("mail.example.com", "192.0.2.1").
+		TXT("broken.example.com", "v=spf1 foo:bar -all").
+		Timeout("timeout.example.com")
+	c := spftest.NewChecker(res)
+	c.Hostname = "mx.example.net"
+	ctx := context.Background()
+	ip := net.ParseIP("192.0.2.1")
+
+	result := c.SPF(ctx, ip, "user@example.com", "")
+	if got, want := result.AuthenticationResults(), "mx.example.net; spf=pass smtp.mailfrom=user@example.com"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	result = c.SPF(ctx, ip, "user@broken.example.com", "")
+	if got, want := result.AuthenticationResults(), `mx.example.net; spf=permerror reason="In field 'foo:bar': unrecognized mechanism 'foo'" smtp.mailfrom=user@broken.example.com`; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	// The resolver's error isn't given away
+	result = c.SPF(ctx, ip, "user@timeout.example.com", "")
+	if got, want := result.AuthenticationResults(), `mx.example.net; spf=temperror reason="TXT lookup for timeout.example.com. failed" smtp.mailfrom=user@timeout.example.com`; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	result = c.SPF(ctx, ip, `"odd user"@example.com`, "")
+	if got, want := result.AuthenticationResults(), `mx.example.net; spf=pass smtp.mailfrom="odd user"@example.com`; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	helo := c.CheckHelo(ctx, ip, "mail.example.com")
+	mailFrom := c.CheckMailFrom(ctx, ip, "user@example.com", "mail.example.com")
+	header := spf.NewAuthenticationResults(c.Hostname, helo, mailFrom)
+	want := "mx.example.net; spf=pass smtp.helo=mail.example.com; spf=pass smtp.mailfrom=user@example.com"
+	if header.String() != want {
+		t.Errorf("expected %q, got %q", want, header.String())
+	}
+
+	if got := spf.NewAuthenticationResults("mx.example.net").String(); got != "mx.example.net; none" {
+		t.Errorf("expected no results, got %q", got)
+	}
+}
+
+func TestParseAuthenticationResults(t *testing.T) {
+	// Examples from RFC 8601 appendix B
+	header := `Authentication-Results: example.com;
+	          spf=pass smtp.mailfrom=example.net
+	Received: from dialup-1-2-3-4.example.net`
+	header = header[:len(header)-len("\n\tReceived: from dialup-1-2-3-4.example.net")]
+	ar, err := spf.ParseAuthenticationResults(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ar.AuthServID != "example.com" || len(ar.Results) != 1 {
+		t.Fatalf("unexpected parse %#v", ar)
+	}
+	if r := ar.Results[0]; r.Method != "spf" || r.Result != "pass" || r.Property("smtp", "mailfrom") != "example.net" {
+		t.Errorf("unexpected result %#v", r)
+	}
+
+	ar, err = spf.ParseAuthenticationResults(`example.com 1;
+	          auth=pass (cram-md5) smtp.auth=sender@example.net;
+	          spf=pass smtp.mailfrom=example.net;
+	          sender-id=pass header.from=example.net`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ar.Version != 1 || len(ar.Results) != 3 {
+		t.Fatalf("unexpected parse %#v", ar)
+	}
+	if r := ar.Results[0]; r.Comment != "cram-md5" || r.Property("smtp", "auth") != "sender@example.net" {
+		t.Errorf("unexpected result %#v", r)
+	}
+	if r := ar.Results[2]; r.Method != "sender-id" || r.Property("header", "from") != "example.net" {
+		t.Errorf("unexpected result %#v", r)
+	}
+
+	ar, err = spf.ParseAuthenticationResults(`example.com; none`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ar.Results) != 0 {
+		t.Errorf("expected no results, got %v", ar.Results)
+	}
+
+	// Round trip
+	want := &spf.AuthenticationResults{
+		AuthServID: "mx.example.net",
+		Results: []spf.AuthResult{{
+			Method:  "spf",
+			Result:  "temperror",
+			Reason:  `lookup of "example.com" timed out (really)`,
+			Comment: "comment (nested) with \\ backslash",
+			Properties: []spf.AuthProperty{
+				{Type: "smtp", Name: "mailfrom", Value: `"odd user"@example.com`},
+			},
+		}},
+	}
+	ar, err = spf.ParseAuthenticationResults(want.String())
+	if err != nil {
+		t.Fatalf("%s: %v", want, err)
+	}
+	if ar.String() != want.String() {
+		t.Errorf("expected %s, got %s", want, ar)
+	}
+	got := ar.Results[0]
+	if got.Reason != want.Results[0].Reason || got.Comment != want.Results[0].Comment {
+		t.Errorf("expected %#v, got %#v", want.Results[0], got)
+	}
+
+	for _, bad := range []string{
+		"",
+		"example.com",
+		"example.com; spf",
+		"example.com; spf=pass (unterminated",
+		`example.com; spf=pass reason="unterminated`,
+	} {
+		if _, err := spf.ParseAuthenticationResults(bad); err == nil {
+			t.Errorf("expected an error parsing %q", bad)
+		}
+	}
+}
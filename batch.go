@@ -0,0 +1,167 @@
+package spf
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/sync/singleflight"
+)
+
+// DefaultBatchWorkers is the number of checks CheckMany runs at once if
+// BatchOptions.Workers isn't set.
+const DefaultBatchWorkers = 16
+
+// CheckRequest is a single message to check with CheckMany.
+type CheckRequest struct {
+	IP       net.IP
+	MailFrom string
+	Helo     string
+	Tag      interface{} // anything the caller needs to match the response to the request
+}
+
+// CheckResponse is the result of checking one CheckRequest.
+type CheckResponse struct {
+	Request CheckRequest
+	Index   int // position of the request in the input, counting from 0
+	Result  Result
+}
+
+// BatchOptions configures CheckMany.
+type BatchOptions struct {
+	Workers int           // how many checks to run at once, DefaultBatchWorkers if zero
+	Timeout time.Duration // deadline for each check, none if zero
+	Ordered bool          // return responses in the order the requests were received
+}
+
+// CheckMany checks each request read from requests, as Checker.SPF does,
+// several at a time, and sends the results to the returned channel. The
+// channel is closed once requests is closed and every request read has been
+// answered, or once ctx is done and every request already started has been.
+// The caller must read every response.
+//
+// Identical DNS queries made by different checks at the same time are sent
+// to the Resolver once, and the response shared. The Resolver and any Hook
+// are called from several goroutines at once, so must be safe for
+// concurrent use.
+func (c *Checker) CheckMany(ctx context.Context, requests <-chan CheckRequest, opts BatchOptions) <-chan CheckResponse {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = DefaultBatchWorkers
+	}
+	batch := *c
+	batch.Resolver = &coalescingResolver{ctx: ctx, timeout: opts.Timeout, upstream: c.Resolver}
+
+	check := func(index int, req CheckRequest) CheckResponse {
+		checkCtx := ctx
+		if opts.Timeout > 0 {
+			var cancel context.CancelFunc
+			checkCtx, cancel = context.WithTimeout(ctx, opts.Timeout)
+			defer cancel()
+		}
+		return CheckResponse{
+			Request: req,
+			Index:   index,
+			Result:  batch.SPF(checkCtx, req.IP, req.MailFrom, req.Helo),
+		}
+	}
+
+	out := make(chan CheckResponse, workers)
+	// Each request gets a slot for its response, queued in the order the
+	// requests arrived when the responses need to be in that order.
+	var slots chan chan CheckResponse
+	if opts.Ordered {
+		slots = make(chan chan CheckResponse, workers)
+		go func() {
+			for slot := range slots {
+				out <- <-slot
+			}
+			close(out)
+		}()
+	}
+
+	go func() {
+		var wg sync.WaitGroup
+		running := make(chan struct{}, workers)
+		index := 0
+	loop:
+		for {
+			var req CheckRequest
+			var ok bool
+			select {
+			case req, ok = <-requests:
+				if !ok {
+					break loop
+				}
+			case <-ctx.Done():
+				break loop
+			}
+			running <- struct{}{}
+			respond := out
+			if opts.Ordered {
+				slot := make(chan CheckResponse, 1)
+				slots <- slot
+				respond = slot
+			}
+			wg.Add(1)
+			go func(index int, req CheckRequest) {
+				defer wg.Done()
+				respond <- check(index, req)
+				<-running
+			}(index, req)
+			index++
+		}
+		wg.Wait()
+		if opts.Ordered {
+			close(slots)
+		} else {
+			close(out)
+		}
+	}()
+	return out
+}
+
+// coalescingResolver sends identical queries made at the same time to its
+// upstream Resolver once, sharing the response. The shared query is made
+// with ctx rather than that of any one caller, so that one caller's
+// deadline doesn't fail the others' queries, but is given up after timeout
+// so that a query that hangs isn't shared by every later caller.
+type coalescingResolver struct {
+	ctx      context.Context
+	timeout  time.Duration // no limit if zero
+	upstream Resolver
+	group    singleflight.Group
+}
+
+func (c *coalescingResolver) Resolve(ctx context.Context, r *dns.Msg) (*dns.Msg, error) {
+	if len(r.Question) != 1 {
+		return c.upstream.Resolve(ctx, r)
+	}
+	q := r.Question[0]
+	key := strings.ToLower(q.Name) + " " + strconv.Itoa(int(q.Qtype)) + " " + strconv.Itoa(int(q.Qclass))
+	ch := c.group.DoChan(key, func() (interface{}, error) {
+		ctx := c.ctx
+		if c.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, c.timeout)
+			defer cancel()
+		}
+		return c.upstream.Resolve(ctx, r.Copy())
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		m := res.Val.(*dns.Msg).Copy()
+		m.Id = r.Id
+		m.Question = append([]dns.Question(nil), r.Question...)
+		return m, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,151 @@
+package spf_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/wttw/spf"
+	"github.com/wttw/spf/spftest"
+)
+
+// blockingResolver never answers queries for one name
+type blockingResolver struct {
+	spf.Resolver
+	name string
+}
+
+func (r *blockingResolver) Resolve(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	if m.Question[0].Name == dns.Fqdn(r.name) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return r.Resolver.Resolve(ctx, m)
+}
+
+// hangOnceResolver never answers the first query, and closes hung when
+// that query is given up
+type hangOnceResolver struct {
+	spf.Resolver
+	once sync.Once
+	hung chan struct{}
+}
+
+func (r *hangOnceResolver) Resolve(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	first := false
+	r.once.Do(func() { first = true })
+	if first {
+		<-ctx.Done()
+		close(r.hung)
+		return nil, ctx.Err()
+	}
+	return r.Resolver.Resolve(ctx, m)
+}
+
+func TestCheckMany(t *testing.T) {
+	res := spftest.NewResolver().
+		TXT("example.com", "v=spf1 include:_spf.example.com -all").
+		TXT("_spf.example.com", "v=spf1 ip4:192.0.2.0/25 -all")
+	slow := &slowResolver{Resolver: res}
+	c := spftest.NewChecker(slow)
+
+	const count = 40
+	for _, ordered := range []bool{false, true} {
+		res.ResetQueries()
+		requests := make(chan spf.CheckRequest)
+		go func() {
+			for i := 0; i < count; i++ {
+				requests <- spf.CheckRequest{
+					IP:       net.IPv4(192, 0, 2, byte(i*4)),
+					MailFrom: "user@example.com",
+					Tag:      fmt.Sprintf("message %d", i),
+				}
+			}
+			close(requests)
+		}()
+
+		seen := map[int]bool{}
+		for resp := range c.CheckMany(context.Background(), requests, spf.BatchOptions{Workers: count, Ordered: ordered}) {
+			if ordered && resp.Index != len(seen) {
+				t.Errorf("expected response %d, got %d", len(seen), resp.Index)
+			}
+			seen[resp.Index] = true
+			if resp.Request.Tag != fmt.Sprintf("message %d", resp.Index) {
+				t.Errorf("response %d has tag %v", resp.Index, resp.Request.Tag)
+			}
+			want := spf.Pass
+			if resp.Index >= 32 {
+				want = spf.Fail
+			}
+			if resp.Result.Type != want {
+				t.Errorf("response %d: expected %s, got %s", resp.Index, want, resp.Result.Type)
+			}
+		}
+		if len(seen) != count {
+			t.Errorf("expected %d responses, got %d", count, len(seen))
+		}
+		if got := res.QueryCount("example.com", dns.TypeTXT); got >= count/2 {
+			t.Errorf("expected concurrent queries to be coalesced, got %d", got)
+		}
+	}
+}
+
+func TestCheckManyTimeout(t *testing.T) {
+	res := spftest.NewResolver().
+		TXT("example.com", "v=spf1 ip4:192.0.2.1 -all").
+		TXT("slow.example.com", "v=spf1 ip4:192.0.2.1 -all")
+	c := spftest.NewChecker(&blockingResolver{Resolver: res, name: "slow.example.com"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	requests := make(chan spf.CheckRequest, 2)
+	requests <- spf.CheckRequest{IP: net.ParseIP("192.0.2.1"), MailFrom: "user@slow.example.com"}
+	requests <- spf.CheckRequest{IP: net.ParseIP("192.0.2.1"), MailFrom: "user@example.com"}
+	close(requests)
+
+	start := time.Now()
+	var results []spf.ResultType
+	for resp := range c.CheckMany(ctx, requests, spf.BatchOptions{Timeout: 50 * time.Millisecond, Ordered: true}) {
+		results = append(results, resp.Result.Type)
+	}
+	if len(results) != 2 || results[0] != spf.Temperror || results[1] != spf.Pass {
+		t.Errorf("expected temperror and pass, got %v", results)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("timeout not applied, took %s", elapsed)
+	}
+}
+
+func TestCheckManyHungQuery(t *testing.T) {
+	res := spftest.NewResolver().
+		TXT("example.com", "v=spf1 ip4:192.0.2.1 -all")
+	hang := &hangOnceResolver{Resolver: res, hung: make(chan struct{})}
+	c := spftest.NewChecker(hang)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	requests := make(chan spf.CheckRequest)
+	defer close(requests)
+	responses := c.CheckMany(ctx, requests, spf.BatchOptions{Timeout: 50 * time.Millisecond})
+	req := spf.CheckRequest{IP: net.ParseIP("192.0.2.1"), MailFrom: "user@example.com"}
+
+	requests <- req
+	if resp := <-responses; resp.Result.Type != spf.Temperror {
+		t.Fatalf("expected the hung query to fail, got %s", resp.Result.Type)
+	}
+	// The shared query must be given up too, rather than left for later
+	// checks to wait on
+	select {
+	case <-hang.hung:
+	case <-time.After(2 * time.Second):
+		t.Fatal("hung query wasn't given up")
+	}
+	requests <- req
+	if resp := <-responses; resp.Result.Type != spf.Pass {
+		t.Errorf("expected a later check to be answered, got %s", resp.Result.Type)
+	}
+}
@@ -0,0 +1,223 @@
+package spf
+
+import (
+	"container/list"
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultCacheSize is the number of responses a CachingResolver created with
+// NewCachingResolver will hold.
+const DefaultCacheSize = 10000
+
+var _ Resolver = &CachingResolver{}
+
+// CachingResolver is a Resolver that caches the responses from another
+// Resolver for as long as their TTLs allow. Negative responses - NXDOMAIN and
+// NODATA - are cached using the SOA record in the authority section, as
+// described in RFC 2308. Failures and truncated responses are not cached.
+//
+// A CachingResolver is safe for concurrent use by multiple goroutines.
+type CachingResolver struct {
+	Resolver   Resolver      // upstream resolver to send cache misses to
+	MaxEntries int           // maximum number of cached responses, 0 for no limit
+	MaxTTL     time.Duration // maximum time to cache any response, 0 for no limit
+
+	mu      sync.Mutex
+	entries map[cacheKey]*list.Element
+	lru     *list.List
+	hits    uint64
+	misses  uint64
+}
+
+// CacheStats reports how effective a CachingResolver has been.
+type CacheStats struct {
+	Hits    uint64 // queries answered from the cache
+	Misses  uint64 // queries sent to the upstream resolver
+	Entries int    // responses currently cached
+}
+
+type cacheKey struct {
+	name   string
+	qtype  uint16
+	qclass uint16
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	msg     *dns.Msg
+	stored  time.Time
+	expires time.Time
+}
+
+// NewCachingResolver creates a CachingResolver holding up to DefaultCacheSize
+// responses from upstream.
+func NewCachingResolver(upstream Resolver) *CachingResolver {
+	return &CachingResolver{
+		Resolver:   upstream,
+		MaxEntries: DefaultCacheSize,
+	}
+}
+
+// Resolve answers a query from the cache if possible, otherwise from the
+// upstream Resolver.
+func (c *CachingResolver) Resolve(ctx context.Context, r *dns.Msg) (*dns.Msg, error) {
+	if len(r.Question) != 1 {
+		return c.Resolver.Resolve(ctx, r)
+	}
+	q := r.Question[0]
+	key := cacheKey{
+		name:   strings.ToLower(q.Name),
+		qtype:  q.Qtype,
+		qclass: q.Qclass,
+	}
+	now := time.Now()
+
+	if m := c.lookup(key, now); m != nil {
+		m.Id = r.Id
+		return m, nil
+	}
+
+	m, err := c.Resolver.Resolve(ctx, r)
+	if err != nil {
+		return m, err
+	}
+	ttl, ok := cacheTTL(m)
+	if ok {
+		if c.MaxTTL > 0 && ttl > c.MaxTTL {
+			ttl = c.MaxTTL
+		}
+		c.store(key, m.Copy(), now, ttl)
+	}
+	return m, nil
+}
+
+// Stats returns the number of cache hits and misses so far, and the number of
+// responses currently cached.
+func (c *CachingResolver) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Hits:    c.hits,
+		Misses:  c.misses,
+		Entries: len(c.entries),
+	}
+}
+
+// Flush discards all cached responses.
+func (c *CachingResolver) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = nil
+	c.lru = nil
+}
+
+// lookup returns a copy of a cached response, with TTLs reduced by the time
+// it has spent in the cache, or nil if there isn't one.
+func (c *CachingResolver) lookup(key cacheKey, now time.Time) *dns.Msg {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil
+	}
+	entry := el.Value.(*cacheEntry)
+	if !now.Before(entry.expires) {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+		c.misses++
+		return nil
+	}
+	c.hits++
+	c.lru.MoveToFront(el)
+	m := entry.msg.Copy()
+	age := uint32(now.Sub(entry.stored) / time.Second)
+	for _, section := range [][]dns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range section {
+			hdr := rr.Header()
+			if hdr.Rrtype == dns.TypeOPT {
+				continue
+			}
+			if hdr.Ttl > age {
+				hdr.Ttl -= age
+			} else {
+				hdr.Ttl = 0
+			}
+		}
+	}
+	return m
+}
+
+func (c *CachingResolver) store(key cacheKey, m *dns.Msg, now time.Time, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = map[cacheKey]*list.Element{}
+		c.lru = list.New()
+	}
+	entry := &cacheEntry{
+		key:     key,
+		msg:     m,
+		stored:  now,
+		expires: now.Add(ttl),
+	}
+	if el, ok := c.entries[key]; ok {
+		el.Value = entry
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.MaxEntries > 0 && c.lru.Len() > c.MaxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// cacheTTL works out how long a response may be cached for, if at all.
+func cacheTTL(m *dns.Msg) (time.Duration, bool) {
+	if m == nil || m.Truncated {
+		return 0, false
+	}
+	var ttl uint32
+	switch {
+	case m.Rcode == dns.RcodeSuccess && len(m.Answer) > 0:
+		ttl = m.Answer[0].Header().Ttl
+		for _, rr := range m.Answer[1:] {
+			if rr.Header().Ttl < ttl {
+				ttl = rr.Header().Ttl
+			}
+		}
+	case m.Rcode == dns.RcodeSuccess || m.Rcode == dns.RcodeNameError:
+		// RFC 2308 section 5: the TTL of a negative response is the smaller of
+		// the SOA MINIMUM field and the TTL of the SOA itself. Negative responses
+		// without an SOA record SHOULD NOT be cached.
+		found := false
+		for _, rr := range m.Ns {
+			soa, ok := rr.(*dns.SOA)
+			if !ok {
+				continue
+			}
+			ttl = soa.Hdr.Ttl
+			if soa.Minttl < ttl {
+				ttl = soa.Minttl
+			}
+			found = true
+			break
+		}
+		if !found {
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+	if ttl == 0 {
+		return 0, false
+	}
+	return time.Duration(ttl) * time.Second, true
+}
@@ -0,0 +1,171 @@
+package spf_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/wttw/spf"
+	"github.com/wttw/spf/spftest"
+)
+
+func TestCachingResolver(t *testing.T) {
+	upstream := spftest.NewResolver().
+		SOA("example.com").
+		TXT("example.com", "v=spf1 -all")
+	cache := spf.NewCachingResolver(upstream)
+
+	query := func(name string, qtype uint16) *dns.Msg {
+		r := &dns.Msg{}
+		r.SetQuestion(name, qtype)
+		m, err := cache.Resolve(context.Background(), r)
+		if err != nil {
+			t.Fatalf("%s %s: %v", dns.Type(qtype), name, err)
+		}
+		if m.Id != r.Id {
+			t.Errorf("%s %s: response id %d doesn't match query id %d", dns.Type(qtype), name, m.Id, r.Id)
+		}
+		return m
+	}
+
+	for i := 0; i < 3; i++ {
+		m := query("Example.COM.", dns.TypeTXT)
+		if len(m.Answer) != 1 {
+			t.Fatalf("expected 1 TXT answer, got %d", len(m.Answer))
+		}
+		query("example.com.", dns.TypeA)          // NODATA with SOA
+		query("missing.example.org.", dns.TypeMX) // NXDOMAIN without SOA
+	}
+
+	// TXT and A are fetched once each, MX every time
+	spftest.AssertQueries(t, upstream, 5)
+	stats := cache.Stats()
+	if stats.Hits != 4 || stats.Misses != 5 || stats.Entries != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+// cannedResolver answers each query with a fixed response for its name,
+// counting the queries for each name
+type cannedResolver struct {
+	mu        sync.Mutex
+	responses map[string]*dns.Msg
+	queries   map[string]int
+}
+
+func (r *cannedResolver) Resolve(_ context.Context, q *dns.Msg) (*dns.Msg, error) {
+	name := q.Question[0].Name
+	r.mu.Lock()
+	r.queries[name]++
+	r.mu.Unlock()
+	m := r.responses[name].Copy()
+	m.SetRcode(q, m.Rcode)
+	return m, nil
+}
+
+func TestCachingResolverExpiry(t *testing.T) {
+	a := func(name string, ttl uint32) dns.RR {
+		return &dns.A{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl}, A: []byte{192, 0, 2, 1}}
+	}
+	nxdomain := func(ttl, minttl uint32) *dns.Msg {
+		return &dns.Msg{
+			MsgHdr: dns.MsgHdr{Rcode: dns.RcodeNameError},
+			Ns: []dns.RR{&dns.SOA{
+				Hdr:    dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: ttl},
+				Ns:     "ns.example.com.",
+				Mbox:   "hostmaster.example.com.",
+				Minttl: minttl,
+			}},
+		}
+	}
+	upstream := &cannedResolver{
+		responses: map[string]*dns.Msg{
+			// A positive response is cached for the smallest TTL in the answer
+			"short.example.com.": {Answer: []dns.RR{a("short.example.com.", 3600), a("short.example.com.", 1)}},
+			"long.example.com.":  {Answer: []dns.RR{a("long.example.com.", 3600)}},
+			// A negative response is cached for the smaller of the SOA's TTL
+			// and its MINIMUM field
+			"soattl.example.com.":   nxdomain(1, 3600),
+			"minimum.example.com.":  nxdomain(3600, 1),
+			"negative.example.com.": nxdomain(3600, 3600),
+		},
+		queries: map[string]int{},
+	}
+	cache := spf.NewCachingResolver(upstream)
+
+	query := func(name string) *dns.Msg {
+		r := &dns.Msg{}
+		r.SetQuestion(name, dns.TypeA)
+		m, err := cache.Resolve(context.Background(), r)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		return m
+	}
+	for i := 0; i < 2; i++ {
+		for name := range upstream.responses {
+			query(name)
+		}
+	}
+	for name := range upstream.responses {
+		if upstream.queries[name] != 1 {
+			t.Errorf("%s: expected the response to be cached, queried %d times", name, upstream.queries[name])
+		}
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	for name := range upstream.responses {
+		query(name)
+	}
+	for name, want := range map[string]int{
+		"short.example.com.":    2,
+		"long.example.com.":     1,
+		"soattl.example.com.":   2,
+		"minimum.example.com.":  2,
+		"negative.example.com.": 1,
+	} {
+		if got := upstream.queries[name]; got != want {
+			t.Errorf("%s: expected %d queries, got %d", name, want, got)
+		}
+	}
+	// TTLs in a cached response count down
+	if ttl := query("long.example.com.").Answer[0].Header().Ttl; ttl != 3599 {
+		t.Errorf("expected a TTL of 3599 after a second in the cache, got %d", ttl)
+	}
+}
+
+func TestCachingResolverEviction(t *testing.T) {
+	names := []string{"a.example.com.", "b.example.com.", "c.example.com."}
+	upstream := spftest.NewResolver()
+	for _, name := range names {
+		upstream.A(name, "192.0.2.1")
+	}
+	cache := spf.NewCachingResolver(upstream)
+	cache.MaxEntries = 2
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		for _, name := range names {
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				r := &dns.Msg{}
+				r.SetQuestion(name, dns.TypeA)
+				if _, err := cache.Resolve(context.Background(), r); err != nil {
+					t.Error(err)
+				}
+			}(name)
+		}
+	}
+	wg.Wait()
+
+	stats := cache.Stats()
+	if stats.Entries != 2 {
+		t.Errorf("expected cache to be capped at 2 entries, has %d", stats.Entries)
+	}
+	if stats.Hits+stats.Misses != 30 {
+		t.Errorf("expected 30 lookups, got %+v", stats)
+	}
+}
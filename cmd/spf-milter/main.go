@@ -0,0 +1,123 @@
+/*
+spf-milter is a milter daemon that checks SPF for mail received by Postfix
+or Sendmail.
+
+It checks the HELO and MAIL FROM identities of each message, rejects or
+defers it according to the policy for the result, and adds
+Authentication-Results and Received-SPF headers to messages it accepts.
+
+	spf-milter -listen inet:127.0.0.1:8891
+
+	spf-milter -help
+	Usage of spf-milter:
+	  -authentication-results
+	    	add an Authentication-Results header (default true)
+	  -authserv-id string
+	    	identifier for this host in Authentication-Results headers (default hostname)
+	  -explanation string
+	    	explanation to give when a domain with no exp= fails
+	  -hostname string
+	    	hostname of this host (default from the operating system)
+	  -listen string
+	    	where to listen, as unix:path, inet:host:port, inet6:[host]:port or inet:port@host (default "inet:127.0.0.1:8891")
+	  -policy string
+	    	comma separated result=action pairs, where action is reject, tempfail or tag (default "fail=reject,temperror=tempfail")
+	  -received-spf
+	    	add a Received-SPF header (default true)
+
+To use it with Postfix add it to main.cf:
+
+	smtpd_milters = inet:127.0.0.1:8891
+	milter_default_action = accept
+
+and with Sendmail, to sendmail.mc:
+
+	INPUT_MAIL_FILTER(`spf', `S=inet:8891@127.0.0.1')
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/wttw/spf"
+	"github.com/wttw/spf/milter"
+)
+
+func main() {
+	var listen, policy, authServID, hostname, explanation string
+	var authRes, receivedSPF bool
+	flag.StringVar(&listen, "listen", "inet:127.0.0.1:8891", "where to listen, as unix:path, inet:host:port, inet6:[host]:port or inet:port@host")
+	flag.StringVar(&policy, "policy", milter.DefaultPolicy().String(), "comma separated result=action pairs, where action is reject, tempfail or tag")
+	flag.StringVar(&authServID, "authserv-id", "", "identifier for this host in Authentication-Results headers (default hostname)")
+	flag.StringVar(&hostname, "hostname", "", "hostname of this host (default from the operating system)")
+	flag.StringVar(&explanation, "explanation", "", "explanation to give when a domain with no exp= fails")
+	flag.BoolVar(&authRes, "authentication-results", true, "add an Authentication-Results header")
+	flag.BoolVar(&receivedSPF, "received-spf", true, "add a Received-SPF header")
+	flag.Parse()
+
+	if flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	p, err := milter.ParsePolicy(policy)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	c := spf.NewChecker()
+	if hostname != "" {
+		c.Hostname = hostname
+	}
+	c.DefaultExplanation = explanation
+
+	s := milter.NewServer(c)
+	s.Policy = p
+	s.AuthServID = authServID
+	s.AuthenticationResults = authRes
+	s.ReceivedSPF = receivedSPF
+
+	network, address, err := parseListen(listen)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if network == "unix" {
+		// Remove a socket left behind by a previous run
+		_ = os.Remove(address)
+	}
+	l, err := net.Listen(network, address)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Fatalln(s.Serve(l))
+}
+
+// parseListen parses a milter socket address in the forms used by Postfix
+// and Sendmail.
+func parseListen(s string) (string, string, error) {
+	colon := strings.Index(s, ":")
+	if colon == -1 {
+		return "", "", fmt.Errorf("expected type:address, not '%s'", s)
+	}
+	kind, address := strings.ToLower(s[:colon]), s[colon+1:]
+	switch kind {
+	case "unix", "local":
+		return "unix", address, nil
+	case "inet", "inet6", "tcp":
+		network := "tcp"
+		if kind == "inet6" {
+			network = "tcp6"
+		}
+		if at := strings.Index(address, "@"); at != -1 {
+			// Sendmail's port@host
+			return network, net.JoinHostPort(address[at+1:], address[:at]), nil
+		}
+		return network, address, nil
+	}
+	return "", "", fmt.Errorf("unknown socket type '%s'", kind)
+}
@@ -0,0 +1,112 @@
+/*
+spf-policyd is a Postfix SMTPD policy delegation server that checks SPF.
+
+It checks the client address, HELO name and sender of each request and
+answers with an action chosen by the result: by default it rejects fail,
+defers temperror unless the message is rejected for another reason, and
+adds a Received-SPF header for everything else.
+
+	spf-policyd -listen inet:127.0.0.1:10023 -config /etc/spf-policyd.yaml
+
+	spf-policyd -help
+	Usage of spf-policyd:
+	  -config string
+	    	YAML configuration file
+	  -listen string
+	    	where to listen, as unix:path, inet:host:port or inet6:[host]:port (default "inet:127.0.0.1:10023")
+
+The configuration file sets the action for each result, and lists clients,
+such as internal relays, that aren't checked.
+
+	actions:
+	  fail: REJECT
+	  softfail: DEFER_IF_PERMIT
+	  temperror: DEFER_IF_PERMIT
+	  permerror: PREPEND
+	trusted:
+	  - 127.0.0.0/8
+	  - ::1
+	  - 192.0.2.0/24
+	hostname: mx.example.com
+	explanation: "%{i} is not one of %{d}'s designated mail servers"
+
+Actions are REJECT, DEFER, DEFER_IF_PERMIT, PREPEND (a Received-SPF header),
+DUNNO and OK, as described in Postfix's access(5).
+
+To use it from Postfix add it to the recipient restrictions in main.cf, after
+permit_mynetworks and reject_unauth_destination:
+
+	smtpd_recipient_restrictions =
+	    permit_mynetworks,
+	    reject_unauth_destination,
+	    check_policy_service inet:127.0.0.1:10023
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/wttw/spf"
+	"github.com/wttw/spf/policyd"
+)
+
+func main() {
+	var listen, config string
+	flag.StringVar(&listen, "listen", "inet:127.0.0.1:10023", "where to listen, as unix:path, inet:host:port or inet6:[host]:port")
+	flag.StringVar(&config, "config", "", "YAML configuration file")
+	flag.Parse()
+
+	if flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	s := policyd.NewServer(spf.NewChecker())
+	if config != "" {
+		cfg, err := policyd.ReadConfig(config)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if err := cfg.Apply(s); err != nil {
+			log.Fatalf("in %s: %v", config, err)
+		}
+	}
+
+	network, address, err := parseListen(listen)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if network == "unix" {
+		// Remove a socket left behind by a previous run
+		_ = os.Remove(address)
+	}
+	l, err := net.Listen(network, address)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Fatalln(s.Serve(l))
+}
+
+// parseListen parses a socket address in the form Postfix uses for
+// check_policy_service.
+func parseListen(s string) (string, string, error) {
+	colon := strings.Index(s, ":")
+	if colon == -1 {
+		return "", "", fmt.Errorf("expected type:address, not '%s'", s)
+	}
+	kind, address := strings.ToLower(s[:colon]), s[colon+1:]
+	switch kind {
+	case "unix":
+		return "unix", address, nil
+	case "inet", "tcp":
+		return "tcp", address, nil
+	case "inet6":
+		return "tcp6", address, nil
+	}
+	return "", "", fmt.Errorf("unknown socket type '%s'", kind)
+}
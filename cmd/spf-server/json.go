@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/wttw/spf"
+)
+
+// checkRequest is the body of a POST /check request
+type checkRequest struct {
+	IP       string `json:"ip"`
+	MailFrom string `json:"mail_from"`
+	Helo     string `json:"helo"`
+	Trace    bool   `json:"trace"` // include the evaluation trace in the response
+}
+
+// recordJSON is the body of a GET /record response: the domain's record
+// as published and, if it parses, as an spf.SPFRecord
+type recordJSON struct {
+	Domain string         `json:"domain"`
+	Record string         `json:"record,omitempty"`
+	Parsed *spf.SPFRecord `json:"parsed,omitempty"`
+	Error  string         `json:"error,omitempty"`
+}
+
+// errorJSON is the body of an error response
+type errorJSON struct {
+	Error string `json:"error"`
+}
+
+// healthJSON is the body of a GET /health response
+type healthJSON struct {
+	Status string    `json:"status"`
+	Cache  cacheJSON `json:"cache"`
+}
+
+type cacheJSON struct {
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Entries int    `json:"entries"`
+}
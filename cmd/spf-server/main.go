@@ -0,0 +1,97 @@
+/*
+spf-server is an HTTP service that checks SPF, for use by programs that
+can't use the library directly.
+
+	spf-server -listen 127.0.0.1:8080
+
+	spf-server -help
+	Usage of spf-server:
+	  -cache-size int
+	    	maximum number of DNS responses to cache (default 10000)
+	  -hostname string
+	    	hostname of this host, used in explanations (default from the operating system)
+	  -listen string
+	    	address to listen on (default "127.0.0.1:8080")
+	  -timeout duration
+	    	maximum time to spend on each request (default 10s)
+
+POST /check checks SPF for a message. The body is a JSON object giving the
+client's ip and at least one of mail_from and helo. If trace is true the
+response includes every step taken to reach the result. The response is an
+spf.Result in its JSON form, and can be decoded back into one.
+
+	curl -d '{"ip": "192.0.2.1", "mail_from": "user@example.com", "helo": "mail.example.com"}' http://127.0.0.1:8080/check
+	{"type":"pass","dns_queries":1,"void_lookups":0,"used_helo":false,"mechanism":{"type":"ip4","qualifier":"pass","network":"192.0.2.0/24"},"ip":"192.0.2.1","sender":"user@example.com","helo":"mail.example.com"}
+
+GET /record/{domain} returns the domain's SPF record, parsed into its terms
+in the same form as the mechanism in a /check response.
+
+	curl http://127.0.0.1:8080/record/example.com
+	{"domain":"example.com","record":"v=spf1 ip4:192.0.2.0/24 -all","parsed":{"mechanisms":[{"type":"ip4","qualifier":"pass","network":"192.0.2.0/24"},{"type":"all","qualifier":"fail"}]}}
+
+GET /health reports that the server is running, along with DNS cache
+statistics.
+
+All requests share a DNS cache.
+*/
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/wttw/spf"
+)
+
+func main() {
+	var listen, hostname string
+	var timeout time.Duration
+	var cacheSize int
+	flag.StringVar(&listen, "listen", "127.0.0.1:8080", "address to listen on")
+	flag.DurationVar(&timeout, "timeout", 10*time.Second, "maximum time to spend on each request")
+	flag.IntVar(&cacheSize, "cache-size", spf.DefaultCacheSize, "maximum number of DNS responses to cache")
+	flag.StringVar(&hostname, "hostname", "", "hostname of this host, used in explanations (default from the operating system)")
+	flag.Parse()
+
+	if flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	c := spf.NewChecker()
+	cache := spf.NewCachingResolver(c.Resolver)
+	cache.MaxEntries = cacheSize
+	c.Resolver = cache
+	if hostname != "" {
+		c.Hostname = hostname
+	}
+	s := &server{checker: c, cache: cache, timeout: timeout}
+
+	srv := &http.Server{
+		Addr:              listen,
+		Handler:           s.routes(),
+		ReadHeaderTimeout: timeout,
+		ReadTimeout:       timeout,
+		WriteTimeout:      2 * timeout,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		_ = srv.Shutdown(shutdown)
+	}()
+
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalln(err)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/wttw/spf"
+)
+
+// maxRequestBody is the largest request body accepted
+const maxRequestBody = 64 * 1024
+
+type server struct {
+	checker *spf.Checker
+	cache   *spf.CachingResolver
+	timeout time.Duration // for each request
+}
+
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /check", s.check)
+	mux.HandleFunc("GET /record/{domain}", s.record)
+	mux.HandleFunc("GET /health", s.health)
+	return mux
+}
+
+// check handles POST /check, checking SPF for the identities in the
+// request body.
+func (s *server) check(w http.ResponseWriter, r *http.Request) {
+	var req checkRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request: "+err.Error())
+		return
+	}
+	ip := net.ParseIP(req.IP)
+	if ip == nil {
+		writeError(w, http.StatusBadRequest, "invalid ip '"+req.IP+"'")
+		return
+	}
+	if req.MailFrom == "" && req.Helo == "" {
+		writeError(w, http.StatusBadRequest, "one of mail_from and helo is required")
+		return
+	}
+
+	c := s.checker
+	if req.Trace {
+		traced := *s.checker
+		traced.Trace = true
+		c = &traced
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+	defer cancel()
+	result := c.SPF(ctx, ip, req.MailFrom, req.Helo)
+	writeJSON(w, http.StatusOK, result)
+}
+
+// record handles GET /record/{domain}, returning the domain's parsed SPF
+// record.
+func (s *server) record(w http.ResponseWriter, r *http.Request) {
+	domain := strings.TrimSuffix(r.PathValue("domain"), ".")
+	if _, ok := dns.IsDomainName(domain); !ok || domain == "" {
+		writeError(w, http.StatusBadRequest, "invalid domain '"+domain+"'")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+	defer cancel()
+	text, err := s.checker.LookupSPF(ctx, domain)
+	if errors.Is(err, spf.ErrMultipleRecords) {
+		writeJSON(w, http.StatusUnprocessableEntity, recordJSON{Domain: domain, Error: err.Error()})
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	if text == "" {
+		writeError(w, http.StatusNotFound, domain+" has no SPF record")
+		return
+	}
+	record, err := spf.ParseSPF(text)
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, recordJSON{Domain: domain, Record: text, Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, recordJSON{Domain: domain, Record: text, Parsed: record})
+}
+
+// health handles GET /health.
+func (s *server) health(w http.ResponseWriter, _ *http.Request) {
+	stats := s.cache.Stats()
+	writeJSON(w, http.StatusOK, healthJSON{
+		Status: "ok",
+		Cache: cacheJSON{
+			Hits:    stats.Hits,
+			Misses:  stats.Misses,
+			Entries: stats.Entries,
+		},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("writing response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, errorJSON{Error: message})
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wttw/spf"
+	"github.com/wttw/spf/spftest"
+)
+
+func testServer() *httptest.Server {
+	res := spftest.NewResolver().
+		TXT("example.com", "v=spf1 ip4:192.0.2.0/24 include:_spf.example.com -all").
+		TXT("_spf.example.com", "v=spf1 ip4:198.51.100.0/24 -all").
+		TXT("broken.example.com", "v=spf1 foo:bar -all").
+		TXT("two.example.com", "v=spf1 -all", "v=spf1 +all")
+	cache := spf.NewCachingResolver(res)
+	c := spftest.NewChecker(cache)
+	s := &server{checker: c, cache: cache, timeout: time.Second}
+	return httptest.NewServer(s.routes())
+}
+
+func TestCheck(t *testing.T) {
+	ts := testServer()
+	defer ts.Close()
+
+	tests := []struct {
+		body   string
+		status int
+		want   string // type, DNS queries, helo and mechanism of the result
+	}{
+		{
+			`{"ip": "192.0.2.1", "mail_from": "user@example.com", "helo": "mail.example.com"}`,
+			http.StatusOK,
+			"pass 1 false ip4:192.0.2.0/24",
+		},
+		{`{"ip": "203.0.113.1", "mail_from": "user@example.com"}`, http.StatusOK, "fail 2 false -all"},
+		{`{"ip": "203.0.113.1", "helo": "example.com"}`, http.StatusOK, "fail 2 true -all"},
+		{`{"ip": "not an ip", "mail_from": "user@example.com"}`, http.StatusBadRequest, ""},
+		{`{"ip": "192.0.2.1"}`, http.StatusBadRequest, ""},
+		{`{"ip": "192.0.2.1", "mailfrom": "user@example.com"}`, http.StatusBadRequest, ""},
+	}
+	for _, tst := range tests {
+		resp, err := http.Post(ts.URL+"/check", "application/json", strings.NewReader(tst.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tst.status {
+			resp.Body.Close()
+			t.Errorf("%s: expected status %d, got %d", tst.body, tst.status, resp.StatusCode)
+			continue
+		}
+		if tst.status != http.StatusOK {
+			var e errorJSON
+			err = json.NewDecoder(resp.Body).Decode(&e)
+			resp.Body.Close()
+			if err != nil || e.Error == "" {
+				t.Errorf("%s: expected error message, got %v", tst.body, err)
+			}
+			continue
+		}
+		var got spf.Result
+		err = json.NewDecoder(resp.Body).Decode(&got)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if summary := fmt.Sprintf("%s %d %t %s", got.Type, got.DNSQueries, got.UsedHelo, got.Mechanism); summary != tst.want {
+			t.Errorf("%s: expected %s, got %s", tst.body, tst.want, summary)
+		}
+	}
+
+	resp, err := http.Post(ts.URL+"/check", "application/json", strings.NewReader(`{"ip": "198.51.100.1", "mail_from": "user@example.com", "trace": true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var traced spf.Result
+	if err := json.NewDecoder(resp.Body).Decode(&traced); err != nil {
+		t.Fatal(err)
+	}
+	if traced.Type != spf.Pass || traced.Trace == nil || traced.Trace.Kind != spf.TraceRecord || traced.Trace.Domain != "example.com." {
+		t.Errorf("unexpected traced result %+v", traced)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	ts := testServer()
+	defer ts.Close()
+
+	tests := []struct {
+		domain string
+		status int
+	}{
+		{"example.com", http.StatusOK},
+		{"broken.example.com", http.StatusUnprocessableEntity},
+		{"two.example.com", http.StatusUnprocessableEntity},
+		{"missing.example.com", http.StatusNotFound},
+	}
+	for _, tst := range tests {
+		resp, err := http.Get(ts.URL + "/record/" + tst.domain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got recordJSON
+		err = json.NewDecoder(resp.Body).Decode(&got)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tst.status {
+			t.Errorf("%s: expected status %d, got %d", tst.domain, tst.status, resp.StatusCode)
+		}
+		if tst.status != http.StatusOK {
+			continue
+		}
+		if got.Parsed == nil || got.Parsed.String() != got.Record {
+			t.Errorf("%s: expected %s parsed, got %v", tst.domain, got.Record, got.Parsed)
+		}
+	}
+
+	resp, err := http.Get(ts.URL + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var health healthJSON
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		t.Fatal(err)
+	}
+	if health.Status != "ok" || health.Cache.Misses == 0 {
+		t.Errorf("unexpected health %+v", health)
+	}
+}
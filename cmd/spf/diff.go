@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/wttw/spf"
+)
+
+func diffCommand(args []string) {
+	var zones, oldZones, newZones zoneFiles
+	var text bool
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: spf diff [flags] old-domain new-domain\n")
+		fmt.Fprintf(out, "       spf diff -text [flags] domain old-record new-record\n")
+		fmt.Fprintf(out, "       spf diff -old-zone file -new-zone file [flags] domain\n")
+		fs.PrintDefaults()
+	}
+	fs.Var(&zones, "zone", "answer DNS queries from a zone file, given as file or origin=file, rather than the network (may be repeated)")
+	fs.BoolVar(&text, "text", false, "compare two versions of the text of a domain's record")
+	fs.Var(&oldZones, "old-zone", "zone file to read the old policy from, rather than the network (may be repeated)")
+	fs.Var(&newZones, "new-zone", "zone file to read the new policy from, rather than the network (may be repeated)")
+	_ = fs.Parse(args)
+
+	c := zones.checker()
+	ctx := context.Background()
+	var diff *spf.PolicyDiff
+	var err error
+	switch {
+	case text:
+		if fs.NArg() != 3 {
+			fs.Usage()
+			os.Exit(2)
+		}
+		diff, err = c.DiffRecords(ctx, fs.Arg(0), fs.Arg(1), fs.Arg(2))
+	case len(oldZones) > 0 || len(newZones) > 0:
+		if fs.NArg() != 1 {
+			fs.Usage()
+			os.Exit(2)
+		}
+		oldResolver, newResolver := c.Resolver, c.Resolver
+		if len(oldZones) > 0 {
+			oldResolver = oldZones.checker().Resolver
+		}
+		if len(newZones) > 0 {
+			newResolver = newZones.checker().Resolver
+		}
+		diff, err = c.DiffResolvers(ctx, fs.Arg(0), oldResolver, newResolver)
+	default:
+		if fs.NArg() != 2 {
+			fs.Usage()
+			os.Exit(2)
+		}
+		diff, err = c.DiffDomains(ctx, fs.Arg(0), fs.Arg(1))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	// Exit like diff(1); 0 if there are no differences, 1 if there are
+	fmt.Print(diff)
+	if !diff.Empty() {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/miekg/dns"
+	"github.com/wttw/spf"
+)
+
+func flattenCommand(args []string) {
+	var zones zoneFiles
+	var ttl uint
+	var split bool
+	var maxLength int
+	fs := flag.NewFlagSet("flatten", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: spf flatten [flags] domain\n")
+		fs.PrintDefaults()
+	}
+	fs.Var(&zones, "zone", "answer DNS queries from a zone file, given as file or origin=file, rather than the network (may be repeated)")
+	fs.UintVar(&ttl, "ttl", 3600, "TTL of the TXT records printed")
+	fs.BoolVar(&split, "split", false, "split a long record into several, chained with include")
+	fs.IntVar(&maxLength, "max", spf.DefaultMaxRecordLength, "maximum length of each record when splitting")
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	domain := dns.Fqdn(fs.Arg(0))
+
+	c := zones.checker()
+	record, err := c.Flatten(context.Background(), domain)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	records := []spf.PolicyRecord{{Name: domain, Record: record}}
+	if split {
+		records, err = spf.SplitRecord(domain, record, maxLength)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+	for _, r := range records {
+		fmt.Println(r.ZoneLine(uint32(ttl)))
+	}
+}
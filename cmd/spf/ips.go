@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/wttw/spf"
+)
+
+func ipsCommand(args []string) {
+	var zones zoneFiles
+	fs := flag.NewFlagSet("ips", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: spf ips [flags] domain\n")
+		fs.PrintDefaults()
+	}
+	fs.Var(&zones, "zone", "answer DNS queries from a zone file, given as file or origin=file, rather than the network (may be repeated)")
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	c := zones.checker()
+	policy, err := c.AuthorizedIPs(context.Background(), fs.Arg(0))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	for _, result := range []spf.ResultType{spf.Pass, spf.Softfail, spf.Neutral, spf.Fail} {
+		for _, prefix := range policy.Prefixes[result] {
+			fmt.Printf("%-8s %s\n", result, prefix)
+		}
+	}
+	fmt.Printf("%-8s everything else\n", policy.Default)
+	for _, term := range policy.Unexpandable {
+		fmt.Fprintf(os.Stderr, "not expanded: %s: %s (%s)\n", term.Domain, term.Term, term.Reason)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/logrusorgru/aurora"
+	"github.com/mattn/go-colorable"
+	"github.com/mattn/go-isatty"
+	"github.com/wttw/spf"
+)
+
+var severities = map[string]spf.Severity{
+	"info":    spf.SeverityInfo,
+	"warning": spf.SeverityWarning,
+	"error":   spf.SeverityError,
+}
+
+func lintCommand(args []string) {
+	var zones zoneFiles
+	var minSeverity string
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: spf lint [flags] domain\n")
+		fs.PrintDefaults()
+	}
+	fs.Var(&zones, "zone", "answer DNS queries from a zone file, given as file or origin=file, rather than the network (may be repeated)")
+	fs.StringVar(&minSeverity, "severity", "warning", "only show findings at least this severe: info, warning or error")
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	threshold, ok := severities[minSeverity]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown severity '%s'\n", minSeverity)
+		os.Exit(2)
+	}
+
+	c := zones.checker()
+	report := c.Lint(context.Background(), fs.Arg(0))
+
+	au := aurora.NewAurora(isatty.IsTerminal(os.Stdout.Fd()))
+	stdout := colorable.NewColorableStdout()
+	for _, f := range report.Findings {
+		if f.Severity < threshold {
+			continue
+		}
+		var severity aurora.Value
+		switch f.Severity {
+		case spf.SeverityError:
+			severity = au.BrightRed(f.Severity)
+		case spf.SeverityWarning:
+			severity = au.Yellow(f.Severity)
+		default:
+			severity = au.Blue(f.Severity)
+		}
+		where := f.Domain
+		if f.Term != "" {
+			where += ": " + f.Term
+		}
+		fmt.Fprintf(stdout, "%s: %s: %s [%s]\n", severity, where, f.Message, f.Code)
+	}
+	fmt.Fprintf(stdout, "%s: %d DNS lookups (limit %d), %d void lookups (limit %d)\n",
+		report.Domain, report.DNSLookups, c.DNSLimit, report.VoidLookups, c.VoidQueryLimit)
+
+	if report.Worst() == spf.SeverityError {
+		os.Exit(1)
+	}
+}
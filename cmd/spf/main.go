@@ -23,8 +23,58 @@ involved.
      	ip address from which the message is sent
    -mechanisms
     	show details about each mechanism
+   -record string
+     	save every DNS query and response to a file
+   -replay string
+     	answer DNS queries from a file saved with -record, rather than the network
    -trace
      	show evaluation of record
+   -zone value
+     	answer DNS queries from a zone file, given as file or origin=file, rather than the network (may be repeated)
+
+The -zone flag allows checking against BIND-style master files with no
+network access.
+
+ spf -zone example.com=db.example.com -ip 192.0.2.1 -from user@example.com
+
+The -record flag saves every DNS query made during the check, and its
+response, to a file. Checking again with -replay answers the same queries
+from that file, so a verdict can be reproduced exactly once DNS has changed.
+
+ spf -record dns.json -ip 192.0.2.1 -from user@example.com
+ spf -replay dns.json -ip 192.0.2.1 -from user@example.com -trace
+
+The lint subcommand checks a domain's SPF record, and every record it
+includes or redirects to, for problems. It exits with status 1 if any
+errors are found.
+
+ spf lint example.com
+ spf lint -zone db.example.com -severity info example.com
+
+The flatten subcommand prints a TXT record equivalent to a domain's SPF
+record, with includes, a and mx mechanisms replaced by the addresses they
+match.
+
+ spf flatten example.com
+
+If the flattened record is too long to publish the -split flag will divide
+it into several records, chained with include, printed as zone file lines.
+
+ spf flatten -split example.com
+
+The ips subcommand lists the addresses a domain's policy gives each result
+for, and any terms that can't be expanded without a message to check.
+
+ spf ips example.com
+
+The diff subcommand shows how the result for each address differs between
+two policies; those of two domains, two versions of the text of a record or
+one domain as seen in two sets of zone files. Like diff(1) it exits with
+status 1 if there are differences.
+
+ spf diff old.example.com new.example.com
+ spf diff -text example.com "v=spf1 mx -all" "v=spf1 a mx -all"
+ spf diff -old-zone db.example.com.old -new-zone db.example.com example.com
 */
 package main
 
@@ -48,15 +98,35 @@ import (
 
 
 
+// commands are subcommands, given as the first argument
+var commands = map[string]func(args []string){
+	"diff":    diffCommand,
+	"flatten": flattenCommand,
+	"ips":     ipsCommand,
+	"lint":    lintCommand,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			command(os.Args[2:])
+			return
+		}
+	}
+
 	var ip, from, domain, helo string
 	var trace, showDns, mechanisms bool
+	var zones zoneFiles
+	var record, replay string
 	flag.StringVar(&ip, "ip", "", "ip address from which the message is sent")
 	flag.StringVar(&from, "from", "", "821.From address")
 	flag.StringVar(&helo, "helo", "", "domain used in 821.HELO")
 	flag.BoolVar(&trace, "trace", false, "show evaluation of record")
 	flag.BoolVar(&showDns, "dns", false, "show dns queries")
 	flag.BoolVar(&mechanisms, "mechanisms", false, "show details about each mechanism")
+	flag.Var(&zones, "zone", "answer DNS queries from a zone file, given as file or origin=file, rather than the network (may be repeated)")
+	flag.StringVar(&record, "record", "", "save every DNS query and response to a file")
+	flag.StringVar(&replay, "replay", "", "answer DNS queries from a file saved with -record, rather than the network")
 	flag.Parse()
 
 	if ip == "" {
@@ -67,6 +137,10 @@ func main() {
 		log.Fatalln("-from is required")
 	}
 
+	if replay != "" && len(zones) > 0 {
+		log.Fatalln("-replay can't be used with -zone")
+	}
+
 	if domain == "" {
 		at := strings.LastIndex(from, "@")
 		domain = from[at+1:]
@@ -77,7 +151,8 @@ func main() {
 		log.Fatalf("'%s' doesn't look like an ip address", ip)
 	}
 
-	c := spf.NewChecker()
+	c := zones.checker()
+	finish := recordReplay(c, record, replay)
 	if trace {
 		au := aurora.NewAurora(isatty.IsTerminal(os.Stdout.Fd()))
 		stdout := colorable.NewColorableStdout()
@@ -91,6 +166,7 @@ func main() {
 	}
 	ctx := context.Background()
 	result := c.SPF(ctx, addr, from, helo)
+	finish()
 	fmt.Printf("Result: %v\nError:  %v\nExplanation: %s\n", result.Type, result.Error, result.Explanation)
 }
 
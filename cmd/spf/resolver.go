@@ -0,0 +1,84 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/wttw/spf"
+)
+
+// zoneFiles collects repeated -zone flags
+type zoneFiles []string
+
+func (z *zoneFiles) String() string {
+	return strings.Join(*z, ",")
+}
+
+func (z *zoneFiles) Set(s string) error {
+	*z = append(*z, s)
+	return nil
+}
+
+// resolver loads each zone file, given as either "file" or "origin=file",
+// into a ZoneResolver.
+func (z zoneFiles) resolver() (*spf.ZoneResolver, error) {
+	res := spf.NewZoneResolver()
+	for _, zone := range z {
+		origin := ""
+		filename := zone
+		if eq := strings.Index(zone, "="); eq != -1 {
+			origin, filename = zone[:eq], zone[eq+1:]
+		}
+		if err := res.LoadZoneFile(filename, origin); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// checker creates a Checker, using the zone files if any were given
+func (z zoneFiles) checker() *spf.Checker {
+	c := spf.NewChecker()
+	if len(z) > 0 {
+		res, err := z.resolver()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		c.Resolver = res
+	}
+	return c
+}
+
+// recordReplay answers the Checker's DNS queries from a recording if replay
+// is set, and records them if record is set. The returned function must be
+// called once checking is finished, to finish the recording.
+func recordReplay(c *spf.Checker, record, replay string) func() {
+	if replay != "" {
+		res, err := spf.LoadReplayResolver(replay)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		log.Printf("Replaying DNS recorded at %s", res.Recorded().Format(time.RFC3339))
+		c.Resolver = res
+	}
+	if record == "" {
+		return func() {}
+	}
+	f, err := os.Create(record)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	res := spf.NewRecordingResolver(c.Resolver, f)
+	c.Resolver = res
+	return func() {
+		err := res.Err()
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			log.Fatalf("Failed to write %s: %v", record, err)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package spf
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// PolicyDiff describes how the result a policy gives for each address has
+// changed between two versions of it.
+type PolicyDiff struct {
+	Old, New *IPPolicy
+
+	// Added holds the addresses that get each result from the new policy
+	// but didn't from the old one, and Removed those that used to but no
+	// longer do. An address whose result changed from fail to pass will be
+	// in both Added[Pass] and Removed[Fail]. Addresses matching no term get
+	// the policy's default result.
+	Added   map[ResultType][]netip.Prefix
+	Removed map[ResultType][]netip.Prefix
+
+	AddedIncludes   []string // domains only the new policy includes
+	RemovedIncludes []string // domains only the old policy includes
+
+	AddedUnexpandable   []OpaqueTerm // terms only the new policy can't expand
+	RemovedUnexpandable []OpaqueTerm // terms only the old policy can't expand
+}
+
+// DiffPolicies compares two policies.
+func DiffPolicies(oldPolicy, newPolicy *IPPolicy) *PolicyDiff {
+	ret := &PolicyDiff{
+		Old:     oldPolicy,
+		New:     newPolicy,
+		Added:   map[ResultType][]netip.Prefix{},
+		Removed: map[ResultType][]netip.Prefix{},
+	}
+	oldSets, newSets := oldPolicy.sets(), newPolicy.sets()
+	for _, result := range []ResultType{Pass, Fail, Softfail, Neutral} {
+		if added := newSets[result].subtract(oldSets[result]); !added.empty() {
+			ret.Added[result] = added.prefixes()
+		}
+		if removed := oldSets[result].subtract(newSets[result]); !removed.empty() {
+			ret.Removed[result] = removed.prefixes()
+		}
+	}
+	ret.AddedIncludes, ret.RemovedIncludes = diffStrings(oldPolicy.Includes, newPolicy.Includes)
+
+	key := func(t OpaqueTerm) string {
+		return t.Domain + " " + t.Term
+	}
+	oldTerms, newTerms := map[string]bool{}, map[string]bool{}
+	for _, t := range oldPolicy.Unexpandable {
+		oldTerms[key(t)] = true
+	}
+	for _, t := range newPolicy.Unexpandable {
+		newTerms[key(t)] = true
+		if !oldTerms[key(t)] {
+			ret.AddedUnexpandable = append(ret.AddedUnexpandable, t)
+		}
+	}
+	for _, t := range oldPolicy.Unexpandable {
+		if !newTerms[key(t)] {
+			ret.RemovedUnexpandable = append(ret.RemovedUnexpandable, t)
+		}
+	}
+	return ret
+}
+
+// diffStrings returns the strings only in b, and those only in a, sorted
+// and without duplicates.
+func diffStrings(a, b []string) ([]string, []string) {
+	inA, inB := map[string]bool{}, map[string]bool{}
+	for _, s := range a {
+		inA[s] = true
+	}
+	for _, s := range b {
+		inB[s] = true
+	}
+	var added, removed []string
+	for s := range inB {
+		if !inA[s] {
+			added = append(added, s)
+		}
+	}
+	for s := range inA {
+		if !inB[s] {
+			removed = append(removed, s)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// Empty returns whether the two policies give the same result for every
+// address, include the same domains and need the same number of lookups.
+func (d *PolicyDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 &&
+		len(d.AddedIncludes) == 0 && len(d.RemovedIncludes) == 0 &&
+		len(d.AddedUnexpandable) == 0 && len(d.RemovedUnexpandable) == 0 &&
+		d.Old.DNSLookups == d.New.DNSLookups
+}
+
+// String returns the differences in a line oriented format, with added
+// things prefixed by "+" and removed ones by "-".
+func (d *PolicyDiff) String() string {
+	var sb strings.Builder
+	for _, result := range []ResultType{Pass, Softfail, Neutral, Fail} {
+		for _, prefix := range d.Removed[result] {
+			fmt.Fprintf(&sb, "-%s %s\n", result, prefix)
+		}
+		for _, prefix := range d.Added[result] {
+			fmt.Fprintf(&sb, "+%s %s\n", result, prefix)
+		}
+	}
+	for _, include := range d.RemovedIncludes {
+		fmt.Fprintf(&sb, "-include %s\n", include)
+	}
+	for _, include := range d.AddedIncludes {
+		fmt.Fprintf(&sb, "+include %s\n", include)
+	}
+	for _, t := range d.RemovedUnexpandable {
+		fmt.Fprintf(&sb, "-unexpandable %s %s\n", t.Domain, t.Term)
+	}
+	for _, t := range d.AddedUnexpandable {
+		fmt.Fprintf(&sb, "+unexpandable %s %s\n", t.Domain, t.Term)
+	}
+	if d.Old.DNSLookups != d.New.DNSLookups {
+		fmt.Fprintf(&sb, "lookups %d -> %d\n", d.Old.DNSLookups, d.New.DNSLookups)
+	}
+	return sb.String()
+}
+
+// DiffDomains compares the policies of two domains.
+func (c *Checker) DiffDomains(ctx context.Context, oldDomain, newDomain string) (*PolicyDiff, error) {
+	oldPolicy, err := c.AuthorizedIPs(ctx, oldDomain)
+	if err != nil {
+		return nil, err
+	}
+	newPolicy, err := c.AuthorizedIPs(ctx, newDomain)
+	if err != nil {
+		return nil, err
+	}
+	return DiffPolicies(oldPolicy, newPolicy), nil
+}
+
+// DiffRecords compares two versions of the text of the SPF record for a
+// domain. Records they include are fetched from DNS.
+func (c *Checker) DiffRecords(ctx context.Context, domain string, oldRecord, newRecord string) (*PolicyDiff, error) {
+	oldPolicy, err := c.AuthorizedIPsForRecord(ctx, domain, oldRecord)
+	if err != nil {
+		return nil, err
+	}
+	newPolicy, err := c.AuthorizedIPsForRecord(ctx, domain, newRecord)
+	if err != nil {
+		return nil, err
+	}
+	return DiffPolicies(oldPolicy, newPolicy), nil
+}
+
+// DiffResolvers compares the policy of a domain as seen through two
+// resolvers, such as a ZoneResolver loaded with an old copy of a zone and
+// the live DNS.
+func (c *Checker) DiffResolvers(ctx context.Context, domain string, oldResolver, newResolver Resolver) (*PolicyDiff, error) {
+	oldChecker, newChecker := *c, *c
+	oldChecker.Resolver = oldResolver
+	newChecker.Resolver = newResolver
+	oldPolicy, err := oldChecker.AuthorizedIPs(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+	newPolicy, err := newChecker.AuthorizedIPs(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+	return DiffPolicies(oldPolicy, newPolicy), nil
+}
@@ -0,0 +1,76 @@
+package spf_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/wttw/spf"
+	"github.com/wttw/spf/spftest"
+)
+
+func TestDiffRecords(t *testing.T) {
+	res := spftest.NewResolver().
+		TXT("_spf.vendor.com", "v=spf1 ip4:198.51.100.0/24 -all").
+		TXT("_spf.other.com", "v=spf1 a:mail.other.com -all").
+		A("mail.other.com", "203.0.113.5")
+	c := spftest.NewChecker(res)
+
+	diff, err := c.DiffRecords(context.Background(), "example.com",
+		"v=spf1 ip4:192.0.2.0/24 include:_spf.vendor.com -all",
+		"v=spf1 ip4:192.0.2.0/25 include:_spf.other.com -all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `-pass 192.0.2.128/25
+-pass 198.51.100.0/24
++pass 203.0.113.5/32
+-fail 203.0.113.5/32
++fail 192.0.2.128/25
++fail 198.51.100.0/24
+-include _spf.vendor.com.
++include _spf.other.com.
+lookups 1 -> 2
+`
+	if diff.String() != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, diff.String())
+	}
+	if diff.Empty() {
+		t.Errorf("expected a non-empty diff")
+	}
+
+	diff, err = c.DiffRecords(context.Background(), "example.com",
+		"v=spf1 ip4:192.0.2.0/25 ip4:192.0.2.128/25 -all",
+		"v=spf1 ip4:192.0.2.0/24 -ip4:10.0.0.0/8 -all")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !diff.Empty() {
+		t.Errorf("expected equivalent records to have an empty diff, got\n%s", diff)
+	}
+}
+
+func TestDiffResolvers(t *testing.T) {
+	before := spftest.NewResolver().
+		TXT("example.com", "v=spf1 include:_spf.vendor.com ~all").
+		TXT("_spf.vendor.com", "v=spf1 ip4:198.51.100.0/24 -all")
+	after := spftest.NewResolver().
+		TXT("example.com", "v=spf1 include:_spf.vendor.com ~all").
+		TXT("_spf.vendor.com", "v=spf1 ip4:198.51.100.0/24 ip6:2001:db8::/32 -all")
+	c := spftest.NewChecker(before)
+
+	diff, err := c.DiffResolvers(context.Background(), "example.com", before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	added := diff.Added[spf.Pass]
+	if len(added) != 1 || added[0].String() != "2001:db8::/32" {
+		t.Errorf("expected 2001:db8::/32 to be added to pass, got %v", added)
+	}
+	removed := diff.Removed[spf.Softfail]
+	if len(removed) != 1 || removed[0].String() != "2001:db8::/32" {
+		t.Errorf("expected 2001:db8::/32 to be removed from softfail, got %v", removed)
+	}
+	if len(diff.AddedIncludes) != 0 || len(diff.RemovedIncludes) != 0 {
+		t.Errorf("expected no include changes, got %v %v", diff.AddedIncludes, diff.RemovedIncludes)
+	}
+}
@@ -2,7 +2,6 @@ package spf
 
 import (
 	"context"
-	"fmt"
 	"github.com/miekg/dns"
 	"net"
 	"regexp"
@@ -13,12 +12,12 @@ import (
 var spfPrefixRe = regexp.MustCompile(`(?i)^v=spf1(?: |$)`)
 
 // Gets a single SPF record for a domain, as a single string
-func (c *Checker) getSPFRecord(ctx context.Context, domain string) (string, ResultType, error) {
+func (c *Checker) getSPFRecord(ctx context.Context, result *Result, domain string) (string, ResultType, error) {
 	r := &dns.Msg{}
 	r.SetQuestion(dns.Fqdn(domain), dns.TypeTXT)
-	m, err := c.resolve(ctx, r)
+	m, err := c.resolve(ctx, result, r)
 	if err != nil {
-		return "", Temperror, err
+		return "", Temperror, &DNSError{Name: r.Question[0].Name, Qtype: dns.TypeTXT, Rcode: -1, Err: err}
 	}
 	// 4.4. Record Lookup (RFC 7208)
 	//  If the DNS lookup returns a server failure (RCODE 2) or some other
@@ -27,7 +26,7 @@ func (c *Checker) getSPFRecord(ctx context.Context, domain string) (string, Resu
 	switch m.Rcode {
 	case dns.RcodeSuccess, dns.RcodeNameError:
 	default:
-		return "", Temperror, nil
+		return "", Temperror, &DNSError{Name: r.Question[0].Name, Qtype: dns.TypeTXT, Rcode: m.Rcode}
 	}
 
 	// 4.5.  Selecting Records (RFC 7208)
@@ -67,10 +66,25 @@ func (c *Checker) getSPFRecord(ctx context.Context, domain string) (string, Resu
 	case 1:
 		return spfRecords[0], None, nil
 	default:
-		return "", Permerror, nil
+		return "", Permerror, &RecordError{Count: len(spfRecords), Location: Location{Domain: domain}}
 	}
 }
 
+// LookupSPF fetches the text of the SPF record for a domain. It returns an
+// empty string if the domain has no SPF record, a *RecordError if it has
+// more than one and a *DNSError if the DNS lookup fails.
+func (c *Checker) LookupSPF(ctx context.Context, domain string) (string, error) {
+	domain, err := toASCII(domain)
+	if err != nil {
+		return "", err
+	}
+	record, _, err := c.getSPFRecord(ctx, &Result{c: c}, domain)
+	if err != nil {
+		return "", err
+	}
+	return record, nil
+}
+
 var validDomainSuffix = regexp.MustCompile(`(?i)\.([a-z0-9][a-z0-9-]*[a-z0-9])\.?$`)
 var allNumeric = regexp.MustCompile(`^[0-9]*$`)
 
@@ -137,22 +151,22 @@ func validDomainSpec(domainSpec string) bool {
 func (c *Checker) lookupDNS(ctx context.Context, hostname string, qtype uint16, result *Result) ([]dns.RR, ResultType, error) {
 	r := &dns.Msg{}
 	r.SetQuestion(dns.Fqdn(hostname), qtype)
-	m, err := c.resolve(ctx, r)
+	m, err := c.resolve(ctx, result, r)
 	if err != nil {
-		return []dns.RR{}, Temperror, err
+		return []dns.RR{}, Temperror, &DNSError{Name: r.Question[0].Name, Qtype: qtype, Rcode: -1, Err: err}
 	}
 
 	if m.Rcode == dns.RcodeNameError || (m.Rcode == dns.RcodeSuccess && len(m.Answer) == 0) {
 		// NXDOMAIN or zero records
 		result.VoidLookups++
 		if result.VoidLookups > c.VoidQueryLimit {
-			return []dns.RR{}, Permerror, fmt.Errorf("void queries exceeded limit of %d", c.VoidQueryLimit)
+			return []dns.RR{}, Permerror, &LimitError{Err: ErrVoidLimit, Limit: c.VoidQueryLimit}
 		}
 		return []dns.RR{}, None, nil
 	}
 
 	if m.Rcode != dns.RcodeSuccess {
-		return []dns.RR{}, Temperror, nil
+		return []dns.RR{}, Temperror, &DNSError{Name: r.Question[0].Name, Qtype: qtype, Rcode: m.Rcode}
 	}
 
 	ret := make([]dns.RR, 0, len(m.Answer))
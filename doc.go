@@ -4,11 +4,48 @@ messages passes a published SPF (Sender Policy Framework) policy.
 
 It implements all of the SPF checker protocol as described in RFC 7208, including
 macros and PTR checks, and passes 100% of the openspf and pyspf test suites.
+Internationalized domains and local-parts are handled as described in RFC 8616.
 
-A DNS stub resolver is included, but can be replaced by anything that implements
-the spf.Resolver interface.
+Setting Checker.Prefetch makes the DNS queries each record's terms will need
+in parallel, ahead of evaluating them, which speeds up checking records with
+many includes. Evaluation is otherwise unchanged, so results, limits and
+hooks are the same as without it. Checker.CheckMany checks a stream of
+messages several at a time, sharing identical DNS queries between checks.
+
+A DNS stub resolver configured from resolv.conf is included, along with
+DNS-over-HTTPS (DoHResolver) and DNS-over-TLS (DoTResolver) resolvers and
+NetResolver, which uses the system resolver through net.Resolver, but can be
+replaced by anything that implements the spf.Resolver interface.
+RecordingResolver saves every query and response another Resolver makes to a
+file, and ReplayResolver answers from that file later, so that a check can be
+repeated exactly after DNS has changed.
 
 The Hook interface can be used to hook into the check_host function to see more
-details about why a policy passes or fails.
+details about why a policy passes or fails. Alternatively setting Checker.Trace
+will attach a tree of every record, mechanism, DNS query and macro expansion
+involved in reaching a verdict to the Result.
+
+A Result can be written as an RFC 8601 Authentication-Results header, and
+NewAuthenticationResults combines the results of checking the HELO and MAIL
+FROM identities into one. ParseAuthenticationResults reads back headers
+added by other hosts. ReceivedSPF and ParseReceivedSPF do the same for the
+RFC 7208 Received-SPF header, which also records which mechanism matched.
+Result.SMTPReply gives the reply code, RFC 7372 enhanced status code and
+text to send to the client, using the domain's explanation when it fails.
+Results, SPFRecords and Mechanisms can be encoded as JSON and decoded again,
+so that verdicts can be stored or passed between processes.
+Errors in a Result can be inspected with errors.Is and errors.As: there are
+sentinels such as ErrDNSLimit and ErrMultipleRecords, and error types such as
+LimitError and DNSError that record the domain and mechanism involved.
+
+ParseSPF parses the text of a record, stopping at the first problem.
+ParseSPFLenient carries on past bad terms, reporting each problem with its
+position in the record and a suggested fix where there's an obvious one.
+
+As well as checking messages a Checker can examine a domain's whole policy.
+Lint reports problems with it, and Flatten replaces the terms that need DNS
+lookups with the addresses they match. AuthorizedIPs lists the addresses that
+get each result, and DiffPolicies compares two policies. SplitRecord divides a record that's
+too long to publish into several chained with include.
 */
 package spf
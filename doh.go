@@ -0,0 +1,122 @@
+package spf
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// dohMediaType is the media type of DNS wire format messages, RFC 8484 section 6
+const dohMediaType = "application/dns-message"
+
+// dohMaxResponse is the largest response body a DoHResolver will accept
+const dohMaxResponse = 65535
+
+var _ Resolver = &DoHResolver{}
+
+// DoHResolver is a Resolver that sends queries to a DNS-over-HTTPS server,
+// as described in RFC 8484.
+type DoHResolver struct {
+	URL    string       // URL of the server, e.g. https://dns.example.net/dns-query
+	Method string       // http.MethodGet or http.MethodPost, POST if empty
+	Client *http.Client // client used for requests, http.DefaultClient if nil
+}
+
+// Resolve sends a query to the DoH server and returns the response. Connections
+// are reused by the underlying http.Client.
+func (res *DoHResolver) Resolve(ctx context.Context, r *dns.Msg) (*dns.Msg, error) {
+	// 4.1.  The HTTP Request (RFC 8484)
+	//  In order to maximize HTTP cache friendliness, DoH clients using media
+	//  formats that include the ID field from the DNS message header, such
+	//  as "application/dns-message", SHOULD use a DNS ID of 0 in every DNS
+	//  request.
+	q := r.Copy()
+	q.Id = 0
+	packed, err := q.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack DoH request: %w", err)
+	}
+
+	var req *http.Request
+	switch res.Method {
+	case http.MethodGet:
+		u, err := url.Parse(res.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DoH URL %s: %w", res.URL, err)
+		}
+		values := u.Query()
+		values.Set("dns", base64.RawURLEncoding.EncodeToString(packed))
+		u.RawQuery = values.Encode()
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+	case http.MethodPost, "":
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, res.URL, bytes.NewReader(packed))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", dohMediaType)
+	default:
+		return nil, fmt.Errorf("unsupported DoH method %s", res.Method)
+	}
+	req.Header.Set("Accept", dohMediaType)
+
+	client := res.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("DoH server %s returned %s", res.URL, resp.Status)
+	}
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != dohMediaType {
+		return nil, fmt.Errorf("DoH server %s returned unexpected content type '%s'", res.URL, resp.Header.Get("Content-Type"))
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dohMaxResponse+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > dohMaxResponse {
+		return nil, fmt.Errorf("DoH response from %s is too large", res.URL)
+	}
+
+	m := &dns.Msg{}
+	err = m.Unpack(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unpack DoH response: %w", err)
+	}
+	if !questionMatches(r, m) {
+		return nil, fmt.Errorf("DoH response from %s doesn't match the question", res.URL)
+	}
+	m.Id = r.Id
+	return m, nil
+}
+
+// questionMatches checks that a response is an answer to the query
+func questionMatches(r, m *dns.Msg) bool {
+	if len(r.Question) != len(m.Question) {
+		return false
+	}
+	for i, q := range r.Question {
+		a := m.Question[i]
+		if q.Qtype != a.Qtype || q.Qclass != a.Qclass || !strings.EqualFold(q.Name, a.Name) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,94 @@
+package spf_test
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/wttw/spf"
+)
+
+// answerA replies to any query with a single A record
+func answerA(r *dns.Msg) *dns.Msg {
+	m := &dns.Msg{}
+	m.SetReply(r)
+	m.Answer = []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+		A:   net.ParseIP("192.0.2.1"),
+	}}
+	return m
+}
+
+func TestDoHResolver(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var packed []byte
+		var err error
+		switch req.Method {
+		case http.MethodGet:
+			packed, err = base64.RawURLEncoding.DecodeString(req.URL.Query().Get("dns"))
+		case http.MethodPost:
+			if req.Header.Get("Content-Type") != "application/dns-message" {
+				http.Error(w, "bad content type", http.StatusUnsupportedMediaType)
+				return
+			}
+			packed, err = io.ReadAll(req.Body)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r := &dns.Msg{}
+		if err := r.Unpack(packed); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.Id != 0 {
+			http.Error(w, "expected DNS ID of 0", http.StatusBadRequest)
+			return
+		}
+		if r.Question[0].Name == "slow.example.com." {
+			<-req.Context().Done()
+			return
+		}
+		out, _ := answerA(r).Pack()
+		w.Header().Set("Content-Type", "application/dns-message")
+		_, _ = w.Write(out)
+	}))
+	defer srv.Close()
+
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		t.Run(method, func(t *testing.T) {
+			res := &spf.DoHResolver{
+				URL:    srv.URL + "/dns-query",
+				Method: method,
+				Client: srv.Client(),
+			}
+			r := &dns.Msg{}
+			r.SetQuestion("example.com.", dns.TypeA)
+			m, err := res.Resolve(context.Background(), r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.Id != r.Id {
+				t.Errorf("response id %d doesn't match query id %d", m.Id, r.Id)
+			}
+			if len(m.Answer) != 1 {
+				t.Errorf("expected 1 answer, got %d", len(m.Answer))
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			r.SetQuestion("slow.example.com.", dns.TypeA)
+			_, err = res.Resolve(ctx, r)
+			if err == nil {
+				t.Errorf("expected an error when the context is cancelled")
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package spf
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DoTPort is the well-known port for DNS-over-TLS, RFC 7858
+const DoTPort = "853"
+
+// dotMaxIdle is the number of idle connections a DoTResolver keeps open
+const dotMaxIdle = 4
+
+var _ Resolver = &DoTResolver{}
+
+// DoTResolver is a Resolver that sends queries to a DNS-over-TLS server, as
+// described in RFC 7858. Connections are kept open and reused for later
+// queries.
+//
+// A DoTResolver is safe for concurrent use by multiple goroutines.
+type DoTResolver struct {
+	Server    string        // host:port of the server, port 853 if omitted
+	TLSConfig *tls.Config   // ServerName defaults to the host part of Server
+	Timeout   time.Duration // timeout for each query if the context has no deadline
+
+	mu   sync.Mutex
+	idle []*dns.Conn
+}
+
+// Resolve sends a query to the DoT server and returns the response.
+func (res *DoTResolver) Resolve(ctx context.Context, r *dns.Msg) (*dns.Msg, error) {
+	conn, reused, err := res.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m, err := res.exchange(ctx, conn, r)
+	if err != nil && reused && ctx.Err() == nil {
+		// The server may have closed an idle connection, so try again on a
+		// fresh one.
+		conn.Close()
+		conn, err = res.dial(ctx)
+		if err != nil {
+			return nil, err
+		}
+		m, err = res.exchange(ctx, conn, r)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	res.release(conn)
+	return m, nil
+}
+
+// Close closes any idle connections to the server.
+func (res *DoTResolver) Close() error {
+	res.mu.Lock()
+	idle := res.idle
+	res.idle = nil
+	res.mu.Unlock()
+	var err error
+	for _, conn := range idle {
+		err = errors.Join(err, conn.Close())
+	}
+	return err
+}
+
+// conn returns an idle connection if there is one, or dials a new one.
+func (res *DoTResolver) conn(ctx context.Context) (*dns.Conn, bool, error) {
+	res.mu.Lock()
+	if n := len(res.idle); n > 0 {
+		conn := res.idle[n-1]
+		res.idle = res.idle[:n-1]
+		res.mu.Unlock()
+		return conn, true, nil
+	}
+	res.mu.Unlock()
+	conn, err := res.dial(ctx)
+	return conn, false, err
+}
+
+// release returns a connection to the idle pool.
+func (res *DoTResolver) release(conn *dns.Conn) {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	if len(res.idle) >= dotMaxIdle {
+		conn.Close()
+		return
+	}
+	res.idle = append(res.idle, conn)
+}
+
+func (res *DoTResolver) dial(ctx context.Context) (*dns.Conn, error) {
+	server := res.Server
+	host, _, err := net.SplitHostPort(server)
+	if err != nil {
+		host = server
+		server = net.JoinHostPort(server, DoTPort)
+	}
+	var config *tls.Config
+	if res.TLSConfig != nil {
+		config = res.TLSConfig.Clone()
+	} else {
+		config = &tls.Config{}
+	}
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: res.Timeout},
+		Config:    config,
+	}
+	c, err := dialer.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to DoT server %s: %w", server, err)
+	}
+	return &dns.Conn{Conn: c}, nil
+}
+
+// exchange sends a single query on conn and waits for the response. The
+// exchange is abandoned if ctx is cancelled.
+func (res *DoTResolver) exchange(ctx context.Context, conn *dns.Conn, r *dns.Msg) (*dns.Msg, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok && res.Timeout > 0 {
+		deadline = time.Now().Add(res.Timeout)
+	}
+	err := conn.SetDeadline(deadline)
+	if err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		// Unblock any read or write in progress
+		_ = conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	q := r.Copy()
+	q.Id = dns.Id()
+	err = conn.WriteMsg(q)
+	if err == nil {
+		var m *dns.Msg
+		m, err = conn.ReadMsg()
+		if err == nil {
+			if m.Id != q.Id || !questionMatches(q, m) {
+				return nil, fmt.Errorf("DoT response from %s doesn't match the question", res.Server)
+			}
+			m.Id = r.Id
+			return m, nil
+		}
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return nil, err
+}
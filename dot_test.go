@@ -0,0 +1,84 @@
+package spf_test
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/wttw/spf"
+)
+
+func TestDoTResolver(t *testing.T) {
+	// Borrow the test certificate from httptest
+	https := httptest.NewTLSServer(http.NotFoundHandler())
+	defer https.Close()
+	roots := https.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: https.TLS.Certificates})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	connections := map[string]bool{}
+	srv := &dns.Server{
+		Listener: ln,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			mu.Lock()
+			connections[w.RemoteAddr().String()] = true
+			mu.Unlock()
+			if r.Question[0].Name == "slow.example.com." {
+				time.Sleep(time.Second)
+			}
+			_ = w.WriteMsg(answerA(r))
+		}),
+	}
+	go func() {
+		_ = srv.ActivateAndServe()
+	}()
+	defer srv.Shutdown()
+
+	res := &spf.DoTResolver{
+		Server:    ln.Addr().String(),
+		TLSConfig: &tls.Config{RootCAs: roots},
+		Timeout:   5 * time.Second,
+	}
+	defer res.Close()
+
+	for i := 0; i < 3; i++ {
+		r := &dns.Msg{}
+		r.SetQuestion("example.com.", dns.TypeA)
+		m, err := res.Resolve(context.Background(), r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Id != r.Id {
+			t.Errorf("response id %d doesn't match query id %d", m.Id, r.Id)
+		}
+		if len(m.Answer) != 1 {
+			t.Errorf("expected 1 answer, got %d", len(m.Answer))
+		}
+	}
+	mu.Lock()
+	if len(connections) != 1 {
+		t.Errorf("expected queries to share 1 connection, used %d", len(connections))
+	}
+	mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	r := &dns.Msg{}
+	r.SetQuestion("slow.example.com.", dns.TypeA)
+	start := time.Now()
+	_, err = res.Resolve(ctx, r)
+	if err == nil {
+		t.Errorf("expected an error when the context is cancelled")
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("cancelled query took %v", time.Since(start))
+	}
+}
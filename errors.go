@@ -0,0 +1,198 @@
+package spf
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+// Errors returned in Result.Error can be inspected with errors.Is, using
+// these sentinels, or with errors.As, using the error types below for more
+// detail.
+var (
+	ErrDNSLimit        = errors.New("dns query limit exceeded")
+	ErrVoidLimit       = errors.New("void query limit exceeded")
+	ErrMXLimit         = errors.New("mx address limit exceeded")
+	ErrMultipleRecords = errors.New("multiple spf records")
+	ErrNoRecord        = errors.New("no spf record")
+	ErrInvalidDomain   = errors.New("invalid domain name")
+	ErrMacroSyntax     = errors.New("invalid macro")
+	ErrSyntax          = errors.New("invalid spf record")
+	ErrDNS             = errors.New("dns lookup failed")
+)
+
+// Location is where in the evaluation of a policy an error happened. It's
+// embedded in each of the error types.
+type Location struct {
+	Domain    string    // the domain whose record was being evaluated
+	Mechanism Mechanism // the mechanism being evaluated, nil if it was the record itself
+}
+
+func (l *Location) locate(domain string, m Mechanism) {
+	if l.Domain == "" {
+		l.Domain = domain
+		l.Mechanism = m
+	}
+}
+
+// locator is implemented by errors that embed a Location
+type locator interface {
+	locate(domain string, m Mechanism)
+}
+
+// locate records the domain and mechanism being evaluated in every error in
+// err's chain that doesn't already know where it happened.
+func locate(err error, domain string, m Mechanism) error {
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if l, ok := e.(locator); ok {
+			l.locate(domain, m)
+		}
+	}
+	return err
+}
+
+// LimitError is returned when evaluating a policy exceeds one of the
+// Checker's limits. Err is ErrDNSLimit, ErrVoidLimit or ErrMXLimit.
+type LimitError struct {
+	Err    error
+	Limit  int
+	Target string // the name whose MX records exceeded the limit, for ErrMXLimit
+	Location
+}
+
+func (e *LimitError) Error() string {
+	switch e.Err {
+	case ErrDNSLimit:
+		return fmt.Sprintf("limit of %d dns queries exceeded", e.Limit)
+	case ErrVoidLimit:
+		return fmt.Sprintf("void queries exceeded limit of %d", e.Limit)
+	case ErrMXLimit:
+		return fmt.Sprintf("limit of %d MX results exceeded for %s", e.Limit, e.Target)
+	}
+	return fmt.Sprintf("%v (limit %d)", e.Err, e.Limit)
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// RecordError is returned when a domain publishes more than one SPF record,
+// or none where one is required, as the target of an include or redirect.
+type RecordError struct {
+	Count int // how many SPF records were found
+	Location
+}
+
+func (e *RecordError) Error() string {
+	if e.Count == 0 {
+		return fmt.Sprintf("%s has no SPF record", e.Domain)
+	}
+	return fmt.Sprintf("%s has %d SPF records", e.Domain, e.Count)
+}
+
+func (e *RecordError) Is(target error) bool {
+	if e.Count == 0 {
+		return target == ErrNoRecord
+	}
+	return target == ErrMultipleRecords
+}
+
+// DomainError is returned when a domain, or the expansion of a
+// domain-spec, isn't a valid domain name.
+type DomainError struct {
+	Name string // the invalid name
+	Err  error  // why it's invalid, if known
+	Location
+}
+
+func (e *DomainError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("invalid domain name '%s': %v", e.Name, e.Err)
+	}
+	return fmt.Sprintf("invalid domain name '%s'", e.Name)
+}
+
+func (e *DomainError) Is(target error) bool {
+	return target == ErrInvalidDomain
+}
+
+func (e *DomainError) Unwrap() error {
+	return e.Err
+}
+
+// MacroError is returned when a macro-string can't be expanded.
+type MacroError struct {
+	Macro  string // the macro-string
+	Offset int    // byte offset of the problem in Macro
+	Reason string
+	Location
+}
+
+func (e *MacroError) Error() string {
+	return fmt.Sprintf("%s at offset %d in macro '%s'", e.Reason, e.Offset, e.Macro)
+}
+
+func (e *MacroError) Is(target error) bool {
+	return target == ErrMacroSyntax
+}
+
+// SyntaxError is returned when an SPF record can't be parsed.
+type SyntaxError struct {
+	Term string // the term that couldn't be parsed, empty if it's the record as a whole
+	Err  error
+	Location
+}
+
+func (e *SyntaxError) Error() string {
+	if e.Term == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("In field '%s': %v", e.Term, e.Err)
+}
+
+func (e *SyntaxError) Is(target error) bool {
+	return target == ErrSyntax
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
+
+// DNSError is returned when a DNS query fails, either because the resolver
+// returned an error or because the server responded with an rcode other
+// than NOERROR or NXDOMAIN.
+type DNSError struct {
+	Name  string
+	Qtype uint16
+	Rcode int   // the response code, or -1 if there was no response
+	Err   error // the error from the Resolver, if any
+	Location
+}
+
+func (e *DNSError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s lookup for %s failed: %v", dns.Type(e.Qtype), e.Name, e.Err)
+	}
+	return fmt.Sprintf("%s lookup for %s failed: %s", dns.Type(e.Qtype), e.Name, dns.RcodeToString[e.Rcode])
+}
+
+func (e *DNSError) Is(target error) bool {
+	return target == ErrDNS
+}
+
+func (e *DNSError) Unwrap() error {
+	return e.Err
+}
+
+// publicError describes err for text that leaves this host, in an SMTP
+// reply or a header field added to the message. A failed DNS query is
+// described by its question alone, as the resolver's error may say more
+// about the local network than should be given away.
+func publicError(err error) string {
+	var dnsErr *DNSError
+	if errors.As(err, &dnsErr) {
+		return fmt.Sprintf("%s lookup for %s failed", dns.Type(dnsErr.Qtype), dnsErr.Name)
+	}
+	return err.Error()
+}
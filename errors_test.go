@@ -0,0 +1,119 @@
+package spf_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/wttw/spf"
+	"github.com/wttw/spf/spftest"
+)
+
+func TestErrors(t *testing.T) {
+	res := spftest.NewResolver().
+		TXT("lookups.example.com", "v=spf1 a:a1.example.com a:a2.example.com a:a3.example.com a:a4.example.com a:a5.example.com a:a6.example.com a:a7.example.com a:a8.example.com a:a9.example.com a:a10.example.com a:a11.example.com -all").
+		TXT("void.example.com", "v=spf1 a:v1.example.com a:v2.example.com a:v3.example.com -all").
+		TXT("mx.example.com", "v=spf1 mx -all").
+		TXT("two.example.com", "v=spf1 -all", "v=spf1 +all").
+		TXT("include.example.com", "v=spf1 include:%{c}.example.com -all").
+		TXT("exp.example.com", "v=spf1 -all exp=%{c}.example.com").
+		TXT("servfail.example.com", "v=spf1 a:broken.example.com -all").
+		TXT("timeout.example.com", "v=spf1 a -all").
+		TXT("syntax.example.com", "v=spf1 ip4:192.0.2.0/33 -all").
+		TXT("nested.example.com", "v=spf1 include:servfail.example.com -all").
+		TXT("noinclude.example.com", "v=spf1 include:missing.example.com -all").
+		TXT("noredirect.example.com", "v=spf1 redirect=missing.example.com").
+		ServFail("broken.example.com").
+		Timeout("timeout.example.com", 1)
+	for i := 0; i <= 10; i++ {
+		host := "mx" + strconv.Itoa(i) + ".example.com"
+		res.MX("mx.example.com", uint16(i), host).A(host, "192.0.2.1")
+		res.A("a"+strconv.Itoa(i+1)+".example.com", "192.0.2.1")
+	}
+	c := spftest.NewChecker(res)
+
+	tests := []struct {
+		domain    string
+		sentinel  error
+		mechanism string
+	}{
+		{"lookups.example.com", spf.ErrDNSLimit, "a:a10.example.com"},
+		{"void.example.com", spf.ErrVoidLimit, "a:v3.example.com"},
+		{"mx.example.com", spf.ErrMXLimit, "mx"},
+		{"two.example.com", spf.ErrMultipleRecords, ""},
+		{"servfail.example.com", spf.ErrDNS, "a:broken.example.com"},
+		{"timeout.example.com", spf.ErrDNS, "a"},
+		{"syntax.example.com", spf.ErrSyntax, ""},
+	}
+	for _, tst := range tests {
+		result := c.SPF(context.Background(), []byte{198, 51, 100, 1}, "user@"+tst.domain, "")
+		if !errors.Is(result.Error, tst.sentinel) {
+			t.Errorf("%s: expected %v, got %v", tst.domain, tst.sentinel, result.Error)
+			continue
+		}
+		var domain string
+		var mechanism spf.Mechanism
+		var le *spf.LimitError
+		var re *spf.RecordError
+		var de *spf.DNSError
+		var se *spf.SyntaxError
+		switch {
+		case errors.As(result.Error, &le):
+			domain, mechanism = le.Domain, le.Mechanism
+		case errors.As(result.Error, &re):
+			domain, mechanism = re.Domain, re.Mechanism
+		case errors.As(result.Error, &de):
+			domain, mechanism = de.Domain, de.Mechanism
+		case errors.As(result.Error, &se):
+			domain, mechanism = se.Domain, se.Mechanism
+		}
+		if domain != tst.domain+"." {
+			t.Errorf("%s: expected error in %s., got %s", tst.domain, tst.domain, domain)
+		}
+		var got string
+		if mechanism != nil {
+			got = mechanism.String()
+		}
+		if got != tst.mechanism {
+			t.Errorf("%s: expected error from '%s', got '%s'", tst.domain, tst.mechanism, got)
+		}
+	}
+
+	result := c.SPF(context.Background(), []byte{198, 51, 100, 1}, "user@timeout.example.com", "")
+	if !errors.Is(result.Error, spftest.ErrTimeout) {
+		t.Errorf("expected resolver error to be wrapped, got %v", result.Error)
+	}
+
+	for _, domain := range []string{"include.example.com", "exp.example.com"} {
+		result := c.SPF(context.Background(), []byte{198, 51, 100, 1}, "user@"+domain, "")
+		var me *spf.MacroError
+		if !errors.Is(result.Error, spf.ErrMacroSyntax) || !errors.As(result.Error, &me) {
+			t.Errorf("%s: expected macro error, got %v", domain, result.Error)
+			continue
+		}
+		if me.Offset != 0 {
+			t.Errorf("%s: expected offset 0, got %d in %v", domain, me.Offset, me)
+		}
+	}
+
+	// Errors in included records are kept, where they happened
+	result = c.SPF(context.Background(), []byte{198, 51, 100, 1}, "user@nested.example.com", "")
+	var de *spf.DNSError
+	if result.Type != spf.Temperror || !errors.As(result.Error, &de) || de.Domain != "servfail.example.com." {
+		t.Errorf("expected the included record's DNS error, got %s %v", result.Type, result.Error)
+	}
+
+	for _, domain := range []string{"noinclude.example.com", "noredirect.example.com"} {
+		result := c.SPF(context.Background(), []byte{198, 51, 100, 1}, "user@"+domain, "")
+		var re *spf.RecordError
+		if result.Type != spf.Permerror || !errors.Is(result.Error, spf.ErrNoRecord) || !errors.As(result.Error, &re) || re.Domain != "missing.example.com." {
+			t.Errorf("%s: expected no record at missing.example.com., got %s %v", domain, result.Type, result.Error)
+		}
+	}
+
+	result = c.SPF(context.Background(), []byte{198, 51, 100, 1}, "user@example..com", "")
+	if !errors.Is(result.Error, spf.ErrInvalidDomain) {
+		t.Errorf("expected invalid domain, got %v", result.Error)
+	}
+}
@@ -0,0 +1,403 @@
+package spf
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// flatTerm is a mechanism with its DNS lookups done, so that it's either a
+// set of addresses or something that can't be expanded without a message to
+// check.
+type flatTerm struct {
+	qualifier ResultType
+	set       ipSet     // the addresses the term matches
+	all       bool      // an "all" mechanism, matching every address
+	opaque    bool      // depends on the message being checked
+	reason    string    // why the term is opaque
+	mechanism Mechanism // the original mechanism
+	domain    string    // the domain whose record the term is from
+}
+
+// flatRecord is an SPF record with the DNS lookups for its terms done, and
+// those of any record it redirects to.
+type flatRecord struct {
+	domain   string
+	record   *SPFRecord
+	terms    []flatTerm
+	redirect string // the redirect modifier, if it couldn't be followed
+	opaque   bool   // whether any term is opaque, or the redirect wasn't followed
+}
+
+// flattener expands records, keeping track of what it's done along the way.
+type flattener struct {
+	c        *Checker
+	visiting map[string]bool // domains on the current include path
+	lookups  int             // terms that would cause DNS lookups during a check
+	includes []string        // domains included or redirected to
+	partial  bool            // expand around opaque terms rather than keeping them
+	opaque   []OpaqueTerm    // every opaque term found
+}
+
+func newFlattener(c *Checker) *flattener {
+	return &flattener{
+		c:        c,
+		visiting: map[string]bool{},
+	}
+}
+
+// Flatten fetches the SPF record for a domain and returns an equivalent
+// record with every "include", "a" and "mx" mechanism replaced by the "ip4"
+// and "ip6" mechanisms they match, so that the record needs no DNS lookups
+// to check. A "redirect" with no "all" is replaced by the terms of the
+// record it redirects to.
+//
+// Each address gives the same result with the flattened record as with the
+// original. Terms that depend on the message being checked - "exists",
+// "ptr" and any term using macros - are kept as they are, as are includes
+// and redirects to records that contain them.
+//
+// The flattened record is a snapshot, and needs to be regenerated whenever
+// any of the records it was built from change.
+func (c *Checker) Flatten(ctx context.Context, domain string) (*SPFRecord, error) {
+	f := newFlattener(c)
+	fr, err := f.record(ctx, dns.Fqdn(domain), 0)
+	if err != nil {
+		return nil, err
+	}
+	return fr.flatten(), nil
+}
+
+// flatten builds an SPFRecord from the flattened terms, merging runs of
+// terms with the same qualifier.
+func (fr *flatRecord) flatten() *SPFRecord {
+	ret := &SPFRecord{
+		Exp:            fr.record.Exp,
+		Redirect:       fr.redirect,
+		OtherModifiers: fr.record.OtherModifiers,
+	}
+	var run ipSet
+	var runQualifier ResultType
+	flush := func() {
+		ret.Mechanisms = append(ret.Mechanisms, ipMechanisms(runQualifier, run)...)
+		run = ipSet{}
+	}
+	for _, t := range fr.terms {
+		if t.opaque || t.all || t.qualifier != runQualifier {
+			flush()
+		}
+		switch {
+		case t.opaque:
+			ret.Mechanisms = append(ret.Mechanisms, t.mechanism)
+		case t.all:
+			ret.Mechanisms = append(ret.Mechanisms, MechanismAll{Qualifier: t.qualifier})
+		default:
+			run.union(t.set)
+			runQualifier = t.qualifier
+		}
+	}
+	flush()
+	return ret
+}
+
+// ipMechanisms returns the ip4 and ip6 mechanisms matching exactly the
+// addresses in s
+func ipMechanisms(qualifier ResultType, s ipSet) []Mechanism {
+	var ret []Mechanism
+	for _, p := range s.prefixes() {
+		n := &net.IPNet{
+			IP:   net.IP(p.Addr().AsSlice()),
+			Mask: net.CIDRMask(p.Bits(), p.Addr().BitLen()),
+		}
+		if p.Addr().Is4() {
+			ret = append(ret, MechanismIp4{Qualifier: qualifier, Net: n})
+		} else {
+			ret = append(ret, MechanismIp6{Qualifier: qualifier, Net: n})
+		}
+	}
+	return ret
+}
+
+// firstMatch returns the addresses that each qualifier applies to, with
+// each address belonging to the first term that matches it. Opaque terms
+// are treated as matching nothing.
+func firstMatch(terms []flatTerm) map[ResultType]ipSet {
+	ret := map[ResultType]ipSet{}
+	var matched ipSet
+	for _, t := range terms {
+		if t.opaque {
+			continue
+		}
+		set := t.set
+		if t.all {
+			set = allAddresses()
+		}
+		qualified := ret[t.qualifier]
+		qualified.union(set.subtract(matched))
+		ret[t.qualifier] = qualified
+		matched.union(set)
+		if t.all {
+			break
+		}
+	}
+	return ret
+}
+
+// record fetches and expands the SPF record for a domain.
+func (f *flattener) record(ctx context.Context, domain string, depth int) (*flatRecord, error) {
+	domain = strings.ToLower(domain)
+	if f.visiting[domain] {
+		return nil, fmt.Errorf("%s includes or redirects to itself", domain)
+	}
+	if depth > maxRecordDepth {
+		return nil, fmt.Errorf("includes and redirects nested too deeply at %s", domain)
+	}
+	f.visiting[domain] = true
+	defer delete(f.visiting, domain)
+
+	text, _, err := f.c.getSPFRecord(ctx, nil, domain)
+	if err != nil {
+		return nil, err
+	}
+	if text == "" {
+		return nil, fmt.Errorf("no SPF record for %s", domain)
+	}
+	return f.expand(ctx, domain, text, depth)
+}
+
+// expand parses and expands the text of the SPF record for a domain.
+func (f *flattener) expand(ctx context.Context, domain string, text string, depth int) (*flatRecord, error) {
+	if badChar := invalidCharRe.FindString(text); badChar != "" {
+		return nil, fmt.Errorf("invalid character %q in SPF record for %s", badChar[0], domain)
+	}
+	record, err := ParseSPF(text)
+	if err != nil {
+		return nil, fmt.Errorf("in SPF record for %s: %w", domain, err)
+	}
+
+	fr := &flatRecord{
+		domain: domain,
+		record: record,
+	}
+	all := false
+	for _, mechanism := range record.Mechanisms {
+		t, err := f.term(ctx, mechanism, domain, depth)
+		if err != nil {
+			return nil, err
+		}
+		if t.opaque {
+			f.opaque = append(f.opaque, OpaqueTerm{Domain: domain, Term: mechanism.String(), Reason: t.reason})
+		}
+		fr.terms = append(fr.terms, t)
+		fr.opaque = fr.opaque || t.opaque
+		if t.all {
+			all = true
+			break
+		}
+	}
+
+	// 6.1.  redirect: Redirected Query (RFC 7208)
+	//
+	//   For clarity, any "redirect" modifier SHOULD appear as the very last
+	//   term in a record.  Any "redirect" modifier MUST be ignored if there
+	//   is an "all" mechanism anywhere in the record.
+	if all || record.Redirect == "" {
+		return fr, nil
+	}
+	f.lookups++
+	if strings.Contains(record.Redirect, "%") {
+		f.opaque = append(f.opaque, OpaqueTerm{Domain: domain, Term: "redirect=" + record.Redirect, Reason: "uses macros"})
+		fr.redirect = record.Redirect
+		fr.opaque = true
+		return fr, nil
+	}
+	target, err := f.target(record.Redirect, domain)
+	if err != nil {
+		return nil, err
+	}
+	f.includes = append(f.includes, target)
+	redirected, err := f.record(ctx, target, depth+1)
+	if err != nil {
+		return nil, err
+	}
+	if redirected.opaque && !f.partial {
+		fr.redirect = record.Redirect
+		fr.opaque = true
+		return fr, nil
+	}
+	fr.terms = append(fr.terms, redirected.terms...)
+	return fr, nil
+}
+
+// term expands a single mechanism.
+func (f *flattener) term(ctx context.Context, mechanism Mechanism, domain string, depth int) (flatTerm, error) {
+	t := flatTerm{
+		mechanism: mechanism,
+		domain:    domain,
+	}
+	switch m := mechanism.(type) {
+	case MechanismAll:
+		t.qualifier = m.Qualifier
+		t.all = true
+	case MechanismIp4:
+		t.qualifier = m.Qualifier
+		t.set.addPrefix(prefixFromIPNet(m.Net))
+	case MechanismIp6:
+		t.qualifier = m.Qualifier
+		t.set.addPrefix(prefixFromIPNet(m.Net))
+	case MechanismPTR:
+		f.lookups++
+		t.qualifier = m.Qualifier
+		t.opaque = true
+		t.reason = "depends on the client address"
+	case MechanismExists:
+		f.lookups++
+		t.qualifier = m.Qualifier
+		t.opaque = true
+		t.reason = "depends on the message"
+	case MechanismA:
+		f.lookups++
+		t.qualifier = m.Qualifier
+		if strings.Contains(m.DomainSpec, "%") {
+			t.opaque = true
+			t.reason = "uses macros"
+			break
+		}
+		target, err := f.target(m.DomainSpec, domain)
+		if err != nil {
+			return t, err
+		}
+		if err := f.addresses(ctx, &t.set, target, m.Mask4, m.Mask6); err != nil {
+			return t, err
+		}
+	case MechanismMX:
+		f.lookups++
+		t.qualifier = m.Qualifier
+		if strings.Contains(m.DomainSpec, "%") {
+			t.opaque = true
+			t.reason = "uses macros"
+			break
+		}
+		target, err := f.target(m.DomainSpec, domain)
+		if err != nil {
+			return t, err
+		}
+		rrs, err := f.lookup(ctx, target, dns.TypeMX)
+		if err != nil {
+			return t, err
+		}
+		if len(rrs) > f.c.MXAddressLimit {
+			// This will be a permerror when checked, so leave it for
+			// the checker to find
+			t.opaque = true
+			t.reason = "too many MX records"
+			break
+		}
+		for _, rr := range rrs {
+			if err := f.addresses(ctx, &t.set, rr.(*dns.MX).Mx, m.Mask4, m.Mask6); err != nil {
+				return t, err
+			}
+		}
+	case MechanismInclude:
+		f.lookups++
+		t.qualifier = m.Qualifier
+		if strings.Contains(m.DomainSpec, "%") {
+			t.opaque = true
+			t.reason = "uses macros"
+			break
+		}
+		target, err := f.target(m.DomainSpec, domain)
+		if err != nil {
+			return t, err
+		}
+		f.includes = append(f.includes, target)
+		included, err := f.record(ctx, target, depth+1)
+		if err != nil {
+			return t, err
+		}
+		if included.opaque && !f.partial {
+			t.opaque = true
+			t.reason = "includes terms that can't be expanded"
+			break
+		}
+		// The include matches if the included record would return pass
+		t.set = firstMatch(included.terms)[Pass]
+	default:
+		return t, fmt.Errorf("unhandled mechanism %s", mechanism)
+	}
+	return t, nil
+}
+
+// target returns the domain a domain-spec with no macros refers to.
+func (f *flattener) target(domainSpec, domain string) (string, error) {
+	if domainSpec == "" {
+		return domain, nil
+	}
+	if !validDomainName(domainSpec) {
+		return "", fmt.Errorf("invalid hostname '%s' in SPF record for %s", domainSpec, domain)
+	}
+	return strings.ToLower(dns.Fqdn(domainSpec)), nil
+}
+
+// lookup returns the records of a type for a name. NXDOMAIN is treated as
+// no records, other DNS errors are returned.
+func (f *flattener) lookup(ctx context.Context, name string, qtype uint16) ([]dns.RR, error) {
+	r := &dns.Msg{}
+	r.SetQuestion(dns.Fqdn(name), qtype)
+	m, err := f.c.resolve(ctx, nil, r)
+	if err != nil {
+		return nil, err
+	}
+	switch m.Rcode {
+	case dns.RcodeSuccess:
+	case dns.RcodeNameError:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("%s lookup for %s returned %s", dns.TypeToString[qtype], name, dns.RcodeToString[m.Rcode])
+	}
+	var ret []dns.RR
+	for _, rr := range m.Answer {
+		if rr.Header().Rrtype == qtype {
+			ret = append(ret, rr)
+		}
+	}
+	return ret, nil
+}
+
+// addresses adds the networks given by a hostname's A and AAAA records and
+// a pair of masks to s.
+func (f *flattener) addresses(ctx context.Context, s *ipSet, hostname string, mask4, mask6 net.IPMask) error {
+	ones4 := maskBits(mask4, 32)
+	ones6 := maskBits(mask6, 128)
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		rrs, err := f.lookup(ctx, hostname, qtype)
+		if err != nil {
+			return err
+		}
+		for _, rr := range rrs {
+			switch v := rr.(type) {
+			case *dns.A:
+				addr, _ := netip.AddrFromSlice(v.A.To4())
+				s.addPrefix(netip.PrefixFrom(addr, ones4))
+			case *dns.AAAA:
+				addr, _ := netip.AddrFromSlice(v.AAAA.To16())
+				s.addPrefix(netip.PrefixFrom(addr, ones6))
+			}
+		}
+	}
+	return nil
+}
+
+// maskBits returns the prefix length of a mask, treating a missing mask as
+// matching a single address.
+func maskBits(mask net.IPMask, full int) int {
+	ones, bits := mask.Size()
+	if bits == 0 {
+		return full
+	}
+	return ones
+}
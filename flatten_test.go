@@ -0,0 +1,85 @@
+package spf_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/wttw/spf"
+	"github.com/wttw/spf/spftest"
+)
+
+func TestFlatten(t *testing.T) {
+	res := spftest.NewResolver().
+		TXT("example.com", "v=spf1 mx a:mail.example.com include:_spf.vendor.com -ip4:198.51.100.0/24 exists:%{i}.rbl.example.com redirect=_spf.example.com").
+		TXT("_spf.example.com", "v=spf1 ip4:203.0.113.0/25 ~all").
+		MX("example.com", 10, "mx1.example.com").
+		MX("example.com", 20, "mx2.example.com").
+		A("mx1.example.com", "192.0.2.1").
+		A("mx2.example.com", "192.0.2.2").
+		AAAA("mx2.example.com", "2001:db8::2").
+		A("mail.example.com", "192.0.2.3").
+		AAAA("mail.example.com", "2001:db8::3").
+		TXT("_spf.vendor.com", "v=spf1 -ip4:198.51.100.128/25 ip4:198.51.100.0/24 include:_spf2.vendor.com ?all").
+		TXT("_spf2.vendor.com", "v=spf1 ip6:2001:db8:1::/48 ~ip4:203.0.113.0/24 -all").
+		NoData("rbl.example.com")
+	c := spftest.NewChecker(res)
+
+	flat, err := c.Flatten(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "v=spf1 ip4:192.0.2.1/32 ip4:192.0.2.2/31 ip4:198.51.100.0/25 ip6:2001:db8::2/127 ip6:2001:db8:1::/48 -ip4:198.51.100.0/24 exists:%{i}.rbl.example.com ip4:203.0.113.0/25 ~all"
+	if flat.String() != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, flat.String())
+	}
+	if strings.Contains(flat.String(), "include:") {
+		t.Errorf("flattened record still has includes")
+	}
+
+	// Every address should give the same result from both records
+	res.TXT("flat.example.com", flat.String())
+	for _, ip := range []string{
+		"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4",
+		"198.51.100.1", "198.51.100.200",
+		"203.0.113.1", "203.0.113.200",
+		"2001:db8::2", "2001:db8:1::1", "2001:db8:2::1",
+	} {
+		addr := net.ParseIP(ip)
+		original := c.CheckHost(context.Background(), addr, "example.com.", "user@example.com", "")
+		flattened := c.CheckHost(context.Background(), addr, "flat.example.com.", "user@example.com", "")
+		if original.Type != flattened.Type {
+			t.Errorf("%s: original record gives %s, flattened gives %s", ip, original.Type, flattened.Type)
+		}
+		if original.Type == spf.None || flattened.Type == spf.None {
+			t.Errorf("%s: unexpected none result: %v, %v", ip, original.Error, flattened.Error)
+		}
+	}
+
+	res.TXT("redirect.example.com", "v=spf1 ip4:192.0.2.0/24 redirect=_spf.example.com")
+	flat, err = c.Flatten(context.Background(), "redirect.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "v=spf1 ip4:192.0.2.0/24 ip4:203.0.113.0/25 ~all"
+	if flat.String() != want {
+		t.Errorf("expected %s, got %s", want, flat.String())
+	}
+
+	res.TXT("opaque.example.com", "v=spf1 ip4:192.0.2.0/24 redirect=ptr.example.com").
+		TXT("ptr.example.com", "v=spf1 ptr -all")
+	flat, err = c.Flatten(context.Background(), "opaque.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "v=spf1 ip4:192.0.2.0/24 redirect=ptr.example.com"
+	if flat.String() != want {
+		t.Errorf("expected %s, got %s", want, flat.String())
+	}
+
+	res.TXT("loop.example.com", "v=spf1 include:loop.example.com -all")
+	if _, err := c.Flatten(context.Background(), "loop.example.com"); err == nil {
+		t.Errorf("expected an error flattening a looping record")
+	}
+}
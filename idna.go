@@ -0,0 +1,69 @@
+package spf
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// 4.  SPF and Internationalized Mail (RFC 8616)
+//
+//	All U-labels MUST be converted to A-labels before being used for an
+//	SPF validation.  This includes both the labels in the name used for
+//	the original DNS lookup, described in Section 3 of [RFC7208], and
+//	those used in the macro expansion of domain-spec, described in
+//	Section 7.
+
+// ErrNonASCIILocalPart is returned when expanding a %{s} or %{l} macro for
+// a sender with a non-ASCII local-part anywhere other than an explanation.
+//
+//	SPF macros %{s} and %{l} expand the local part of the sender's
+//	mailbox.  If the local part contains non-ASCII characters, terms that
+//	include %{s} or %{l} do not match anything, because non-ASCII local
+//	parts cannot be used as the DNS labels the macros are intended to
+//	match.
+var ErrNonASCIILocalPart = errors.New("non-ASCII local-part can't be used in a domain-spec")
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// toASCII converts any U-labels in domain to A-labels, and checks that any
+// A-labels are valid. Domains that are already ASCII with no A-labels are
+// returned unchanged.
+func toASCII(domain string) (string, error) {
+	if isASCII(domain) && !strings.Contains(strings.ToLower(domain), "xn--") {
+		return domain, nil
+	}
+	ret, err := idna.Lookup.ToASCII(domain)
+	if err != nil {
+		return "", &DomainError{Name: domain, Err: err}
+	}
+	return ret, nil
+}
+
+// senderToASCII converts the domain of a mailbox to A-labels, leaving the
+// local-part as it is.
+func senderToASCII(sender string) (string, error) {
+	at := strings.LastIndex(sender, "@")
+	domain, err := toASCII(sender[at+1:])
+	if err != nil {
+		return "", err
+	}
+	return sender[:at+1] + domain, nil
+}
+
+// expansionResult is the result of a mechanism whose domain-spec couldn't
+// be expanded.
+func expansionResult(err error) ResultType {
+	if errors.Is(err, ErrNonASCIILocalPart) {
+		return None
+	}
+	return Permerror
+}
@@ -0,0 +1,122 @@
+package spf
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// OpaqueTerm is a term of a policy that can't be expanded into a set of
+// addresses without a message to check, such as "exists" or anything using
+// macros.
+type OpaqueTerm struct {
+	Domain string // the domain whose record it's in
+	Term   string
+	Reason string
+}
+
+// IPPolicy describes which result a policy gives for each address.
+type IPPolicy struct {
+	Domain string
+
+	// Prefixes holds the addresses that get each result, with each address
+	// belonging to the first term that matches it, as it would be when
+	// checking a message. Results with no addresses are missing.
+	Prefixes map[ResultType][]netip.Prefix
+
+	// Default is the result for addresses not in Prefixes; the qualifier of
+	// the "all" mechanism if there is one, otherwise neutral.
+	Default ResultType
+
+	// Unexpandable holds the terms that couldn't be expanded. They're
+	// treated as matching nothing, so if there are any Prefixes may be
+	// incomplete.
+	Unexpandable []OpaqueTerm
+
+	Includes   []string // domains included or redirected to
+	DNSLookups int      // terms that cause DNS lookups, across all included records
+}
+
+// AuthorizedIPs fetches the SPF record for a domain, and every record it
+// includes or redirects to, and works out which addresses get each result.
+// Terms that depend on the message being checked are listed in
+// Unexpandable, and treated as matching nothing.
+func (c *Checker) AuthorizedIPs(ctx context.Context, domain string) (*IPPolicy, error) {
+	f := newFlattener(c)
+	f.partial = true
+	fr, err := f.record(ctx, dns.Fqdn(domain), 0)
+	if err != nil {
+		return nil, err
+	}
+	return fr.ipPolicy(f), nil
+}
+
+// AuthorizedIPsForRecord works out which addresses get each result from the
+// text of an SPF record, as if it were published for domain. Any records it
+// includes or redirects to are fetched from DNS.
+func (c *Checker) AuthorizedIPsForRecord(ctx context.Context, domain string, record string) (*IPPolicy, error) {
+	f := newFlattener(c)
+	f.partial = true
+	domain = strings.ToLower(dns.Fqdn(domain))
+	f.visiting[domain] = true
+	fr, err := f.expand(ctx, domain, record, 0)
+	if err != nil {
+		return nil, err
+	}
+	return fr.ipPolicy(f), nil
+}
+
+// ipPolicy builds an IPPolicy from an expanded record
+func (fr *flatRecord) ipPolicy(f *flattener) *IPPolicy {
+	ret := &IPPolicy{
+		Domain:       fr.domain,
+		Prefixes:     map[ResultType][]netip.Prefix{},
+		Default:      Neutral,
+		Unexpandable: f.opaque,
+		Includes:     f.includes,
+		DNSLookups:   f.lookups,
+	}
+	terms := fr.terms
+	if n := len(terms); n > 0 && terms[n-1].all {
+		ret.Default = terms[n-1].qualifier
+		terms = terms[:n-1]
+	}
+	for qualifier, set := range firstMatch(terms) {
+		if !set.empty() {
+			ret.Prefixes[qualifier] = set.prefixes()
+		}
+	}
+	return ret
+}
+
+// Result returns the result the policy gives for an address, ignoring any
+// Unexpandable terms.
+func (p *IPPolicy) Result(addr netip.Addr) ResultType {
+	addr = addr.Unmap()
+	for qualifier, prefixes := range p.Prefixes {
+		for _, prefix := range prefixes {
+			if prefix.Contains(addr) {
+				return qualifier
+			}
+		}
+	}
+	return p.Default
+}
+
+// sets returns the addresses that get each result, including the default
+func (p *IPPolicy) sets() map[ResultType]ipSet {
+	ret := map[ResultType]ipSet{}
+	var matched ipSet
+	for result, prefixes := range p.Prefixes {
+		var s ipSet
+		s.addPrefixes(prefixes)
+		ret[result] = s
+		matched.union(s)
+	}
+	def := ret[p.Default]
+	def.union(allAddresses().subtract(matched))
+	ret[p.Default] = def
+	return ret
+}
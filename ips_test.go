@@ -0,0 +1,68 @@
+package spf_test
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/wttw/spf"
+	"github.com/wttw/spf/spftest"
+)
+
+func TestAuthorizedIPs(t *testing.T) {
+	res := spftest.NewResolver().
+		TXT("example.com", "v=spf1 -ip4:192.0.2.128/25 include:_spf.example.com ~ip4:192.0.2.0/24 exists:%{i}.rbl.example.com ptr ?ip6:2001:db8::/32 -all").
+		TXT("_spf.example.com", "v=spf1 ip4:192.0.2.0/25 ip4:192.0.2.200 a:mail.example.com ~all").
+		A("mail.example.com", "198.51.100.1")
+	c := spftest.NewChecker(res)
+
+	policy, err := c.AuthorizedIPs(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[spf.ResultType][]string{
+		spf.Pass:    {"192.0.2.0/25", "198.51.100.1/32"},
+		spf.Fail:    {"192.0.2.128/25"},
+		spf.Neutral: {"2001:db8::/32"},
+	}
+	for result, prefixes := range want {
+		got := policy.Prefixes[result]
+		if len(got) != len(prefixes) {
+			t.Errorf("%s: expected %v, got %v", result, prefixes, got)
+			continue
+		}
+		for i := range got {
+			if got[i].String() != prefixes[i] {
+				t.Errorf("%s: expected %v, got %v", result, prefixes, got)
+				break
+			}
+		}
+	}
+	if _, ok := policy.Prefixes[spf.Softfail]; ok {
+		t.Errorf("expected no softfail addresses, got %v", policy.Prefixes[spf.Softfail])
+	}
+	if policy.Default != spf.Fail {
+		t.Errorf("expected default of fail, got %s", policy.Default)
+	}
+	if len(policy.Unexpandable) != 2 {
+		t.Errorf("expected 2 unexpandable terms, got %v", policy.Unexpandable)
+	}
+	if policy.DNSLookups != 4 {
+		t.Errorf("expected 4 DNS lookups, got %d", policy.DNSLookups)
+	}
+	if len(policy.Includes) != 1 || policy.Includes[0] != "_spf.example.com." {
+		t.Errorf("expected to include _spf.example.com., got %v", policy.Includes)
+	}
+	for addr, result := range map[string]spf.ResultType{
+		"192.0.2.1":    spf.Pass,
+		"192.0.2.200":  spf.Fail,
+		"198.51.100.1": spf.Pass,
+		"2001:db8::1":  spf.Neutral,
+		"203.0.113.1":  spf.Fail,
+		"2001:db9::1":  spf.Fail,
+	} {
+		if got := policy.Result(netip.MustParseAddr(addr)); got != result {
+			t.Errorf("%s: expected %s, got %s", addr, result, got)
+		}
+	}
+}
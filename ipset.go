@@ -0,0 +1,177 @@
+package spf
+
+import (
+	"net"
+	"net/netip"
+	"sort"
+)
+
+// ipRange is an inclusive range of addresses, all of the same family
+type ipRange struct {
+	from, to netip.Addr
+}
+
+// ipSet is a set of IPv4 and IPv6 addresses, held as sorted, non-overlapping
+// and non-adjacent ranges.
+type ipSet struct {
+	ranges []ipRange
+}
+
+// allAddresses returns the set of every IPv4 and IPv6 address
+func allAddresses() ipSet {
+	var s ipSet
+	s.addPrefix(netip.MustParsePrefix("0.0.0.0/0"))
+	s.addPrefix(netip.MustParsePrefix("::/0"))
+	return s
+}
+
+// prefixFromIPNet converts a net.IPNet, which may hold an IPv4 address in
+// 16 byte form, to a netip.Prefix
+func prefixFromIPNet(n *net.IPNet) netip.Prefix {
+	addr, _ := netip.AddrFromSlice(n.IP)
+	ones, _ := n.Mask.Size()
+	if addr.Is4In6() {
+		addr = addr.Unmap()
+		if ones >= 96 && len(n.Mask) == net.IPv6len {
+			ones -= 96
+		}
+	}
+	return netip.PrefixFrom(addr, ones).Masked()
+}
+
+// lastAddr returns the highest address in a prefix
+func lastAddr(p netip.Prefix) netip.Addr {
+	b := p.Masked().Addr().AsSlice()
+	for i := p.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 0x80 >> (i % 8)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+// adjacent returns whether b starts no later than immediately after a ends,
+// so that the two ranges can be merged.
+func adjacent(a, b ipRange) bool {
+	if a.to.Is4() != b.from.Is4() {
+		return false
+	}
+	next := a.to.Next()
+	return !next.IsValid() || b.from.Compare(next) <= 0
+}
+
+func (s *ipSet) empty() bool {
+	return len(s.ranges) == 0
+}
+
+func (s *ipSet) addPrefix(p netip.Prefix) {
+	p = p.Masked()
+	s.addRange(ipRange{from: p.Addr(), to: lastAddr(p)})
+}
+
+func (s *ipSet) addPrefixes(prefixes []netip.Prefix) {
+	for _, p := range prefixes {
+		p = p.Masked()
+		s.ranges = append(s.ranges, ipRange{from: p.Addr(), to: lastAddr(p)})
+	}
+	s.normalize()
+}
+
+func (s *ipSet) addRange(r ipRange) {
+	s.ranges = append(s.ranges, r)
+	s.normalize()
+}
+
+// union adds every address in o to s
+func (s *ipSet) union(o ipSet) {
+	// Copy, as s may share its ranges with another set
+	ranges := make([]ipRange, 0, len(s.ranges)+len(o.ranges))
+	s.ranges = append(append(ranges, s.ranges...), o.ranges...)
+	s.normalize()
+}
+
+func (s *ipSet) normalize() {
+	if len(s.ranges) < 2 {
+		return
+	}
+	sort.Slice(s.ranges, func(i, j int) bool {
+		return s.ranges[i].from.Less(s.ranges[j].from)
+	})
+	merged := s.ranges[:1]
+	for _, r := range s.ranges[1:] {
+		last := &merged[len(merged)-1]
+		if adjacent(*last, r) {
+			if last.to.Less(r.to) {
+				last.to = r.to
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	s.ranges = merged
+}
+
+// subtract returns the addresses in s that aren't in o
+func (s ipSet) subtract(o ipSet) ipSet {
+	var ret ipSet
+	for _, r := range s.ranges {
+		remaining := []ipRange{r}
+		for _, cut := range o.ranges {
+			var next []ipRange
+			for _, rr := range remaining {
+				if cut.to.Less(rr.from) || rr.to.Less(cut.from) {
+					next = append(next, rr)
+					continue
+				}
+				if rr.from.Less(cut.from) {
+					next = append(next, ipRange{from: rr.from, to: cut.from.Prev()})
+				}
+				if cut.to.Less(rr.to) {
+					next = append(next, ipRange{from: cut.to.Next(), to: rr.to})
+				}
+			}
+			remaining = next
+		}
+		ret.ranges = append(ret.ranges, remaining...)
+	}
+	return ret
+}
+
+// equal returns whether s and o hold the same addresses
+func (s ipSet) equal(o ipSet) bool {
+	if len(s.ranges) != len(o.ranges) {
+		return false
+	}
+	for i := range s.ranges {
+		if s.ranges[i] != o.ranges[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// prefixes returns the smallest list of CIDR prefixes covering exactly the
+// addresses in s, in order.
+func (s ipSet) prefixes() []netip.Prefix {
+	var ret []netip.Prefix
+	for _, r := range s.ranges {
+		from := r.from
+		for {
+			// Find the largest prefix starting at from that ends no later
+			// than r.to
+			var p netip.Prefix
+			for bits := 0; bits <= from.BitLen(); bits++ {
+				p = netip.PrefixFrom(from, bits)
+				if p.Masked().Addr() == from && !r.to.Less(lastAddr(p)) {
+					break
+				}
+			}
+			ret = append(ret, p)
+			last := lastAddr(p)
+			if last == r.to {
+				break
+			}
+			from = last.Next()
+		}
+	}
+	return ret
+}
@@ -0,0 +1,475 @@
+package spf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// JSON encoding of Results, SPFRecords and Mechanisms, so that verdicts can
+// be stored and passed between processes.
+//
+// Each mechanism is an object whose "type" field names the mechanism, e.g.
+//
+//	{"type":"ip4","qualifier":"pass","network":"192.0.2.0/24"}
+//	{"type":"a","qualifier":"fail","domain_spec":"%{d}","cidr4":24}
+//
+// UnmarshalMechanism decodes one of these without knowing its type in advance.
+
+// mechanismJSON is the JSON form of every Mechanism type, with Type as the
+// discriminator.
+type mechanismJSON struct {
+	Type       string     `json:"type"`
+	Qualifier  ResultType `json:"qualifier"`
+	DomainSpec string     `json:"domain_spec,omitempty"`
+	Network    string     `json:"network,omitempty"`
+	CIDR4      *int       `json:"cidr4,omitempty"`
+	CIDR6      *int       `json:"cidr6,omitempty"`
+}
+
+// UnmarshalMechanism decodes a Mechanism from its JSON form, using the "type"
+// field to decide which Mechanism type to return.
+func UnmarshalMechanism(data []byte) (Mechanism, error) {
+	var j mechanismJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return nil, err
+	}
+	return j.mechanism()
+}
+
+func (j mechanismJSON) mechanism() (Mechanism, error) {
+	switch j.Type {
+	case "all":
+		return MechanismAll{Qualifier: j.Qualifier}, nil
+	case "include":
+		return MechanismInclude{Qualifier: j.Qualifier, DomainSpec: j.DomainSpec}, nil
+	case "a", "mx":
+		mask4, err := cidrMask(j.CIDR4, 32)
+		if err != nil {
+			return nil, err
+		}
+		mask6, err := cidrMask(j.CIDR6, 128)
+		if err != nil {
+			return nil, err
+		}
+		if j.Type == "a" {
+			return MechanismA{Qualifier: j.Qualifier, DomainSpec: j.DomainSpec, Mask4: mask4, Mask6: mask6}, nil
+		}
+		return MechanismMX{Qualifier: j.Qualifier, DomainSpec: j.DomainSpec, Mask4: mask4, Mask6: mask6}, nil
+	case "ptr":
+		return MechanismPTR{Qualifier: j.Qualifier, DomainSpec: j.DomainSpec}, nil
+	case "ip4":
+		n, err := network(j.Network, 32)
+		if err != nil {
+			return nil, err
+		}
+		return MechanismIp4{Qualifier: j.Qualifier, Net: n}, nil
+	case "ip6":
+		n, err := network(j.Network, 128)
+		if err != nil {
+			return nil, err
+		}
+		return MechanismIp6{Qualifier: j.Qualifier, Net: n}, nil
+	case "exists":
+		return MechanismExists{Qualifier: j.Qualifier, DomainSpec: j.DomainSpec}, nil
+	case "":
+		return nil, errors.New("mechanism has no type")
+	}
+	return nil, fmt.Errorf("unrecognized mechanism '%s'", j.Type)
+}
+
+// unmarshalMechanism decodes data into the Mechanism pointed to by m,
+// checking that it's of the expected type.
+func unmarshalMechanism(data []byte, mtype string, m interface{}) error {
+	var j mechanismJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	if j.Type != mtype {
+		return fmt.Errorf("expected %s mechanism, not '%s'", mtype, j.Type)
+	}
+	mech, err := j.mechanism()
+	if err != nil {
+		return err
+	}
+	switch v := m.(type) {
+	case *MechanismAll:
+		*v = mech.(MechanismAll)
+	case *MechanismInclude:
+		*v = mech.(MechanismInclude)
+	case *MechanismA:
+		*v = mech.(MechanismA)
+	case *MechanismMX:
+		*v = mech.(MechanismMX)
+	case *MechanismPTR:
+		*v = mech.(MechanismPTR)
+	case *MechanismIp4:
+		*v = mech.(MechanismIp4)
+	case *MechanismIp6:
+		*v = mech.(MechanismIp6)
+	case *MechanismExists:
+		*v = mech.(MechanismExists)
+	}
+	return nil
+}
+
+// cidrLength returns the prefix length of mask, or nil if it covers the
+// whole address as a mechanism without a cidr-length does.
+func cidrLength(mask net.IPMask, bits int) *int {
+	ones, size := mask.Size()
+	if size == 0 || ones == bits {
+		return nil
+	}
+	return &ones
+}
+
+func cidrMask(length *int, bits int) (net.IPMask, error) {
+	if length == nil {
+		return net.CIDRMask(bits, bits), nil
+	}
+	if *length < 0 || *length > bits {
+		return nil, fmt.Errorf("invalid cidr length %d", *length)
+	}
+	return net.CIDRMask(*length, bits), nil
+}
+
+func network(s string, bits int) (*net.IPNet, error) {
+	_, n, err := parseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid network '%s'", s)
+	}
+	if _, size := n.Mask.Size(); size != bits {
+		return nil, fmt.Errorf("wrong address family for network '%s'", s)
+	}
+	return n, nil
+}
+
+func (m MechanismAll) MarshalJSON() ([]byte, error) {
+	return json.Marshal(mechanismJSON{Type: "all", Qualifier: m.Qualifier})
+}
+
+func (m *MechanismAll) UnmarshalJSON(data []byte) error {
+	return unmarshalMechanism(data, "all", m)
+}
+
+func (m MechanismInclude) MarshalJSON() ([]byte, error) {
+	return json.Marshal(mechanismJSON{Type: "include", Qualifier: m.Qualifier, DomainSpec: m.DomainSpec})
+}
+
+func (m *MechanismInclude) UnmarshalJSON(data []byte) error {
+	return unmarshalMechanism(data, "include", m)
+}
+
+func (m MechanismA) MarshalJSON() ([]byte, error) {
+	return json.Marshal(mechanismJSON{
+		Type:       "a",
+		Qualifier:  m.Qualifier,
+		DomainSpec: m.DomainSpec,
+		CIDR4:      cidrLength(m.Mask4, 32),
+		CIDR6:      cidrLength(m.Mask6, 128),
+	})
+}
+
+func (m *MechanismA) UnmarshalJSON(data []byte) error {
+	return unmarshalMechanism(data, "a", m)
+}
+
+func (m MechanismMX) MarshalJSON() ([]byte, error) {
+	return json.Marshal(mechanismJSON{
+		Type:       "mx",
+		Qualifier:  m.Qualifier,
+		DomainSpec: m.DomainSpec,
+		CIDR4:      cidrLength(m.Mask4, 32),
+		CIDR6:      cidrLength(m.Mask6, 128),
+	})
+}
+
+func (m *MechanismMX) UnmarshalJSON(data []byte) error {
+	return unmarshalMechanism(data, "mx", m)
+}
+
+func (m MechanismPTR) MarshalJSON() ([]byte, error) {
+	return json.Marshal(mechanismJSON{Type: "ptr", Qualifier: m.Qualifier, DomainSpec: m.DomainSpec})
+}
+
+func (m *MechanismPTR) UnmarshalJSON(data []byte) error {
+	return unmarshalMechanism(data, "ptr", m)
+}
+
+func (m MechanismIp4) MarshalJSON() ([]byte, error) {
+	if m.Net == nil {
+		return nil, errors.New("ip4 mechanism has no network")
+	}
+	return json.Marshal(mechanismJSON{Type: "ip4", Qualifier: m.Qualifier, Network: m.Net.String()})
+}
+
+func (m *MechanismIp4) UnmarshalJSON(data []byte) error {
+	return unmarshalMechanism(data, "ip4", m)
+}
+
+func (m MechanismIp6) MarshalJSON() ([]byte, error) {
+	if m.Net == nil {
+		return nil, errors.New("ip6 mechanism has no network")
+	}
+	return json.Marshal(mechanismJSON{Type: "ip6", Qualifier: m.Qualifier, Network: m.Net.String()})
+}
+
+func (m *MechanismIp6) UnmarshalJSON(data []byte) error {
+	return unmarshalMechanism(data, "ip6", m)
+}
+
+func (m MechanismExists) MarshalJSON() ([]byte, error) {
+	return json.Marshal(mechanismJSON{Type: "exists", Qualifier: m.Qualifier, DomainSpec: m.DomainSpec})
+}
+
+func (m *MechanismExists) UnmarshalJSON(data []byte) error {
+	return unmarshalMechanism(data, "exists", m)
+}
+
+// mechanismList decodes a JSON array of mechanisms of mixed types.
+type mechanismList []Mechanism
+
+func (l *mechanismList) UnmarshalJSON(data []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	ret := make(mechanismList, 0, len(raw))
+	for _, r := range raw {
+		m, err := UnmarshalMechanism(r)
+		if err != nil {
+			return err
+		}
+		ret = append(ret, m)
+	}
+	*l = ret
+	return nil
+}
+
+type spfRecordJSON struct {
+	Mechanisms     mechanismList `json:"mechanisms"`
+	Exp            string        `json:"exp,omitempty"`
+	Redirect       string        `json:"redirect,omitempty"`
+	OtherModifiers []string      `json:"other_modifiers,omitempty"`
+}
+
+func (r SPFRecord) MarshalJSON() ([]byte, error) {
+	mechanisms := r.Mechanisms
+	if mechanisms == nil {
+		mechanisms = []Mechanism{}
+	}
+	return json.Marshal(spfRecordJSON{
+		Mechanisms:     mechanisms,
+		Exp:            r.Exp,
+		Redirect:       r.Redirect,
+		OtherModifiers: r.OtherModifiers,
+	})
+}
+
+func (r *SPFRecord) UnmarshalJSON(data []byte) error {
+	var j spfRecordJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*r = SPFRecord{
+		Mechanisms:     j.Mechanisms,
+		Exp:            j.Exp,
+		Redirect:       j.Redirect,
+		OtherModifiers: j.OtherModifiers,
+	}
+	return nil
+}
+
+type resultJSON struct {
+	Type        ResultType      `json:"type"`
+	Error       string          `json:"error,omitempty"`
+	Explanation string          `json:"explanation,omitempty"`
+	DNSQueries  int             `json:"dns_queries"`
+	VoidLookups int             `json:"void_lookups"`
+	UsedHelo    bool            `json:"used_helo"`
+	Mechanism   json.RawMessage `json:"mechanism,omitempty"`
+	IP          net.IP          `json:"ip,omitempty"`
+	Sender      string          `json:"sender,omitempty"`
+	Helo        string          `json:"helo,omitempty"`
+	Trace       *TraceNode      `json:"trace,omitempty"`
+}
+
+// MarshalJSON encodes a Result, including the client IP, sender and HELO
+// name that were checked.
+func (r Result) MarshalJSON() ([]byte, error) {
+	j := resultJSON{
+		Type:        r.Type,
+		Explanation: r.Explanation,
+		DNSQueries:  r.DNSQueries,
+		VoidLookups: r.VoidLookups,
+		UsedHelo:    r.UsedHelo,
+		IP:          r.ip,
+		Sender:      r.sender,
+		Helo:        r.helo,
+		Trace:       r.Trace,
+	}
+	if r.Error != nil {
+		j.Error = r.Error.Error()
+	}
+	if r.Mechanism != nil {
+		m, err := json.Marshal(r.Mechanism)
+		if err != nil {
+			return nil, err
+		}
+		j.Mechanism = m
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON decodes a Result. Any error is recreated from its text, and
+// as the Result isn't associated with a Checker SMTPReply and
+// AuthenticationResults use the package defaults.
+func (r *Result) UnmarshalJSON(data []byte) error {
+	var j resultJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*r = Result{
+		Type:        j.Type,
+		Explanation: j.Explanation,
+		DNSQueries:  j.DNSQueries,
+		VoidLookups: j.VoidLookups,
+		UsedHelo:    j.UsedHelo,
+		Trace:       j.Trace,
+		ip:          j.IP,
+		sender:      j.Sender,
+		helo:        j.Helo,
+	}
+	if j.Error != "" {
+		r.Error = errors.New(j.Error)
+	}
+	if len(j.Mechanism) != 0 && string(j.Mechanism) != "null" {
+		m, err := UnmarshalMechanism(j.Mechanism)
+		if err != nil {
+			return err
+		}
+		r.Mechanism = m
+	}
+	return nil
+}
+
+type traceNodeJSON struct {
+	Kind      TraceKind       `json:"kind"`
+	Domain    string          `json:"domain,omitempty"`
+	Record    string          `json:"record,omitempty"`
+	Index     *int            `json:"index,omitempty"`
+	Mechanism json.RawMessage `json:"mechanism,omitempty"`
+	Target    string          `json:"target,omitempty"`
+	Macro     string          `json:"macro,omitempty"`
+	Question  *questionJSON   `json:"question,omitempty"`
+	Rcode     string          `json:"rcode,omitempty"`
+	Answer    []string        `json:"answer,omitempty"`
+	Result    *ResultType     `json:"result,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	Children  []*TraceNode    `json:"children,omitempty"`
+}
+
+type questionJSON struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// MarshalJSON encodes a TraceNode and its children. DNS answers are written
+// in zone file format.
+func (n *TraceNode) MarshalJSON() ([]byte, error) {
+	j := traceNodeJSON{
+		Kind:     n.Kind,
+		Domain:   n.Domain,
+		Record:   n.Record,
+		Target:   n.Target,
+		Macro:    n.Macro,
+		Children: n.Children,
+	}
+	switch n.Kind {
+	case TraceMechanism:
+		index := n.Index
+		j.Index = &index
+		if n.Mechanism != nil {
+			m, err := json.Marshal(n.Mechanism)
+			if err != nil {
+				return nil, err
+			}
+			j.Mechanism = m
+		}
+	case TraceDNS:
+		j.Question = &questionJSON{Name: n.Question.Name, Type: dns.TypeToString[n.Question.Qtype]}
+		if n.Rcode >= 0 {
+			j.Rcode = dns.RcodeToString[n.Rcode]
+		}
+		for _, rr := range n.Answer {
+			j.Answer = append(j.Answer, rr.String())
+		}
+	}
+	if n.Kind != TraceDNS && n.Kind != TraceMacro {
+		result := n.Result
+		j.Result = &result
+	}
+	if n.Error != nil {
+		j.Error = n.Error.Error()
+	}
+	return json.Marshal(j)
+}
+
+func (n *TraceNode) UnmarshalJSON(data []byte) error {
+	var j traceNodeJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*n = TraceNode{
+		Kind:     j.Kind,
+		Domain:   j.Domain,
+		Record:   j.Record,
+		Target:   j.Target,
+		Macro:    j.Macro,
+		Children: j.Children,
+	}
+	if j.Index != nil {
+		n.Index = *j.Index
+	}
+	if len(j.Mechanism) != 0 && string(j.Mechanism) != "null" {
+		m, err := UnmarshalMechanism(j.Mechanism)
+		if err != nil {
+			return err
+		}
+		n.Mechanism = m
+	}
+	if j.Question != nil {
+		qtype, ok := dns.StringToType[j.Question.Type]
+		if !ok {
+			return fmt.Errorf("unknown DNS type '%s'", j.Question.Type)
+		}
+		n.Question = dns.Question{Name: j.Question.Name, Qtype: qtype, Qclass: dns.ClassINET}
+	}
+	if n.Kind == TraceDNS {
+		n.Rcode = -1
+		if j.Rcode != "" {
+			rcode, ok := dns.StringToRcode[j.Rcode]
+			if !ok {
+				return fmt.Errorf("unknown DNS rcode '%s'", j.Rcode)
+			}
+			n.Rcode = rcode
+		}
+	}
+	for _, a := range j.Answer {
+		rr, err := dns.NewRR(a)
+		if err != nil {
+			return err
+		}
+		n.Answer = append(n.Answer, rr)
+	}
+	if j.Result != nil {
+		n.Result = *j.Result
+	}
+	if j.Error != "" {
+		n.Error = errors.New(j.Error)
+	}
+	return nil
+}
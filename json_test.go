@@ -0,0 +1,114 @@
+package spf_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/wttw/spf"
+	"github.com/wttw/spf/spftest"
+)
+
+func TestRecordJSON(t *testing.T) {
+	text := "v=spf1 a -a:%{d}.example.com/24 mx//64 ~mx:mail.example.com/26//48 ?ptr ip4:192.0.2.0/24 ip6:2001:db8::/32 include:_spf.example.com exists:%{i}.bl.example.com -all redirect=other.example.com exp=exp.example.com foo=bar"
+	record, err := spf.ParseSPF(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, err := json.Marshal(*record); err != nil || string(value) != string(data) {
+		t.Errorf("expected a record value to encode as %s, got %s %v", data, value, err)
+	}
+	var got spf.SPFRecord
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("%v in %s", err, data)
+	}
+	if !reflect.DeepEqual(record, &got) {
+		t.Errorf("round trip through %s\nexpected %#v\ngot %#v", data, record, &got)
+	}
+	if got.String() != text {
+		t.Errorf("expected %s, got %s", text, got.String())
+	}
+
+	var m spf.MechanismA
+	if err := json.Unmarshal([]byte(`{"type":"mx","qualifier":"pass"}`), &m); err == nil {
+		t.Error("expected error decoding mx into MechanismA")
+	}
+	for _, bad := range []string{
+		`{"qualifier":"pass"}`,
+		`{"type":"foo","qualifier":"pass"}`,
+		`{"type":"all","qualifier":"maybe"}`,
+		`{"type":"ip4","qualifier":"pass","network":"2001:db8::/32"}`,
+		`{"type":"a","qualifier":"pass","cidr4":33}`,
+	} {
+		if _, err := spf.UnmarshalMechanism([]byte(bad)); err == nil {
+			t.Errorf("expected error decoding %s", bad)
+		}
+	}
+}
+
+func TestResultJSON(t *testing.T) {
+	res := spftest.NewResolver().
+		TXT("example.com", "v=spf1 include:_spf.example.com -all").
+		TXT("_spf.example.com", "v=spf1 a:mail.example.com/28 -all").
+		A("mail.example.com", "192.0.2.1").
+		TXT("broken.example.com", "v=spf1 foo:bar -all")
+	c := spftest.NewChecker(res)
+	c.Trace = true
+	// The receiver comes from the Checker, which isn't encoded
+	c.Hostname = ""
+
+	for _, tst := range []struct {
+		ip       string
+		mailFrom string
+	}{
+		{"192.0.2.9", "user@example.com"},
+		{"198.51.100.1", "user@example.com"},
+		{"198.51.100.1", "user@broken.example.com"},
+		{"198.51.100.1", "user@missing.example.com"},
+	} {
+		result := c.SPF(context.Background(), net.ParseIP(tst.ip), tst.mailFrom, "")
+		data, err := json.Marshal(result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got spf.Result
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("%v in %s", err, data)
+		}
+		if got.Type != result.Type || got.DNSQueries != result.DNSQueries || !reflect.DeepEqual(got.Mechanism, result.Mechanism) {
+			t.Errorf("%s from %s: round trip through %s gave %+v", tst.mailFrom, tst.ip, data, got)
+		}
+		if (got.Error == nil) != (result.Error == nil) || (got.Error != nil && got.Error.Error() != result.Error.Error()) {
+			t.Errorf("%s from %s: expected error %v, got %v", tst.mailFrom, tst.ip, result.Error, got.Error)
+		}
+		if got.Trace.String() != result.Trace.String() {
+			t.Errorf("%s from %s: expected trace\n%s\ngot\n%s", tst.mailFrom, tst.ip, result.Trace, got.Trace)
+		}
+		if got.ReceivedSPF() != result.ReceivedSPF() {
+			t.Errorf("%s from %s: expected %s, got %s", tst.mailFrom, tst.ip, result.ReceivedSPF(), got.ReceivedSPF())
+		}
+	}
+}
+
+func TestResultTypeText(t *testing.T) {
+	data, err := json.Marshal(map[spf.ResultType]spf.ResultType{spf.Fail: spf.Softfail})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"fail":"softfail"}` {
+		t.Errorf("unexpected encoding %s", data)
+	}
+	var rt spf.ResultType
+	if err := rt.UnmarshalText([]byte("temperror")); err != nil || rt != spf.Temperror {
+		t.Errorf("expected temperror, got %v, %v", rt, err)
+	}
+	if err := rt.UnmarshalText([]byte("ok")); err == nil {
+		t.Error("expected error for unknown result")
+	}
+}
@@ -0,0 +1,405 @@
+package spf
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// lintResponseSize is the size of a TXT response that Lint warns about. The
+// DNS over UDP limit without EDNS0 is 512 bytes, larger responses may be
+// truncated and need to be retried over TCP.
+const lintResponseSize = 450
+
+// maxRecordDepth limits how deeply nested includes and redirects are followed
+// when walking a whole policy rather than checking a message
+const maxRecordDepth = 20
+
+// Severity ranks how serious a lint Finding is.
+type Severity int
+
+const (
+	SeverityInfo    Severity = iota // worth knowing, but not a problem
+	SeverityWarning                 // legal, but likely to cause problems
+	SeverityError                   // will cause a permerror or temperror
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// Finding is a single problem found by Lint.
+type Finding struct {
+	Severity Severity
+	Code     string // short identifier for the type of problem, e.g. "ptr"
+	Domain   string // the domain whose record has the problem
+	Term     string // the term of the record involved, if any
+	Message  string
+}
+
+func (f Finding) String() string {
+	if f.Term == "" {
+		return fmt.Sprintf("%s: %s: %s [%s]", f.Severity, f.Domain, f.Message, f.Code)
+	}
+	return fmt.Sprintf("%s: %s: %s: %s [%s]", f.Severity, f.Domain, f.Term, f.Message, f.Code)
+}
+
+// LintReport holds everything Lint found in a domain's SPF policy.
+type LintReport struct {
+	Domain      string
+	Findings    []Finding
+	DNSLookups  int // terms causing DNS lookups, across all included records
+	VoidLookups int // lookups that returned no records
+}
+
+// Worst returns the severity of the most serious finding, or SeverityInfo if
+// there are none.
+func (r *LintReport) Worst() Severity {
+	worst := SeverityInfo
+	for _, f := range r.Findings {
+		if f.Severity > worst {
+			worst = f.Severity
+		}
+	}
+	return worst
+}
+
+type linter struct {
+	c        *Checker
+	report   *LintReport
+	visiting map[string]bool // domains on the current include path
+	seen     map[string]bool // domains already reported on
+}
+
+// Lint fetches the SPF record for a domain and every record it includes or
+// redirects to, and reports problems with them. Unlike checking a message it
+// continues past errors to find as many problems as possible, and it follows
+// every branch of the policy rather than only those needed for one IP
+// address. Terms containing macros can't be followed without a message to
+// check, so they're reported and skipped.
+func (c *Checker) Lint(ctx context.Context, domain string) *LintReport {
+	l := &linter{
+		c: c,
+		report: &LintReport{
+			Domain: dns.Fqdn(domain),
+		},
+		visiting: map[string]bool{},
+		seen:     map[string]bool{},
+	}
+	if !l.record(ctx, dns.Fqdn(domain), 0) {
+		l.add(SeverityWarning, "no-record", dns.Fqdn(domain), "", "no SPF record published")
+	}
+
+	if l.report.DNSLookups > c.DNSLimit {
+		l.add(SeverityError, "lookup-limit", l.report.Domain, "",
+			fmt.Sprintf("policy needs %d DNS lookups, more than the limit of %d", l.report.DNSLookups, c.DNSLimit))
+	}
+	if l.report.VoidLookups > c.VoidQueryLimit {
+		l.add(SeverityError, "void-limit", l.report.Domain, "",
+			fmt.Sprintf("policy has %d lookups that return no records, more than the limit of %d", l.report.VoidLookups, c.VoidQueryLimit))
+	}
+	return l.report
+}
+
+func (l *linter) add(severity Severity, code, domain, term, message string) {
+	l.report.Findings = append(l.report.Findings, Finding{
+		Severity: severity,
+		Code:     code,
+		Domain:   domain,
+		Term:     term,
+		Message:  message,
+	})
+}
+
+// record lints the SPF record for a domain, and any it references. It
+// returns whether the record was found.
+func (l *linter) record(ctx context.Context, domain string, depth int) bool {
+	domain = strings.ToLower(domain)
+	if l.visiting[domain] {
+		l.add(SeverityError, "loop", domain, "", "record includes or redirects to itself")
+		return true
+	}
+	if depth > maxRecordDepth {
+		l.add(SeverityError, "depth", domain, "", "includes and redirects are nested too deeply")
+		return true
+	}
+	// Only report problems with each record once, but follow it every time
+	// it's used so that the lookups are counted correctly.
+	quiet := l.seen[domain]
+	l.seen[domain] = true
+	l.visiting[domain] = true
+	defer delete(l.visiting, domain)
+
+	add := func(severity Severity, code, term, message string) {
+		if !quiet {
+			l.add(severity, code, domain, term, message)
+		}
+	}
+
+	text, found := l.fetch(ctx, domain, add)
+	if !found {
+		return false
+	}
+	if text == "" {
+		return true
+	}
+
+	parsed := ParseSPFLenient(text)
+	for _, d := range parsed.Diagnostics {
+		message := d.Message
+		if d.Suggestion != "" {
+			message += fmt.Sprintf(" (did you mean '%s'?)", d.Suggestion)
+		}
+		add(d.Severity, "syntax", d.Term, message)
+	}
+	if !parsed.Valid() {
+		return true
+	}
+	record := parsed.Record
+
+	var all *MechanismAll
+	var nets []*net.IPNet
+	var netTerms []string
+	for _, mechanism := range record.Mechanisms {
+		term := mechanism.String()
+		if all != nil {
+			add(SeverityWarning, "after-all", term, "mechanism after \"all\" will never be evaluated")
+			continue
+		}
+		switch m := mechanism.(type) {
+		case MechanismAll:
+			all = &m
+			switch m.Qualifier {
+			case Pass:
+				add(SeverityError, "pass-all", term, "\"+all\" allows any host to send mail")
+			case Neutral:
+				add(SeverityWarning, "neutral-all", term, "\"?all\" makes no assertion about hosts not listed")
+			}
+		case MechanismIp4:
+			nets, netTerms = l.overlaps(add, m.Net, term, nets, netTerms)
+		case MechanismIp6:
+			nets, netTerms = l.overlaps(add, m.Net, term, nets, netTerms)
+		case MechanismPTR:
+			l.report.DNSLookups++
+			add(SeverityWarning, "ptr", term, "the \"ptr\" mechanism is slow, unreliable and SHOULD NOT be used")
+		case MechanismExists:
+			l.report.DNSLookups++
+		case MechanismInclude:
+			l.report.DNSLookups++
+			target, ok := l.target(m.DomainSpec, domain, term, add)
+			if !ok {
+				continue
+			}
+			if !l.record(ctx, target, depth+1) {
+				l.report.VoidLookups++
+				add(SeverityError, "include-none", term, "included domain has no SPF record, which causes a permerror")
+			}
+		case MechanismA:
+			l.report.DNSLookups++
+			target, ok := l.target(m.DomainSpec, domain, term, add)
+			if !ok {
+				continue
+			}
+			if !l.hasAddresses(ctx, target) {
+				l.report.VoidLookups++
+				add(SeverityWarning, "void-lookup", term, fmt.Sprintf("%s has no A or AAAA records", target))
+			}
+		case MechanismMX:
+			l.report.DNSLookups++
+			target, ok := l.target(m.DomainSpec, domain, term, add)
+			if !ok {
+				continue
+			}
+			l.mx(ctx, target, term, add)
+		}
+	}
+
+	if all == nil && record.Redirect == "" {
+		add(SeverityInfo, "no-all", "", "record has neither \"all\" nor \"redirect\", so defaults to neutral")
+	}
+
+	if record.Redirect != "" {
+		term := "redirect=" + record.Redirect
+		if all != nil {
+			add(SeverityWarning, "redirect-ignored", term, "redirect is ignored because the record has an \"all\" mechanism")
+		} else {
+			l.report.DNSLookups++
+			target, ok := l.target(record.Redirect, domain, term, add)
+			if ok {
+				if !l.record(ctx, target, depth+1) {
+					l.report.VoidLookups++
+					add(SeverityError, "redirect-none", term, "redirect target has no SPF record, which causes a permerror")
+				}
+			}
+		}
+	}
+	return true
+}
+
+// fetch looks up the SPF record for a domain, reporting problems with the
+// DNS response. It returns "", true if there is a record that can't be used.
+func (l *linter) fetch(ctx context.Context, domain string, add func(Severity, string, string, string)) (string, bool) {
+	r := &dns.Msg{}
+	r.SetQuestion(domain, dns.TypeTXT)
+	m, err := l.c.resolve(ctx, nil, r)
+	if err != nil {
+		add(SeverityError, "dns-error", "", fmt.Sprintf("TXT lookup failed: %v", err))
+		return "", true
+	}
+	switch m.Rcode {
+	case dns.RcodeSuccess:
+	case dns.RcodeNameError:
+		return "", false
+	default:
+		add(SeverityError, "dns-error", "", fmt.Sprintf("TXT lookup returned %s", dns.RcodeToString[m.Rcode]))
+		return "", true
+	}
+
+	var records []string
+	for _, rr := range m.Answer {
+		if txt, ok := rr.(*dns.TXT); ok {
+			record := strings.Join(txt.Txt, "")
+			if spfPrefixRe.MatchString(record) {
+				records = append(records, record)
+			}
+		}
+	}
+	if len(records) == 0 {
+		return "", false
+	}
+
+	if size := m.Len(); size >= lintResponseSize {
+		add(SeverityWarning, "response-size", "",
+			fmt.Sprintf("TXT response is %d bytes, close to or over the 512 byte limit for DNS over UDP", size))
+	}
+
+	if l.hasSPFRR(ctx, domain) {
+		add(SeverityWarning, "spf-rr", "", "record is also published using the SPF RR type, which is obsolete (RFC 7208 section 3.1)")
+	}
+
+	if len(records) > 1 {
+		add(SeverityError, "multiple-records", "", fmt.Sprintf("%d SPF records published, which causes a permerror", len(records)))
+		return "", true
+	}
+
+	if badChar := invalidCharRe.FindString(records[0]); badChar != "" {
+		add(SeverityError, "invalid-character", "", fmt.Sprintf("record contains invalid character %q", badChar[0]))
+		return "", true
+	}
+	return records[0], true
+}
+
+// target finds the domain a term refers to, if it can be known without
+// a message to check.
+func (l *linter) target(domainSpec, domain, term string, add func(Severity, string, string, string)) (string, bool) {
+	if domainSpec == "" {
+		return domain, true
+	}
+	if strings.Contains(domainSpec, "%") {
+		add(SeverityInfo, "macro", term, "contains macros, so can't be checked without a message")
+		return "", false
+	}
+	if !validDomainName(domainSpec) {
+		add(SeverityError, "invalid-domain", term, fmt.Sprintf("'%s' isn't a valid domain name", domainSpec))
+		return "", false
+	}
+	return strings.ToLower(dns.Fqdn(domainSpec)), true
+}
+
+// overlaps checks whether an ip4 or ip6 network duplicates or overlaps one
+// seen earlier in the same record.
+func (l *linter) overlaps(add func(Severity, string, string, string), n *net.IPNet, term string, nets []*net.IPNet, terms []string) ([]*net.IPNet, []string) {
+	ones, _ := n.Mask.Size()
+	for i, prev := range nets {
+		prevOnes, _ := prev.Mask.Size()
+		switch {
+		case ones == prevOnes && prev.IP.Equal(n.IP):
+			add(SeverityWarning, "duplicate-network", term, fmt.Sprintf("duplicates %s", terms[i]))
+		case prevOnes <= ones && prev.Contains(n.IP):
+			add(SeverityInfo, "overlapping-network", term, fmt.Sprintf("is within %s", terms[i]))
+		case ones < prevOnes && n.Contains(prev.IP):
+			add(SeverityInfo, "overlapping-network", term, fmt.Sprintf("contains %s", terms[i]))
+		default:
+			continue
+		}
+		break
+	}
+	return append(nets, n), append(terms, term)
+}
+
+// mx checks the hosts an "mx" mechanism refers to.
+func (l *linter) mx(ctx context.Context, target, term string, add func(Severity, string, string, string)) {
+	r := &dns.Msg{}
+	r.SetQuestion(target, dns.TypeMX)
+	m, err := l.c.resolve(ctx, nil, r)
+	if err != nil || (m.Rcode != dns.RcodeSuccess && m.Rcode != dns.RcodeNameError) {
+		add(SeverityError, "dns-error", term, fmt.Sprintf("MX lookup for %s failed", target))
+		return
+	}
+	var hosts []string
+	for _, rr := range m.Answer {
+		if mx, ok := rr.(*dns.MX); ok {
+			hosts = append(hosts, mx.Mx)
+		}
+	}
+	if len(hosts) == 0 {
+		l.report.VoidLookups++
+		add(SeverityWarning, "void-lookup", term, fmt.Sprintf("%s has no MX records", target))
+		return
+	}
+	if len(hosts) > l.c.MXAddressLimit {
+		add(SeverityError, "mx-limit", term, fmt.Sprintf("%s has %d MX records, more than the limit of %d", target, len(hosts), l.c.MXAddressLimit))
+	}
+	for _, host := range hosts {
+		if !l.hasAddresses(ctx, host) {
+			l.report.VoidLookups++
+			add(SeverityWarning, "void-lookup", term, fmt.Sprintf("MX host %s has no A or AAAA records", host))
+		}
+	}
+}
+
+// hasAddresses checks whether a hostname has any A or AAAA records
+func (l *linter) hasAddresses(ctx context.Context, hostname string) bool {
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		r := &dns.Msg{}
+		r.SetQuestion(dns.Fqdn(hostname), qtype)
+		m, err := l.c.resolve(ctx, nil, r)
+		if err != nil || m.Rcode != dns.RcodeSuccess {
+			continue
+		}
+		for _, rr := range m.Answer {
+			if rr.Header().Rrtype == qtype {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasSPFRR checks whether a domain publishes a record using the obsolete SPF
+// RR type
+func (l *linter) hasSPFRR(ctx context.Context, domain string) bool {
+	r := &dns.Msg{}
+	r.SetQuestion(domain, dns.TypeSPF)
+	m, err := l.c.resolve(ctx, nil, r)
+	if err != nil || m.Rcode != dns.RcodeSuccess {
+		return false
+	}
+	for _, rr := range m.Answer {
+		if _, ok := rr.(*dns.SPF); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package spf_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/wttw/spf"
+	"github.com/wttw/spf/spftest"
+)
+
+func TestLint(t *testing.T) {
+	res := spftest.NewResolver().
+		TXT("example.com", "v=spf1 ip4:192.0.2.0/24 ip4:192.0.2.10 ptr include:_spf.example.com a:missing.example.com +all -mx redirect=other.example.com").
+		SPF("example.com", "v=spf1 -all").
+		TXT("_spf.example.com", "v=spf1 include:example.com ip6:2001:db8::/32 ip6:2001:db8::/32 ?all").
+		TXT("broken.example.com", "v=spf1 include:none.example.com foo:bar -all").
+		TXT("none.example.com", "not an spf record").
+		NoData("missing.example.com")
+	c := spftest.NewChecker(res)
+
+	report := c.Lint(context.Background(), "example.com")
+	codes := map[string]spf.Severity{}
+	for _, f := range report.Findings {
+		codes[f.Code] = f.Severity
+	}
+	for code, severity := range map[string]spf.Severity{
+		"overlapping-network": spf.SeverityInfo,
+		"ptr":                 spf.SeverityWarning,
+		"pass-all":            spf.SeverityError,
+		"after-all":           spf.SeverityWarning,
+		"redirect-ignored":    spf.SeverityWarning,
+		"void-lookup":         spf.SeverityWarning,
+		"spf-rr":              spf.SeverityWarning,
+		"loop":                spf.SeverityError,
+		"duplicate-network":   spf.SeverityWarning,
+		"neutral-all":         spf.SeverityWarning,
+	} {
+		got, ok := codes[code]
+		if !ok {
+			t.Errorf("expected a %s finding", code)
+			continue
+		}
+		if got != severity {
+			t.Errorf("%s: expected severity %v, got %v", code, severity, got)
+		}
+	}
+	if report.Worst() != spf.SeverityError {
+		t.Errorf("expected worst severity to be error, got %v", report.Worst())
+	}
+	if report.DNSLookups != 4 {
+		t.Errorf("expected 4 DNS lookups, got %d", report.DNSLookups)
+	}
+	if report.VoidLookups != 1 {
+		t.Errorf("expected 1 void lookup, got %d", report.VoidLookups)
+	}
+
+	report = c.Lint(context.Background(), "broken.example.com")
+	if len(report.Findings) != 1 || report.Findings[0].Code != "syntax" {
+		t.Errorf("expected a single syntax finding, got %v", report.Findings)
+	}
+
+	report = c.Lint(context.Background(), "nowhere.example.com")
+	if len(report.Findings) != 1 || report.Findings[0].Code != "no-record" {
+		t.Errorf("expected a single no-record finding, got %v", report.Findings)
+	}
+}
+
+func TestLintLimits(t *testing.T) {
+	res := spftest.NewResolver().
+		TXT("example.com", "v=spf1 include:a.example.com include:b.example.com -all").
+		TXT("a.example.com", "v=spf1 a a a a a ?all").
+		TXT("b.example.com", "v=spf1 a:void1.example.com a:void2.example.com a:void3.example.com -all").
+		A("a.example.com", "192.0.2.1")
+	c := spftest.NewChecker(res)
+
+	report := c.Lint(context.Background(), "example.com")
+	var lookupLimit, voidLimit bool
+	for _, f := range report.Findings {
+		switch f.Code {
+		case "lookup-limit":
+			lookupLimit = true
+		case "void-limit":
+			voidLimit = true
+		}
+	}
+	if report.DNSLookups != 10 || lookupLimit {
+		t.Errorf("expected 10 lookups without a lookup-limit finding, got %d", report.DNSLookups)
+	}
+	if report.VoidLookups != 3 || !voidLimit {
+		t.Errorf("expected 3 void lookups with a void-limit finding, got %d", report.VoidLookups)
+	}
+}
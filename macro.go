@@ -74,7 +74,7 @@ import (
 //      r = domain name of host performing the check
 //      t = current timestamp
 
-var macroRe = regexp.MustCompile(`^{([alodiphcrtvALODIPHCRTV])([0-9]{0,3})(r?)([.+=,/_-]*)}`)
+var macroRe = regexp.MustCompile(`^{([slodiphcrtvSLODIPHCRTV])([0-9]{0,3})(r?)([.+=,/_-]*)}`)
 
 // MacroIsValid validates an SPF macro.
 func MacroIsValid(macroString string) bool {
@@ -105,6 +105,9 @@ func MacroIsValid(macroString string) bool {
 // ExpandMacro populates an SPF macro based on the current state of the check process.
 func (c *Checker) ExpandMacro(ctx context.Context, domainSpec string, result *Result, domain string, exp bool) (string, error) {
 	expansion, err := c.expandMacro(ctx, domainSpec, result, domain, exp)
+	if strings.Contains(domainSpec, "%") {
+		result.traceAdd(&TraceNode{Kind: TraceMacro, Macro: domainSpec, Target: expansion, Error: err})
+	}
 	if c.Hook != nil {
 		c.Hook.Macro(domainSpec, expansion, err)
 	}
@@ -117,12 +120,17 @@ func (c *Checker) expandMacro(ctx context.Context, domainSpec string, result *Re
 		// short circuit common case
 		return domainSpec, nil
 	}
+	macro := domainSpec
 	var ret strings.Builder
 	for {
 		ret.WriteString(domainSpec[:percent])
 		domainSpec = domainSpec[percent+1:]
+		offset := len(macro) - len(domainSpec) - 1 // of the %, for errors
+		macroErr := func(reason string) error {
+			return &MacroError{Macro: macro, Offset: offset, Reason: reason}
+		}
 		if len(domainSpec) == 0 {
-			return "", errors.New("trailing % in macro expansion")
+			return "", macroErr("trailing %")
 		}
 		switch domainSpec[0] {
 		case '%':
@@ -135,20 +143,33 @@ func (c *Checker) expandMacro(ctx context.Context, domainSpec string, result *Re
 			ret.WriteRune(' ')
 			domainSpec = domainSpec[1:]
 		default:
-			return "", fmt.Errorf("invalid character '%c' following %% in macro expansion", domainSpec[0])
+			return "", macroErr(fmt.Sprintf("invalid character '%c' following %%", domainSpec[0]))
 		case '{':
 			matches := macroRe.FindStringSubmatch(domainSpec)
 			if len(matches) == 0 {
-				return "", fmt.Errorf("invalid macro starting near %s", domainSpec)
+				return "", macroErr("invalid macro")
 			}
 			macroLetter, macroLimit, macroReverse, macroDelimiters := matches[1], matches[2], matches[3], matches[4]
 			domainSpec = domainSpec[len(matches[0]):]
 			var replacement string
 			switch strings.ToLower(macroLetter) {
-			case "s":
-				replacement = result.sender
-			case "l":
-				replacement = result.sender[:strings.LastIndex(result.sender, "@")]
+			case "s", "l":
+				// A sender with no local-part has "postmaster" substituted,
+				// as in check_host()
+				sender := result.sender
+				at := strings.LastIndex(sender, "@")
+				if at <= 0 {
+					sender = "postmaster@" + sender[at+1:]
+					at = len("postmaster")
+				}
+				local := sender[:at]
+				if !exp && !isASCII(local) {
+					return "", ErrNonASCIILocalPart
+				}
+				replacement = sender
+				if strings.ToLower(macroLetter) == "l" {
+					replacement = local
+				}
 			case "o":
 				replacement = strings.TrimSuffix(result.sender[strings.LastIndex(result.sender, "@")+1:], ".")
 			case "d":
@@ -175,17 +196,17 @@ func (c *Checker) expandMacro(ctx context.Context, domainSpec string, result *Re
 				replacement = result.helo
 			case "c":
 				if !exp {
-					return "", errors.New("c macro not allowed outside exp")
+					return "", macroErr("c macro not allowed outside exp")
 				}
 				replacement = result.ip.String()
 			case "r":
 				if !exp {
-					return "", errors.New("r macro not allowed outside exp")
+					return "", macroErr("r macro not allowed outside exp")
 				}
 				replacement = c.Hostname
 			case "t":
 				if !exp {
-					return "", errors.New("t macro not allowed outside exp")
+					return "", macroErr("t macro not allowed outside exp")
 				}
 				replacement = strconv.FormatInt(time.Now().Unix(), 10)
 			case "v":
@@ -244,6 +265,7 @@ func (c *Checker) expandMacro(ctx context.Context, domainSpec string, result *Re
 // the result is a valid-appearing hostname.
 func (c *Checker) ExpandDomainSpec(ctx context.Context, domainSpec string, result *Result, domain string, exp bool) (string, error) {
 	if domainSpec == "" {
+		result.traceTarget(domain)
 		return domain, nil
 	}
 	target, err := c.ExpandMacro(ctx, domainSpec, result, domain, exp)
@@ -252,17 +274,20 @@ func (c *Checker) ExpandDomainSpec(ctx context.Context, domainSpec string, resul
 	}
 	length := len(target)
 	if length <= 253 {
+		result.traceTarget(target)
 		return target, nil
 	}
 	parts := strings.Split(target, ".")
 	for {
 		if len(parts) == 0 {
-			return "", errors.New("oddly long TLD")
+			return "", &DomainError{Name: target, Err: errors.New("oddly long TLD")}
 		}
 		length = length - len(parts[0]) - 1
 		parts = parts[1:]
 		if length <= 253 {
-			return strings.Join(parts, "."), nil
+			target = strings.Join(parts, ".")
+			result.traceTarget(target)
+			return target, nil
 		}
 	}
 }
@@ -0,0 +1,53 @@
+package spf_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/wttw/spf"
+	"github.com/wttw/spf/spftest"
+)
+
+func TestMacroLetters(t *testing.T) {
+	for _, tst := range []struct {
+		macro string
+		valid bool
+	}{
+		{"%{s}", true},
+		{"%{S}", true},
+		{"%{l1r-}.%{o}", true},
+		{"%{a}", false},
+		{"%{A}", false},
+	} {
+		if got := spf.MacroIsValid(tst.macro); got != tst.valid {
+			t.Errorf("%s: expected valid %v, got %v", tst.macro, tst.valid, got)
+		}
+	}
+
+	res := spftest.NewResolver().
+		TXT("example.com", "v=spf1 -all exp=exp.example.com").
+		TXT("exp.example.com", "%{s} is not allowed").
+		TXT("bad.example.com", "v=spf1 a:%{a}.example.com -all")
+	c := spftest.NewChecker(res)
+
+	result := spftest.AssertResult(t, c, "192.0.2.1", "user@example.com", "", spf.Fail)
+	if want := "user@example.com is not allowed"; result.Explanation != want {
+		t.Errorf("expected explanation %q, got %q", want, result.Explanation)
+	}
+	result = spftest.AssertResult(t, c, "192.0.2.1", "user@bad.example.com", "", spf.Permerror)
+	if !errors.Is(result.Error, spf.ErrMacroSyntax) {
+		t.Errorf("expected macro syntax error, got %v", result.Error)
+	}
+
+	// A sender with no local-part is treated as postmaster
+	for macro, want := range map[string]string{
+		"%{s}": "postmaster@",
+		"%{l}": "postmaster",
+	} {
+		got, err := c.ExpandMacro(context.Background(), macro, &spf.Result{}, "example.com", false)
+		if err != nil || got != want {
+			t.Errorf("%s: expected %q, got %q %v", macro, want, got, err)
+		}
+	}
+}
@@ -80,11 +80,11 @@ func (m MechanismInclude) Evaluate(ctx context.Context, result *Result, domain s
 	dom, err := result.c.ExpandDomainSpec(ctx, m.DomainSpec, result, domain, false)
 
 	if err != nil {
-		return Permerror, err
+		return expansionResult(err), err
 	}
 
 	if !validDomainName(dom) {
-		return None, fmt.Errorf("invalid hostname '%s'", dom)
+		return None, &DomainError{Name: dom}
 	}
 	includeResult := result.c.checkHost(ctx, result, dns.Fqdn(dom), true, false)
 
@@ -93,10 +93,11 @@ func (m MechanismInclude) Evaluate(ctx context.Context, result *Result, domain s
 		return m.Qualifier, nil
 	case Fail, Softfail, Neutral:
 		return None, nil
-	case Temperror:
-		return Temperror, nil
-	case Permerror, None:
-		return Permerror, nil
+	case Temperror, Permerror:
+		// The included record's error
+		return includeResult, result.Error
+	case None:
+		return Permerror, &RecordError{Location: Location{Domain: dns.Fqdn(dom)}}
 	}
 	return Permerror, errors.New("unhandled case in MechanismInclude")
 }
@@ -138,10 +139,10 @@ func (m MechanismA) Evaluate(ctx context.Context, result *Result, domain string)
 
 	target, err := result.c.ExpandDomainSpec(ctx, m.DomainSpec, result, domain, false)
 	if err != nil {
-		return Permerror, err
+		return expansionResult(err), err
 	}
 	if !validDomainName(target) {
-		return None, fmt.Errorf("invalid hostname '%s'", target)
+		return None, &DomainError{Name: target}
 	}
 
 	rrs, resultType, err := result.c.lookupDNS(ctx, target, qtype, result)
@@ -208,10 +209,10 @@ func (m MechanismMX) Evaluate(ctx context.Context, result *Result, domain string
 
 	target, err := result.c.ExpandDomainSpec(ctx, m.DomainSpec, result, domain, false)
 	if err != nil {
-		return Permerror, err
+		return expansionResult(err), err
 	}
 	if !validDomainName(target) {
-		return None, fmt.Errorf("invalid hostname '%s'", target)
+		return None, &DomainError{Name: target}
 	}
 
 	mxrrs, resultType, err := result.c.lookupDNS(ctx, target, dns.TypeMX, result)
@@ -224,7 +225,7 @@ func (m MechanismMX) Evaluate(ctx context.Context, result *Result, domain string
 		mx := mxrr.(*dns.MX)
 		mxcount++
 		if mxcount > result.c.MXAddressLimit {
-			return Permerror, fmt.Errorf("limit of %d MX results exceeded for %s", result.c.MXAddressLimit, target)
+			return Permerror, &LimitError{Err: ErrMXLimit, Limit: result.c.MXAddressLimit, Target: target}
 		}
 		addresses, resultType, err := result.c.lookupAddresses(ctx, mx.Mx, qtype, result)
 		if resultType != None {
@@ -328,10 +329,10 @@ func (m MechanismExists) Evaluate(ctx context.Context, result *Result, domain st
 	result.DNSQueries++
 	target, err := result.c.ExpandDomainSpec(ctx, m.DomainSpec, result, domain, false)
 	if err != nil {
-		return Permerror, err
+		return expansionResult(err), err
 	}
 	if !validDomainName(target) {
-		return None, fmt.Errorf("invalid hostname '%s'", target)
+		return None, &DomainError{Name: target}
 	}
 	arecs, resultType, err := result.c.lookupAddresses(ctx, target, dns.TypeA, result)
 	if resultType != None {
@@ -0,0 +1,296 @@
+/*
+Package milter is a Sendmail milter that checks SPF for each message, for
+use with Sendmail or Postfix.
+
+It checks the HELO and MAIL FROM identities when it sees the MAIL FROM
+command, then rejects, defers or accepts the message according to a Policy.
+Accepted messages have Authentication-Results and Received-SPF headers
+added. Any Authentication-Results headers already in the message that claim
+to be from this host are removed.
+
+	s := milter.NewServer(spf.NewChecker())
+	s.Policy[spf.Softfail] = milter.Reject
+	l, err := net.Listen("tcp", "127.0.0.1:8891")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Fatalln(s.Serve(l))
+*/
+package milter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wttw/spf"
+)
+
+// DefaultTimeout is how long to wait for the MTA to send each command.
+const DefaultTimeout = 5 * time.Minute
+
+// Server is a milter that checks SPF.
+type Server struct {
+	Checker *spf.Checker
+	Policy  Policy
+
+	// AuthServID identifies this host in Authentication-Results headers.
+	// If empty, Checker.Hostname is used.
+	AuthServID string
+
+	AuthenticationResults bool // add an Authentication-Results header
+	ReceivedSPF           bool // add a Received-SPF header
+
+	Timeout  time.Duration // how long to wait for each command
+	ErrorLog *log.Logger   // if nil, errors are logged with the log package
+}
+
+// NewServer creates a Server using the DefaultPolicy and adding both
+// headers.
+func NewServer(c *spf.Checker) *Server {
+	return &Server{
+		Checker:               c,
+		Policy:                DefaultPolicy(),
+		AuthenticationResults: true,
+		ReceivedSPF:           true,
+		Timeout:               DefaultTimeout,
+	}
+}
+
+// Serve accepts connections from MTAs on l, handling each in a new
+// goroutine. It returns when l.Accept fails.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := s.ServeConn(context.Background(), conn); err != nil {
+				s.logf("milter connection from %s: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// ServeConn speaks the milter protocol on a single connection, until the
+// MTA quits or the connection fails. It closes conn before returning.
+func (s *Server) ServeConn(ctx context.Context, conn net.Conn) error {
+	defer conn.Close()
+	sess := &session{s: s, conn: conn}
+	for {
+		if s.Timeout > 0 {
+			_ = conn.SetReadDeadline(time.Now().Add(s.Timeout))
+		}
+		p, err := readPacket(conn)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		done, err := sess.handle(ctx, p)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+func (s *Server) authServID() string {
+	if s.AuthServID != "" {
+		return s.AuthServID
+	}
+	return s.Checker.Hostname
+}
+
+// session is the state of one connection from the MTA.
+type session struct {
+	s    *Server
+	conn net.Conn
+	ip   net.IP
+	helo string
+
+	// Per message
+	result  *spf.Result
+	arCount int   // Authentication-Results headers seen
+	forged  []int // indexes of those claiming to be from us
+}
+
+// reset clears the per message state.
+func (sess *session) reset() {
+	sess.result = nil
+	sess.arCount = 0
+	sess.forged = nil
+}
+
+func (sess *session) send(code byte, data []byte) error {
+	return writePacket(sess.conn, code, data)
+}
+
+// handle handles a single command, returning true when the connection
+// should be closed.
+func (sess *session) handle(ctx context.Context, p packet) (bool, error) {
+	switch p.code {
+	case cmdOptNeg:
+		mta, err := parseOptNeg(p)
+		if err != nil {
+			return true, err
+		}
+		if mta.version < minProtocolVersion {
+			return true, fmt.Errorf("unsupported milter protocol version %d", mta.version)
+		}
+		ours := optNeg{
+			version:  protocolVersion,
+			actions:  (actAddHeaders | actChangeHeaders) & mta.actions,
+			protocol: (protoNoRcpt | protoNoBody | protoNoEOH | protoNoUnknown | protoNoData) & mta.protocol,
+		}
+		if mta.version < ours.version {
+			ours.version = mta.version
+		}
+		return false, sess.send(respOptNeg, ours.bytes())
+	case cmdMacro:
+		// Macros don't get a response
+		return false, nil
+	case cmdConnect:
+		sess.reset()
+		sess.ip = nil
+		// hostname NUL, family, 2 byte port, address NUL
+		if nul := bytes.IndexByte(p.data, 0); nul != -1 && len(p.data) > nul+4 {
+			family := p.data[nul+1]
+			if family == familyInet || family == familyInet6 {
+				addr := bytes.TrimSuffix(p.data[nul+4:], []byte{0})
+				sess.ip = net.ParseIP(string(addr))
+			}
+		}
+		if sess.ip == nil {
+			// Not a TCP connection, so there's nothing to check
+			return false, sess.send(respAccept, nil)
+		}
+		return false, sess.send(respContinue, nil)
+	case cmdHelo:
+		if args := p.strings(); len(args) > 0 {
+			sess.helo = args[0]
+		}
+		return false, sess.send(respContinue, nil)
+	case cmdMail:
+		return false, sess.mail(ctx, p)
+	case cmdHeader:
+		args := p.strings()
+		if len(args) == 2 && strings.EqualFold(args[0], "Authentication-Results") {
+			sess.arCount++
+			ar, err := spf.ParseAuthenticationResults(args[1])
+			if err == nil && strings.EqualFold(ar.AuthServID, sess.s.authServID()) {
+				sess.forged = append(sess.forged, sess.arCount)
+			}
+		}
+		return false, sess.send(respContinue, nil)
+	case cmdEOB:
+		err := sess.endOfMessage()
+		sess.reset()
+		return false, err
+	case cmdAbort:
+		// Abort doesn't get a response
+		sess.reset()
+		return false, nil
+	case cmdQuit:
+		return true, nil
+	case cmdQuitNC:
+		// The MTA will reuse the connection for a new SMTP connection
+		sess.reset()
+		sess.ip = nil
+		sess.helo = ""
+		return false, nil
+	case cmdRcpt, cmdData, cmdEOH, cmdBody, cmdUnknown:
+		return false, sess.send(respContinue, nil)
+	}
+	return true, fmt.Errorf("unknown milter command '%c'", p.code)
+}
+
+// mail checks SPF when the MTA sees MAIL FROM, and rejects the message if
+// the policy says to.
+func (sess *session) mail(ctx context.Context, p packet) error {
+	sess.reset()
+	args := p.strings()
+	if len(args) == 0 {
+		return errors.New("MAIL FROM with no sender")
+	}
+	sender := strings.TrimSuffix(strings.TrimPrefix(args[0], "<"), ">")
+	result := sess.s.Checker.SPF(ctx, sess.ip, sender, sess.helo)
+	sess.result = &result
+
+	action := sess.s.Policy.Action(result.Type)
+	if action == Tag {
+		return sess.send(respContinue, nil)
+	}
+	reply := rejection(result.SMTPReply(ctx), action)
+	return sess.send(respReplyCode, nulStrings(replyCode(reply)))
+}
+
+// rejection makes sure a reply has a 5xx code when rejecting or a 4xx code
+// when deferring, as the policy may reject results that SMTPReply accepts.
+func rejection(r spf.SMTPReply, action Action) spf.SMTPReply {
+	switch action {
+	case Reject:
+		if r.Code < 500 {
+			r.Code, r.Enhanced = 550, "5.7.1"
+		}
+	case Tempfail:
+		if r.Code >= 500 {
+			r.Code -= 100
+			r.Enhanced = "4" + r.Enhanced[1:]
+		}
+		if r.Code < 400 {
+			r.Code, r.Enhanced = 451, "4.7.1"
+		}
+	}
+	return r
+}
+
+// replyCode formats a reply for SMFIR_REPLYCODE. The MTA treats the text
+// like a printf format, so any % must be doubled.
+func replyCode(r spf.SMTPReply) string {
+	return strings.ReplaceAll(r.String(), "%", "%%")
+}
+
+// endOfMessage removes forged headers and adds ours.
+func (sess *session) endOfMessage() error {
+	if sess.result == nil {
+		return sess.send(respContinue, nil)
+	}
+	// Later headers first, in case removing one renumbers the rest
+	sort.Sort(sort.Reverse(sort.IntSlice(sess.forged)))
+	for _, index := range sess.forged {
+		if err := sess.send(respChgHeader, headerIndex(uint32(index), "Authentication-Results", "")); err != nil {
+			return err
+		}
+	}
+	// Each header is inserted at the top, so Authentication-Results
+	// ends up above Received-SPF
+	if sess.s.ReceivedSPF {
+		if err := sess.send(respInsHeader, headerIndex(0, "Received-SPF", sess.result.ReceivedSPF())); err != nil {
+			return err
+		}
+	}
+	if sess.s.AuthenticationResults {
+		ar := spf.NewAuthenticationResults(sess.s.authServID(), *sess.result).String()
+		if err := sess.send(respInsHeader, headerIndex(0, "Authentication-Results", ar)); err != nil {
+			return err
+		}
+	}
+	return sess.send(respContinue, nil)
+}
@@ -0,0 +1,177 @@
+package milter_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/wttw/spf"
+	"github.com/wttw/spf/milter"
+	"github.com/wttw/spf/spftest"
+)
+
+// client is a stand-in for the MTA end of a milter connection.
+type client struct {
+	t    *testing.T
+	conn net.Conn
+}
+
+type response struct {
+	code byte
+	data []byte
+}
+
+func (c *client) send(code byte, data ...[]byte) {
+	c.t.Helper()
+	payload := bytes.Join(data, nil)
+	buf := make([]byte, 5)
+	binary.BigEndian.PutUint32(buf, uint32(len(payload)+1))
+	buf[4] = code
+	if _, err := c.conn.Write(append(buf, payload...)); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *client) read() response {
+	c.t.Helper()
+	var length uint32
+	if err := binary.Read(c.conn, binary.BigEndian, &length); err != nil {
+		c.t.Fatal(err)
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(c.conn, buf); err != nil {
+		c.t.Fatal(err)
+	}
+	return response{code: buf[0], data: buf[1:]}
+}
+
+// command sends a command and checks the code of the response.
+func (c *client) command(want byte, code byte, data ...[]byte) response {
+	c.t.Helper()
+	c.send(code, data...)
+	r := c.read()
+	if r.code != want {
+		c.t.Fatalf("command '%c': expected response '%c', got '%c' %q", code, want, r.code, r.data)
+	}
+	return r
+}
+
+func nul(s ...string) []byte {
+	return []byte(strings.Join(s, "\x00") + "\x00")
+}
+
+func uint32s(v ...uint32) []byte {
+	buf := make([]byte, 4*len(v))
+	for i, n := range v {
+		binary.BigEndian.PutUint32(buf[i*4:], n)
+	}
+	return buf
+}
+
+// connect starts a milter session for a client at ip, which may be empty
+// for a local connection.
+func connect(t *testing.T, s *milter.Server, ip string) (*client, byte) {
+	server, conn := net.Pipe()
+	go func() {
+		_ = s.ServeConn(context.Background(), server)
+	}()
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	c := &client{t: t, conn: conn}
+	c.command('O', 'O', uint32s(6, 0x1ff, 0x1fffff))
+	family := []byte("4\x00\x19")
+	if ip == "" {
+		family = []byte("L\x00\x00")
+	}
+	c.send('C', nul("client.example.net"), family, nul(ip))
+	return c, c.read().code
+}
+
+func TestMilter(t *testing.T) {
+	res := spftest.NewResolver().
+		TXT("example.com", "v=spf1 ip4:192.0.2.0/24 ~all").
+		TXT("fail.example.com", "v=spf1 -all").
+		Timeout("broken.example.com")
+	c := spftest.NewChecker(res)
+	c.Hostname = "mx.example.org"
+	s := milter.NewServer(c)
+
+	// Pass, with a forged Authentication-Results header
+	cl, code := connect(t, s, "192.0.2.1")
+	if code != 'c' {
+		t.Fatalf("expected continue after connect, got '%c'", code)
+	}
+	cl.command('c', 'H', nul("client.example.net"))
+	cl.command('c', 'M', nul("<user@example.com>", "SIZE=100"))
+	cl.command('c', 'L', nul("Authentication-Results", "other.example.org; spf=pass smtp.mailfrom=user@example.com"))
+	cl.command('c', 'L', nul("Authentication-Results", "mx.example.org; spf=pass smtp.mailfrom=forged@example.com"))
+	cl.command('c', 'L', nul("Subject", "hello"))
+	cl.send('E')
+	r := cl.read()
+	if r.code != 'm' || !bytes.Equal(r.data, append(uint32s(2), nul("Authentication-Results", "")...)) {
+		t.Errorf("expected forged header 2 to be removed, got '%c' %q", r.code, r.data)
+	}
+	r = cl.read()
+	if r.code != 'i' || !bytes.HasPrefix(r.data, append(uint32s(0), "Received-SPF\x00pass "...)) {
+		t.Errorf("expected Received-SPF, got '%c' %q", r.code, r.data)
+	}
+	r = cl.read()
+	want := append(uint32s(0), nul("Authentication-Results", "mx.example.org; spf=pass smtp.mailfrom=user@example.com")...)
+	if r.code != 'i' || !bytes.Equal(r.data, want) {
+		t.Errorf("expected Authentication-Results\n%q\ngot '%c'\n%q", want, r.code, r.data)
+	}
+	if r = cl.read(); r.code != 'c' {
+		t.Errorf("expected continue after end of message, got '%c'", r.code)
+	}
+	cl.send('Q')
+
+	// Fail is rejected, temperror deferred and softfail tagged by default
+	cl, _ = connect(t, s, "198.51.100.1")
+	cl.command('c', 'H', nul("client.example.net"))
+	r = cl.command('y', 'M', nul("<user@fail.example.com>"))
+	if !strings.HasPrefix(string(r.data), "550 5.7.23 ") {
+		t.Errorf("expected 550 5.7.23, got %q", r.data)
+	}
+	cl.send('A')
+	r = cl.command('y', 'M', nul("<user@broken.example.com>"))
+	if !strings.HasPrefix(string(r.data), "451 4.7.24 ") {
+		t.Errorf("expected 451 4.7.24, got %q", r.data)
+	}
+	cl.send('A')
+	cl.command('c', 'M', nul("<user@example.com>"))
+	cl.send('A')
+
+	// A stricter policy rejects softfail too
+	s.Policy[spf.Softfail] = milter.Reject
+	r = cl.command('y', 'M', nul("<user@example.com>"))
+	if !strings.HasPrefix(string(r.data), "550 5.7.1 ") {
+		t.Errorf("expected 550 5.7.1, got %q", r.data)
+	}
+
+	// Local connections aren't checked
+	_, code = connect(t, s, "")
+	if code != 'a' {
+		t.Errorf("expected local connection to be accepted, got '%c'", code)
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	p, err := milter.ParsePolicy("softfail=reject, permerror=tempfail,temperror=tag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "fail=reject,permerror=tempfail,softfail=reject,temperror=tag"
+	if p.String() != want {
+		t.Errorf("expected %s, got %s", want, p)
+	}
+	for _, bad := range []string{"softfail", "sortafail=reject", "fail=bounce"} {
+		if _, err := milter.ParsePolicy(bad); err == nil {
+			t.Errorf("expected error parsing %s", bad)
+		}
+	}
+}
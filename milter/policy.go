@@ -0,0 +1,100 @@
+package milter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/wttw/spf"
+)
+
+// Action is what the milter does with a message after checking SPF.
+type Action int
+
+const (
+	// Tag accepts the message, adding headers recording the result.
+	Tag Action = iota
+	// Reject rejects the message at MAIL FROM with a 5xx reply.
+	Reject
+	// Tempfail rejects the message at MAIL FROM with a 4xx reply, so
+	// that the sender will retry later.
+	Tempfail
+)
+
+var actionNames = map[Action]string{
+	Tag:      "tag",
+	Reject:   "reject",
+	Tempfail: "tempfail",
+}
+
+func (a Action) String() string {
+	if name, ok := actionNames[a]; ok {
+		return name
+	}
+	return fmt.Sprintf("Action(%d)", int(a))
+}
+
+// ParseAction parses the name of an Action.
+func ParseAction(s string) (Action, error) {
+	for a, name := range actionNames {
+		if strings.EqualFold(s, name) {
+			return a, nil
+		}
+	}
+	return Tag, fmt.Errorf("unknown action '%s'", s)
+}
+
+// Policy gives the Action for each SPF result. Results that aren't listed
+// are tagged.
+type Policy map[spf.ResultType]Action
+
+// DefaultPolicy rejects mail that fails, defers mail with temporary errors
+// and tags everything else, as RFC 7208 section 8 suggests.
+func DefaultPolicy() Policy {
+	return Policy{
+		spf.Fail:      Reject,
+		spf.Temperror: Tempfail,
+	}
+}
+
+// Action returns the action for a result.
+func (p Policy) Action(r spf.ResultType) Action {
+	return p[r]
+}
+
+// ParsePolicy parses a comma separated list of result=action pairs, such
+// as "fail=reject,softfail=tag,temperror=tempfail", starting from the
+// DefaultPolicy.
+func ParsePolicy(s string) (Policy, error) {
+	ret := DefaultPolicy()
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		eq := strings.Index(pair, "=")
+		if eq == -1 {
+			return nil, fmt.Errorf("expected result=action, not '%s'", pair)
+		}
+		result, err := spf.ResultTypeString(strings.ToLower(strings.TrimSpace(pair[:eq])))
+		if err != nil {
+			return nil, fmt.Errorf("unknown result '%s'", pair[:eq])
+		}
+		action, err := ParseAction(strings.TrimSpace(pair[eq+1:]))
+		if err != nil {
+			return nil, err
+		}
+		ret[result] = action
+	}
+	return ret, nil
+}
+
+// String returns the policy in the form ParsePolicy accepts.
+func (p Policy) String() string {
+	var pairs []string
+	for result, action := range p {
+		pairs = append(pairs, result.String()+"="+action.String())
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,163 @@
+package milter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The milter protocol version spoken, and the oldest accepted
+const (
+	protocolVersion    = 6
+	minProtocolVersion = 2
+)
+
+// maxPacket is the largest packet that will be read. Body chunks are no
+// longer than 65535 bytes, but headers can be longer.
+const maxPacket = 1 << 20
+
+// Commands sent by the MTA
+const (
+	cmdOptNeg  = 'O'
+	cmdMacro   = 'D'
+	cmdConnect = 'C'
+	cmdHelo    = 'H'
+	cmdMail    = 'M'
+	cmdRcpt    = 'R'
+	cmdData    = 'T'
+	cmdHeader  = 'L'
+	cmdEOH     = 'N'
+	cmdBody    = 'B'
+	cmdEOB     = 'E'
+	cmdAbort   = 'A'
+	cmdQuit    = 'Q'
+	cmdQuitNC  = 'K'
+	cmdUnknown = 'U'
+)
+
+// Responses sent by the filter
+const (
+	respOptNeg    = 'O'
+	respContinue  = 'c'
+	respAccept    = 'a'
+	respReplyCode = 'y'
+	respInsHeader = 'i'
+	respChgHeader = 'm'
+)
+
+// Actions the filter may take, negotiated with SMFIC_OPTNEG
+const (
+	actAddHeaders    = 0x01
+	actChangeHeaders = 0x10
+)
+
+// Protocol steps the filter doesn't need to see, negotiated with
+// SMFIC_OPTNEG
+const (
+	protoNoRcpt    = 0x08
+	protoNoBody    = 0x10
+	protoNoEOH     = 0x40
+	protoNoUnknown = 0x100
+	protoNoData    = 0x200
+)
+
+// Address families in SMFIC_CONNECT
+const (
+	familyInet  = '4'
+	familyInet6 = '6'
+)
+
+var errPacketTooLong = errors.New("milter packet too long")
+
+// packet is a single milter protocol message.
+type packet struct {
+	code byte
+	data []byte
+}
+
+// readPacket reads a packet: a four byte big-endian length, then a one
+// byte command code and length-1 bytes of data.
+func readPacket(r io.Reader) (packet, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return packet{}, err
+	}
+	if length == 0 {
+		return packet{}, errors.New("empty milter packet")
+	}
+	if length > maxPacket {
+		return packet{}, errPacketTooLong
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return packet{}, err
+	}
+	return packet{code: buf[0], data: buf[1:]}, nil
+}
+
+// writePacket writes a packet with the given code and data.
+func writePacket(w io.Writer, code byte, data []byte) error {
+	buf := make([]byte, 5, 5+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(len(data)+1))
+	buf[4] = code
+	_, err := w.Write(append(buf, data...))
+	return err
+}
+
+// strings splits packet data into its NUL terminated strings.
+func (p packet) strings() []string {
+	data := bytes.TrimSuffix(p.data, []byte{0})
+	if len(data) == 0 {
+		return nil
+	}
+	parts := bytes.Split(data, []byte{0})
+	ret := make([]string, len(parts))
+	for i, part := range parts {
+		ret[i] = string(part)
+	}
+	return ret
+}
+
+// nulStrings builds packet data from NUL terminated strings.
+func nulStrings(s ...string) []byte {
+	var buf bytes.Buffer
+	for _, v := range s {
+		buf.WriteString(v)
+		buf.WriteByte(0)
+	}
+	return buf.Bytes()
+}
+
+// optNeg holds the fields of an SMFIC_OPTNEG packet.
+type optNeg struct {
+	version, actions, protocol uint32
+}
+
+func parseOptNeg(p packet) (optNeg, error) {
+	if len(p.data) < 12 {
+		return optNeg{}, fmt.Errorf("option negotiation packet too short: %d bytes", len(p.data))
+	}
+	return optNeg{
+		version:  binary.BigEndian.Uint32(p.data[0:4]),
+		actions:  binary.BigEndian.Uint32(p.data[4:8]),
+		protocol: binary.BigEndian.Uint32(p.data[8:12]),
+	}, nil
+}
+
+func (o optNeg) bytes() []byte {
+	buf := make([]byte, 12)
+	binary.BigEndian.PutUint32(buf[0:4], o.version)
+	binary.BigEndian.PutUint32(buf[4:8], o.actions)
+	binary.BigEndian.PutUint32(buf[8:12], o.protocol)
+	return buf
+}
+
+// headerIndex builds the data for SMFIR_INSHEADER and SMFIR_CHGHEADER: a
+// four byte index followed by the header name and value.
+func headerIndex(index uint32, name, value string) []byte {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, index)
+	return append(buf, nulStrings(name, value)...)
+}
@@ -0,0 +1,163 @@
+package spf
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// netResolverTTL is the TTL given to records synthesized by NetResolver,
+// which can't see the real ones
+const netResolverTTL = 60
+
+var _ Resolver = &NetResolver{}
+
+// NetResolver is a Resolver that makes its lookups through a net.Resolver,
+// for platforms where the system resolver is the only way to query DNS.
+// It looks up TXT, A, AAAA, MX and PTR records, which is all that SPF
+// checks need, and builds a DNS response from the results. Other query
+// types are answered with NOTIMP.
+//
+// net.Resolver hides some details of DNS, so some behaviour is approximated:
+//
+//   - NXDOMAIN and an empty answer can't be told apart, so both are
+//     returned as NXDOMAIN. Either counts as a void lookup, so SPF results
+//     are unaffected, but the Rcode in a Trace may be wrong.
+//   - Temporary failures and timeouts are returned as SERVFAIL, whatever
+//     the server actually said.
+//   - TTLs aren't available, so every record has a TTL of one minute and
+//     negative responses have no SOA, so a CachingResolver won't cache them.
+//   - CNAMEs are followed but not included in the answer.
+//   - A and AAAA lookups may be answered from the hosts file, and TXT
+//     strings longer than 255 bytes are split.
+//
+// A NetResolver is safe for concurrent use by multiple goroutines.
+type NetResolver struct {
+	Resolver *net.Resolver // resolver to use, net.DefaultResolver if nil
+}
+
+// Resolve performs a DNS lookup for a single question with the net.Resolver.
+func (res *NetResolver) Resolve(ctx context.Context, r *dns.Msg) (*dns.Msg, error) {
+	if len(r.Question) != 1 {
+		return nil, errors.New("NetResolver: expected exactly one question")
+	}
+	q := r.Question[0]
+	m := &dns.Msg{}
+	m.SetReply(r)
+	m.RecursionAvailable = true
+	if q.Qclass != dns.ClassINET {
+		m.Rcode = dns.RcodeNotImplemented
+		return m, nil
+	}
+
+	resolver := res.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	// A trailing dot stops net.Resolver applying the search list
+	name := dns.Fqdn(q.Name)
+	hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: netResolverTTL}
+
+	var err error
+	switch q.Qtype {
+	case dns.TypeTXT:
+		var txts []string
+		txts, err = resolver.LookupTXT(ctx, name)
+		for _, txt := range txts {
+			m.Answer = append(m.Answer, &dns.TXT{Hdr: hdr, Txt: TXTStrings(txt)})
+		}
+	case dns.TypeA, dns.TypeAAAA:
+		var addrs []net.IPAddr
+		addrs, err = resolver.LookupIPAddr(ctx, name)
+		for _, addr := range addrs {
+			ip4 := addr.IP.To4()
+			switch {
+			case q.Qtype == dns.TypeA && ip4 != nil:
+				m.Answer = append(m.Answer, &dns.A{Hdr: hdr, A: ip4})
+			case q.Qtype == dns.TypeAAAA && ip4 == nil:
+				m.Answer = append(m.Answer, &dns.AAAA{Hdr: hdr, AAAA: addr.IP})
+			}
+		}
+	case dns.TypeMX:
+		var mxs []*net.MX
+		mxs, err = resolver.LookupMX(ctx, name)
+		for _, mx := range mxs {
+			m.Answer = append(m.Answer, &dns.MX{Hdr: hdr, Preference: mx.Pref, Mx: dns.Fqdn(mx.Host)})
+		}
+	case dns.TypePTR:
+		ip := reverseIP(name)
+		if ip == nil {
+			m.Rcode = dns.RcodeNameError
+			return m, nil
+		}
+		var names []string
+		names, err = resolver.LookupAddr(ctx, ip.String())
+		for _, ptr := range names {
+			m.Answer = append(m.Answer, &dns.PTR{Hdr: hdr, Ptr: dns.Fqdn(ptr)})
+		}
+	default:
+		m.Rcode = dns.RcodeNotImplemented
+		return m, nil
+	}
+	if err == nil {
+		return m, nil
+	}
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) {
+		return nil, err
+	}
+	switch {
+	case dnsErr.IsTemporary, dnsErr.IsTimeout:
+		m.Rcode = dns.RcodeServerFailure
+	case dnsErr.IsNotFound:
+		m.Rcode = dns.RcodeNameError
+	default:
+		// Anything else, such as a malformed response, is a server failure
+		// as far as SPF is concerned
+		m.Rcode = dns.RcodeServerFailure
+	}
+	m.Answer = nil
+	return m, nil
+}
+
+// reverseIP returns the address a reverse lookup name in in-addr.arpa or
+// ip6.arpa is for, or nil if it isn't one.
+func reverseIP(name string) net.IP {
+	labels := dns.SplitDomainName(strings.ToLower(name))
+	n := len(labels)
+	switch {
+	case n == 6 && labels[4] == "in-addr" && labels[5] == "arpa":
+		var octets [4]byte
+		for i := range octets {
+			octet, err := strconv.ParseUint(labels[3-i], 10, 8)
+			if err != nil {
+				return nil
+			}
+			octets[i] = byte(octet)
+		}
+		return net.IPv4(octets[0], octets[1], octets[2], octets[3])
+	case n == 34 && labels[32] == "ip6" && labels[33] == "arpa":
+		var sb strings.Builder
+		for i := 31; i >= 0; i-- {
+			if len(labels[i]) != 1 {
+				return nil
+			}
+			sb.WriteString(labels[i])
+		}
+		hex := sb.String()
+		var groups []string
+		for i := 0; i < len(hex); i += 4 {
+			groups = append(groups, hex[i:i+4])
+		}
+		return net.ParseIP(strings.Join(groups, ":"))
+	}
+	return nil
+}
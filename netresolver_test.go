@@ -0,0 +1,69 @@
+package spf_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/wttw/spf"
+	"github.com/wttw/spf/spftest"
+)
+
+func TestNetResolver(t *testing.T) {
+	records := spftest.NewResolver().
+		TXT("example.com", "v=spf1 include:_spf.example.com mx a:a.example.com ptr -all").
+		TXT("_spf.example.com", "v=spf1 ip4:192.0.2.0/24 -all").
+		MX("example.com", 10, "mail.example.com").
+		A("mail.example.com", "198.51.100.1").
+		AAAA("a.example.com", "2001:db8::1").
+		PTR("203.0.113.5", "host.example.com").
+		A("host.example.com", "203.0.113.5").
+		ServFail("broken.example.com")
+	addr := startServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m, err := records.Resolve(context.Background(), r)
+		if err != nil {
+			m = &dns.Msg{}
+			m.SetRcode(r, dns.RcodeServerFailure)
+		}
+		_ = w.WriteMsg(m)
+	})
+	res := &spf.NetResolver{Resolver: &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}}
+
+	c := spftest.NewChecker(res)
+	spftest.AssertResult(t, c, "192.0.2.10", "user@example.com", "", spf.Pass)
+	spftest.AssertResult(t, c, "198.51.100.1", "user@example.com", "", spf.Pass)
+	spftest.AssertResult(t, c, "2001:db8::1", "user@example.com", "", spf.Pass)
+	spftest.AssertResult(t, c, "203.0.113.5", "user@example.com", "", spf.Pass)
+	spftest.AssertResult(t, c, "203.0.113.6", "user@example.com", "", spf.Fail)
+	spftest.AssertResult(t, c, "192.0.2.10", "user@missing.example.com", "", spf.None)
+	spftest.AssertResult(t, c, "192.0.2.10", "user@broken.example.com", "", spf.Temperror)
+
+	for _, tst := range []struct {
+		name  string
+		qtype uint16
+		rcode int
+	}{
+		{"missing.example.com.", dns.TypeTXT, dns.RcodeNameError},
+		{"broken.example.com.", dns.TypeTXT, dns.RcodeServerFailure},
+		{"example.com.", dns.TypeSPF, dns.RcodeNotImplemented},
+		{"5.113.0.203.in-addr.arpa.", dns.TypePTR, dns.RcodeSuccess},
+	} {
+		r := &dns.Msg{}
+		r.SetQuestion(tst.name, tst.qtype)
+		m, err := res.Resolve(context.Background(), r)
+		if err != nil {
+			t.Errorf("%s %s: %v", tst.name, dns.Type(tst.qtype), err)
+			continue
+		}
+		if m.Rcode != tst.rcode {
+			t.Errorf("%s %s: expected %s, got %s", tst.name, dns.Type(tst.qtype), dns.RcodeToString[tst.rcode], dns.RcodeToString[m.Rcode])
+		}
+	}
+}
@@ -0,0 +1,349 @@
+package spf
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"unicode"
+)
+
+// Term is a single term of an SPF record, as it appears in the record.
+type Term struct {
+	Text      string    // the term exactly as written
+	Offset    int       // byte offset of the term in the record
+	Index     int       // position of the term in the record, counting the version as 0
+	Mechanism Mechanism // the mechanism, nil if the term is a modifier or couldn't be parsed
+}
+
+// Diagnostic is a problem with an SPF record found by ParseSPFLenient.
+type Diagnostic struct {
+	Severity Severity
+	Offset   int    // byte offset of the problem in the record
+	Length   int    // length in bytes of the text with the problem
+	Index    int    // index of the term with the problem, as in Term
+	Term     string // text of the term with the problem
+	Message  string
+
+	// Suggestion is what was probably meant, to replace the Length bytes
+	// at Offset, or empty if there's no obvious fix.
+	Suggestion string
+
+	err error
+}
+
+func (d Diagnostic) String() string {
+	ret := fmt.Sprintf("%s at offset %d: %s", d.Severity, d.Offset, d.Message)
+	if d.Suggestion != "" {
+		ret += fmt.Sprintf(" (did you mean '%s'?)", d.Suggestion)
+	}
+	return ret
+}
+
+// ParsedRecord is an SPF record parsed by ParseSPFLenient.
+type ParsedRecord struct {
+	Record      *SPFRecord // every term that could be parsed
+	Terms       []Term     // every term, including the version
+	Diagnostics []Diagnostic
+}
+
+// Valid returns whether the record has no errors, so would be accepted by
+// ParseSPF.
+func (p *ParsedRecord) Valid() bool {
+	return p.firstError() == nil
+}
+
+func (p *ParsedRecord) firstError() *Diagnostic {
+	for i := range p.Diagnostics {
+		if p.Diagnostics[i].Severity == SeverityError {
+			return &p.Diagnostics[i]
+		}
+	}
+	return nil
+}
+
+// ParseSPFLenient parses the text of an SPF record like ParseSPF but,
+// rather than stopping at the first problem, skips any terms that can't be
+// parsed and carries on. Every problem is returned as a Diagnostic, with a
+// suggested fix where there's an obvious one. Some valid but suspicious
+// terms, such as an unknown modifier named like a mechanism, are reported
+// as warnings.
+func ParseSPFLenient(s string) *ParsedRecord {
+	p := &ParsedRecord{
+		Record: &SPFRecord{},
+		Terms:  splitTerms(s),
+	}
+	if len(p.Terms) == 0 {
+		p.Diagnostics = append(p.Diagnostics, Diagnostic{
+			Severity: SeverityError,
+			Message:  "empty record",
+			err:      errors.New("empty record"),
+		})
+		return p
+	}
+
+	terms := p.Terms[1:]
+	if version := p.Terms[0]; !strings.EqualFold(version.Text, "v=spf1") {
+		d := p.diagnose(SeverityError, version, errors.New("record doesn't begin with v=spf1"))
+		lower := strings.ToLower(version.Text)
+		switch {
+		case strings.HasPrefix(lower, "v=spf2"):
+			// Sender ID, not a mistake
+		case strings.HasPrefix(lower, "v=") || strings.HasPrefix(lower, "spf"):
+			d.Suggestion = "v=spf1"
+		default:
+			// The version is missing altogether
+			d.Length = 0
+			d.Suggestion = "v=spf1 "
+			terms = p.Terms
+		}
+	}
+
+	for i := 0; i < len(terms); i++ {
+		var next *Term
+		if i+1 < len(terms) {
+			next = &terms[i+1]
+		}
+		if p.term(&terms[i], next) {
+			i++
+		}
+	}
+	return p
+}
+
+// diagnose adds a Diagnostic for the whole of a term, returning it so that
+// it can be adjusted.
+func (p *ParsedRecord) diagnose(severity Severity, t Term, err error) *Diagnostic {
+	p.Diagnostics = append(p.Diagnostics, Diagnostic{
+		Severity: severity,
+		Offset:   t.Offset,
+		Length:   len(t.Text),
+		Index:    t.Index,
+		Term:     t.Text,
+		Message:  err.Error(),
+		err:      err,
+	})
+	return &p.Diagnostics[len(p.Diagnostics)-1]
+}
+
+// term parses a single term, adding it to the record. It returns true if
+// the next term was consumed too, as the parameter of a mechanism missing
+// its colon.
+func (p *ParsedRecord) term(t *Term, next *Term) bool {
+	record := p.Record
+	matches := modifierRe.FindStringSubmatch(t.Text)
+	if matches != nil {
+		name, value := strings.ToLower(matches[1]), matches[2]
+		switch name {
+		case "redirect":
+			if record.Redirect != "" {
+				p.diagnose(SeverityError, *t, errors.New("multiple redirect modifiers"))
+				return false
+			}
+			if !validDomainSpec(value) {
+				p.diagnose(SeverityError, *t, errors.New("invalid domain-spec in redirect"))
+				return false
+			}
+			record.Redirect = value
+		case "exp":
+			if record.Exp != "" {
+				p.diagnose(SeverityError, *t, errors.New("multiple exp modifiers"))
+				return false
+			}
+			if !validDomainSpec(value) {
+				p.diagnose(SeverityError, *t, errors.New("invalid domain-spec in exp"))
+				return false
+			}
+			record.Exp = value
+		default:
+			if !MacroIsValid(value) {
+				p.diagnose(SeverityError, *t, errors.New("invalid macro-string in modifier"))
+				return false
+			}
+			if isMechanismName(name) {
+				d := p.diagnose(SeverityWarning, *t, fmt.Errorf("'%s' is an unknown modifier, not the %s mechanism", t.Text, name))
+				d.Suggestion = name + ":" + value
+				if name == "all" {
+					d.Suggestion = name
+				}
+			}
+			record.OtherModifiers = append(record.OtherModifiers, t.Text)
+		}
+		return false
+	}
+
+	m, err := NewMechanism(t.Text)
+	if err == nil {
+		t.Mechanism = m
+		record.Mechanisms = append(record.Mechanisms, m)
+		return false
+	}
+	d := p.diagnose(SeverityError, *t, err)
+
+	// "include _spf.example.com", with the parameter as the next term
+	qualifier, name, rest := splitMechanism(t.Text)
+	if rest == "" && next != nil && needsParameter(name) {
+		joined := t.Text + ":" + next.Text
+		if _, err := NewMechanism(joined); err == nil {
+			d.Length = next.Offset + len(next.Text) - t.Offset
+			d.Suggestion = joined
+			return true
+		}
+	}
+	d.Suggestion = suggestMechanism(qualifier, name, rest)
+	return false
+}
+
+// splitTerms splits a record into terms on whitespace, as strings.Fields
+// does, keeping the position of each.
+func splitTerms(s string) []Term {
+	var ret []Term
+	start := -1
+	for i, r := range s {
+		if unicode.IsSpace(r) {
+			if start >= 0 {
+				ret = append(ret, Term{Text: s[start:i], Offset: start, Index: len(ret)})
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		ret = append(ret, Term{Text: s[start:], Offset: start, Index: len(ret)})
+	}
+	return ret
+}
+
+// splitMechanism splits the text of a mechanism into its qualifier, its
+// name and the rest, which starts with ":" or "/" if it's not empty.
+func splitMechanism(s string) (string, string, string) {
+	var qualifier string
+	if len(s) > 0 && strings.ContainsRune("+-~?", rune(s[0])) {
+		qualifier, s = s[:1], s[1:]
+	}
+	separator := strings.IndexAny(s, ":/")
+	if separator == -1 {
+		return qualifier, strings.ToLower(s), ""
+	}
+	return qualifier, strings.ToLower(s[:separator]), s[separator:]
+}
+
+var mechanismNames = []string{"all", "include", "a", "mx", "ptr", "ip4", "ip6", "exists"}
+
+func isMechanismName(name string) bool {
+	for _, n := range mechanismNames {
+		if name == n {
+			return true
+		}
+	}
+	return false
+}
+
+func needsParameter(name string) bool {
+	switch name {
+	case "include", "exists", "ip4", "ip6":
+		return true
+	}
+	return false
+}
+
+// mechanismAliases are names commonly used by mistake for mechanisms and modifiers
+var mechanismAliases = map[string]string{
+	"ipv4":     "ip4",
+	"ipv6":     "ip6",
+	"inc":      "include",
+	"redirect": "redirect=",
+	"exp":      "exp=",
+}
+
+// suggestMechanism guesses what was meant by a mechanism that couldn't be
+// parsed, returning "" if it can't.
+func suggestMechanism(qualifier, name, rest string) string {
+	candidate := name
+	if alias, ok := mechanismAliases[name]; ok {
+		candidate = alias
+	} else if !isMechanismName(name) {
+		candidate = ""
+		for _, n := range mechanismNames {
+			if len(n) >= 3 && len(name) >= 3 && editDistance(name, n) == 1 {
+				candidate = n
+				break
+			}
+		}
+	}
+	if candidate == "" {
+		return ""
+	}
+	if strings.HasSuffix(candidate, "=") {
+		// A modifier written as a mechanism
+		if !strings.HasPrefix(rest, ":") || qualifier != "" {
+			return ""
+		}
+		return candidate + rest[1:]
+	}
+
+	param := strings.TrimPrefix(rest, ":")
+	var fixes []string
+	switch candidate {
+	case "all":
+		fixes = []string{""}
+	case "a", "mx", "ptr":
+		// Drop a bad cidr-length, or the whole parameter
+		domainSpec := param
+		if slash := strings.Index(param, "/"); slash != -1 {
+			domainSpec = param[:slash]
+		}
+		fixes = []string{rest, ":" + domainSpec, ""}
+	case "ip4", "ip6":
+		address := param
+		if slash := strings.Index(param, "/"); slash != -1 {
+			address = param[:slash]
+		}
+		fixes = []string{":" + param, ":" + address}
+		if ip := net.ParseIP(address); ip != nil {
+			if ip.To4() != nil {
+				candidate = "ip4"
+			} else {
+				candidate = "ip6"
+			}
+		}
+	default:
+		fixes = []string{rest}
+	}
+	for _, fix := range fixes {
+		suggestion := qualifier + candidate + fix
+		if _, err := NewMechanism(suggestion); err == nil {
+			return suggestion
+		}
+	}
+	return ""
+}
+
+// editDistance is the optimal string alignment distance between a and b,
+// counting insertions, deletions, substitutions and transpositions.
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
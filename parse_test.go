@@ -0,0 +1,66 @@
+package spf_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/wttw/spf"
+)
+
+func TestParseSPFLenient(t *testing.T) {
+	tests := []struct {
+		record     string
+		offset     int
+		length     int
+		index      int
+		suggestion string
+	}{
+		{"v=spf1 ip4:1.2.3.4/33 -all", 7, 14, 1, "ip4:1.2.3.4"},
+		{"v=spf1 ip4:2001:db8::/32 -all", 7, 17, 1, "ip6:2001:db8::/32"},
+		{"v=spf1 include _spf.example.com -all", 7, 24, 1, "include:_spf.example.com"},
+		{"v=spf1 include -all", 7, 7, 1, ""},
+		{"v=spf1 mx ~inlcude:_spf.example.com -all", 10, 25, 2, "~include:_spf.example.com"},
+		{"v=spf1 ipv4:192.0.2.0/24 -all", 7, 17, 1, "ip4:192.0.2.0/24"},
+		{"v=spf1  a:  -all", 8, 2, 1, "a"},
+		{"v=spf1 mx:example.com/40 -all", 7, 17, 1, "mx:example.com"},
+		{"v=spf1 -all:foo", 7, 8, 1, "-all"},
+		{"v=spf1 redirect:example.com", 7, 20, 1, "redirect=example.com"},
+		{"v=spf1 mx include=_spf.example.com -all", 10, 24, 2, "include:_spf.example.com"},
+		{"v=spf 1 mx -all", 0, 5, 0, "v=spf1"},
+		{"mx -all", 0, 0, 0, "v=spf1 "},
+	}
+	for _, tst := range tests {
+		p := spf.ParseSPFLenient(tst.record)
+		if len(p.Diagnostics) == 0 {
+			t.Errorf("%s: expected a diagnostic", tst.record)
+			continue
+		}
+		d := p.Diagnostics[0]
+		if d.Offset != tst.offset || d.Length != tst.length || d.Index != tst.index || d.Suggestion != tst.suggestion {
+			t.Errorf("%s: expected offset %d, length %d, index %d, suggestion %q, got %+v", tst.record, tst.offset, tst.length, tst.index, tst.suggestion, d)
+		}
+		if d.Term != p.Terms[d.Index].Text {
+			t.Errorf("%s: diagnostic is for '%s', but term %d is '%s'", tst.record, d.Term, d.Index, p.Terms[d.Index].Text)
+		}
+	}
+
+	p := spf.ParseSPFLenient("v=spf1 foo:bar a:example.com ip4:192.0.2.300 -all redirect=a.example.com redirect=b.example.com")
+	if len(p.Diagnostics) != 3 || p.Valid() {
+		t.Errorf("expected 3 diagnostics, got %v", p.Diagnostics)
+	}
+	if got := p.Record.String(); got != "v=spf1 a:example.com -all redirect=a.example.com" {
+		t.Errorf("unexpected record %s", got)
+	}
+	if len(p.Terms) != 7 || p.Terms[2].Offset != 15 || p.Terms[2].Mechanism == nil || p.Terms[3].Mechanism != nil {
+		t.Errorf("unexpected terms %+v", p.Terms)
+	}
+
+	_, err := spf.ParseSPF("v=spf1 foo:bar -all")
+	var se *spf.SyntaxError
+	if !errors.Is(err, spf.ErrSyntax) || !errors.As(err, &se) || se.Term != "foo:bar" {
+		t.Errorf("expected syntax error for foo:bar, got %v", err)
+	}
+	if _, err := spf.ParseSPF("v=spf1 mx include=_spf.example.com -all"); err != nil {
+		t.Errorf("expected warnings not to be errors, got %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package policyd
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/wttw/spf"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the contents of a YAML configuration file for a Server.
+//
+//	# Action for each result, anything not listed is left as the default
+//	actions:
+//	  fail: REJECT
+//	  softfail: DEFER_IF_PERMIT
+//	  permerror: DUNNO
+//
+//	# Relays and networks that are never checked
+//	trusted:
+//	  - 127.0.0.0/8
+//	  - ::1
+//	  - 192.0.2.10
+//
+//	hostname: mx.example.com
+//	explanation: "%{i} is not one of %{d}'s designated mail servers"
+type Config struct {
+	Actions     map[string]string `yaml:"actions"`
+	Trusted     []string          `yaml:"trusted"`
+	Hostname    string            `yaml:"hostname"`    // used in Received-SPF headers
+	Explanation string            `yaml:"explanation"` // for fail results with no exp=
+}
+
+// ReadConfig reads a configuration file.
+func ReadConfig(filename string) (*Config, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("while reading %s: %w", filename, err)
+	}
+	return cfg, nil
+}
+
+// Apply configures a Server, and its Checker, from a Config.
+func (cfg *Config) Apply(s *Server) error {
+	for name, value := range cfg.Actions {
+		result, err := spf.ResultTypeString(strings.ToLower(name))
+		if err != nil {
+			return fmt.Errorf("unknown result '%s'", name)
+		}
+		action, err := ParseAction(value)
+		if err != nil {
+			return err
+		}
+		s.Actions[result] = action
+	}
+	for _, trusted := range cfg.Trusted {
+		prefix, err := parsePrefix(trusted)
+		if err != nil {
+			return err
+		}
+		s.Trusted = append(s.Trusted, prefix)
+	}
+	if cfg.Hostname != "" {
+		s.Checker.Hostname = cfg.Hostname
+	}
+	if cfg.Explanation != "" {
+		s.Checker.DefaultExplanation = cfg.Explanation
+	}
+	return nil
+}
+
+// parsePrefix parses a CIDR prefix or a single address.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid trusted network '%s'", s)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid trusted address '%s'", s)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
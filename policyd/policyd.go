@@ -0,0 +1,236 @@
+/*
+Package policyd is a Postfix SMTPD policy delegation server that checks SPF.
+
+Postfix sends a request for each SMTP command it's configured to check,
+made up of name=value lines and ending with a blank line. The server checks
+the client_address, helo_name and sender attributes and answers with an
+action such as "REJECT" or "PREPEND Received-SPF: ...", chosen by the
+result. See https://www.postfix.org/SMTPD_POLICY_README.html
+
+	s := policyd.NewServer(spf.NewChecker())
+	s.Actions[spf.Softfail] = policyd.DeferIfPermit
+	l, err := net.Listen("tcp", "127.0.0.1:10023")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Fatalln(s.Serve(l))
+*/
+package policyd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/wttw/spf"
+)
+
+// DefaultTimeout is how long to wait for Postfix to send each request.
+// Postfix closes idle connections to policy servers after 300 seconds.
+const DefaultTimeout = 5 * time.Minute
+
+// maxLine is the longest request line that will be read
+const maxLine = 64 * 1024
+
+// Action is a Postfix access(5) action.
+type Action string
+
+const (
+	// Reject rejects the command with a 5xx reply.
+	Reject Action = "REJECT"
+	// Defer rejects the command with a 4xx reply.
+	Defer Action = "DEFER"
+	// DeferIfPermit defers the command if it would otherwise be accepted.
+	DeferIfPermit Action = "DEFER_IF_PERMIT"
+	// Prepend adds a Received-SPF header and carries on.
+	Prepend Action = "PREPEND"
+	// Dunno carries on with the next restriction.
+	Dunno Action = "DUNNO"
+	// OK accepts the command, skipping later restrictions.
+	OK Action = "OK"
+)
+
+var actions = []Action{Reject, Defer, DeferIfPermit, Prepend, Dunno, OK}
+
+// ParseAction parses the name of an Action.
+func ParseAction(s string) (Action, error) {
+	for _, a := range actions {
+		if strings.EqualFold(s, string(a)) {
+			return a, nil
+		}
+	}
+	return "", fmt.Errorf("unknown action '%s'", s)
+}
+
+// DefaultActions rejects mail that fails, defers mail with temporary
+// errors unless something else rejects it, and adds a Received-SPF header
+// to everything else.
+func DefaultActions() map[spf.ResultType]Action {
+	return map[spf.ResultType]Action{
+		spf.None:      Prepend,
+		spf.Neutral:   Prepend,
+		spf.Pass:      Prepend,
+		spf.Fail:      Reject,
+		spf.Softfail:  Prepend,
+		spf.Temperror: DeferIfPermit,
+		spf.Permerror: Prepend,
+	}
+}
+
+// Server is a Postfix policy server that checks SPF.
+type Server struct {
+	Checker *spf.Checker
+
+	// Actions gives the action for each result. Results that aren't
+	// listed get Dunno.
+	Actions map[spf.ResultType]Action
+
+	// Trusted clients, such as internal relays, aren't checked.
+	Trusted []netip.Prefix
+
+	Timeout  time.Duration // how long to wait for each request
+	ErrorLog *log.Logger   // if nil, errors are logged with the log package
+}
+
+// NewServer creates a Server using the DefaultActions.
+func NewServer(c *spf.Checker) *Server {
+	return &Server{
+		Checker: c,
+		Actions: DefaultActions(),
+		Timeout: DefaultTimeout,
+	}
+}
+
+// Serve accepts connections from Postfix on l, handling each in a new
+// goroutine. It returns when l.Accept fails.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := s.ServeConn(context.Background(), conn); err != nil {
+				s.logf("policy connection from %s: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// ServeConn answers requests on a single connection until Postfix closes
+// it. It closes conn before returning.
+func (s *Server) ServeConn(ctx context.Context, conn net.Conn) error {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), maxLine)
+	// Postfix sends one request for each recipient of a message, but the
+	// header should only be added once
+	var lastInstance string
+	var lastAction Action
+	var lastResponse string
+
+	request := map[string]string{}
+	for {
+		if s.Timeout > 0 {
+			_ = conn.SetReadDeadline(time.Now().Add(s.Timeout))
+		}
+		if !scanner.Scan() {
+			return scanner.Err()
+		}
+		line := scanner.Text()
+		if line != "" {
+			eq := strings.Index(line, "=")
+			if eq == -1 {
+				return fmt.Errorf("malformed request line '%s'", line)
+			}
+			request[line[:eq]] = line[eq+1:]
+			continue
+		}
+
+		var response string
+		instance := request["instance"]
+		switch {
+		case instance != "" && instance == lastInstance && lastAction == Prepend:
+			response = string(Dunno)
+		case instance != "" && instance == lastInstance:
+			response = lastResponse
+		default:
+			lastAction, response = s.Check(ctx, request)
+			lastInstance, lastResponse = instance, response
+		}
+		if _, err := fmt.Fprintf(conn, "action=%s\n\n", response); err != nil {
+			return err
+		}
+		request = map[string]string{}
+	}
+}
+
+// Check checks SPF for a single policy request, given as its attributes,
+// and returns the action and the response to send, without "action=".
+func (s *Server) Check(ctx context.Context, request map[string]string) (Action, string) {
+	if request["request"] != "smtpd_access_policy" {
+		return Dunno, string(Dunno)
+	}
+	addr, err := netip.ParseAddr(request["client_address"])
+	if err != nil {
+		s.logf("invalid client_address '%s' in policy request", request["client_address"])
+		return Dunno, string(Dunno)
+	}
+	addr = addr.Unmap()
+	for _, prefix := range s.Trusted {
+		if prefix.Contains(addr) {
+			return Dunno, string(Dunno)
+		}
+	}
+
+	result := s.Checker.SPF(ctx, net.IP(addr.AsSlice()), request["sender"], request["helo_name"])
+	if result.Error != nil {
+		// The response only says what failed, not why
+		s.logf("SPF %s for %s from %s: %v", result.Type, request["sender"], addr, result.Error)
+	}
+	action, ok := s.Actions[result.Type]
+	if !ok {
+		action = Dunno
+	}
+	return action, s.response(ctx, &result, action)
+}
+
+// response builds the response for a result.
+func (s *Server) response(ctx context.Context, result *spf.Result, action Action) string {
+	reply := result.SMTPReply(ctx)
+	switch action {
+	case Reject:
+		if reply.Code < 500 {
+			reply.Code, reply.Enhanced = 550, "5.7.1"
+		}
+		return reply.String()
+	case Defer, DeferIfPermit:
+		if reply.Code >= 500 {
+			reply.Code -= 100
+			reply.Enhanced = "4" + reply.Enhanced[1:]
+		}
+		if reply.Code < 400 {
+			reply.Code, reply.Enhanced = 451, "4.7.1"
+		}
+		if action == Defer {
+			return reply.String()
+		}
+		return fmt.Sprintf("%s %s %s", action, reply.Enhanced, reply.Text)
+	case Prepend:
+		return "PREPEND Received-SPF: " + result.ReceivedSPF()
+	}
+	return string(action)
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
@@ -0,0 +1,142 @@
+package policyd_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wttw/spf"
+	"github.com/wttw/spf/policyd"
+	"github.com/wttw/spf/spftest"
+)
+
+// query sends a policy request and returns the action from the response.
+func query(t *testing.T, conn net.Conn, r *bufio.Reader, attrs ...string) string {
+	t.Helper()
+	req := "request=smtpd_access_policy\nprotocol_state=RCPT\n" + strings.Join(attrs, "\n") + "\n\n"
+	if _, err := fmt.Fprint(conn, req); err != nil {
+		t.Fatal(err)
+	}
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blank, err := r.ReadString('\n'); err != nil || blank != "\n" {
+		t.Fatalf("expected blank line after response, got %q %v", blank, err)
+	}
+	if !strings.HasPrefix(line, "action=") {
+		t.Fatalf("expected action=, got %q", line)
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(line, "action="), "\n")
+}
+
+func TestServer(t *testing.T) {
+	res := spftest.NewResolver().
+		TXT("example.com", "v=spf1 ip4:192.0.2.0/24 ~all").
+		TXT("fail.example.com", "v=spf1 -all").
+		Timeout("broken.example.com")
+	c := spftest.NewChecker(res)
+	c.Hostname = "mx.example.org"
+	s := policyd.NewServer(c)
+	var logged strings.Builder
+	s.ErrorLog = log.New(&logged, "", 0)
+	s.Trusted = append(s.Trusted, netip.MustParsePrefix("10.0.0.0/8"))
+
+	server, conn := net.Pipe()
+	go func() {
+		_ = s.ServeConn(context.Background(), server)
+	}()
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	tests := []struct {
+		attrs []string
+		want  string
+	}{
+		{
+			[]string{"client_address=192.0.2.1", "helo_name=mail.example.com", "sender=user@example.com", "instance=1"},
+			"PREPEND Received-SPF: pass (mx.example.org: domain of user@example.com designates 192.0.2.1 as permitted sender) receiver=mx.example.org; client-ip=192.0.2.1; envelope-from=\"user@example.com\"; helo=mail.example.com; mechanism=\"ip4:192.0.2.0/24\"; identity=mailfrom;",
+		},
+		{
+			// Another recipient of the same message
+			[]string{"client_address=192.0.2.1", "helo_name=mail.example.com", "sender=user@example.com", "instance=1"},
+			"DUNNO",
+		},
+		{
+			[]string{"client_address=198.51.100.1", "helo_name=mail.example.com", "sender=user@fail.example.com", "instance=2"},
+			"550 5.7.23 198.51.100.1 is not one of fail.example.com's designated mail servers",
+		},
+		{
+			[]string{"client_address=198.51.100.1", "helo_name=mail.example.com", "sender=user@fail.example.com", "instance=2"},
+			"550 5.7.23 198.51.100.1 is not one of fail.example.com's designated mail servers",
+		},
+		{
+			[]string{"client_address=198.51.100.1", "helo_name=mail.example.com", "sender=user@broken.example.com", "instance=3"},
+			"DEFER_IF_PERMIT 4.7.24 SPF validation error: TXT lookup for broken.example.com. failed",
+		},
+		{
+			[]string{"client_address=10.1.2.3", "helo_name=mail.example.com", "sender=user@fail.example.com", "instance=4"},
+			"DUNNO",
+		},
+	}
+	for _, tst := range tests {
+		if got := query(t, conn, r, tst.attrs...); got != tst.want {
+			t.Errorf("%v: expected\n%s\ngot\n%s", tst.attrs, tst.want, got)
+		}
+	}
+	if !strings.Contains(logged.String(), spftest.ErrTimeout.Error()) {
+		t.Errorf("expected the DNS error to be logged, got %q", logged.String())
+	}
+}
+
+func TestConfig(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "spf-policyd.yaml")
+	config := `actions:
+  softfail: defer_if_permit
+  permerror: DUNNO
+trusted:
+  - 192.0.2.0/24
+  - 2001:db8::1
+hostname: mx.example.org
+`
+	if err := os.WriteFile(filename, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := policyd.ReadConfig(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := policyd.NewServer(spf.NewChecker())
+	if err := cfg.Apply(s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Actions[spf.Softfail] != policyd.DeferIfPermit || s.Actions[spf.Permerror] != policyd.Dunno || s.Actions[spf.Fail] != policyd.Reject {
+		t.Errorf("unexpected actions %v", s.Actions)
+	}
+	if len(s.Trusted) != 2 || s.Trusted[1].String() != "2001:db8::1/128" {
+		t.Errorf("unexpected trusted networks %v", s.Trusted)
+	}
+	if s.Checker.Hostname != "mx.example.org" {
+		t.Errorf("expected hostname to be set, got %s", s.Checker.Hostname)
+	}
+
+	for _, bad := range []string{"actions:\n  fial: REJECT\n", "actions:\n  fail: BOUNCE\n", "trusted:\n  - 192.0.2/24\n"} {
+		if err := os.WriteFile(filename, []byte(bad), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := policyd.ReadConfig(filename)
+		if err == nil {
+			err = cfg.Apply(policyd.NewServer(spf.NewChecker()))
+		}
+		if err == nil {
+			t.Errorf("expected error from config %q", bad)
+		}
+	}
+}
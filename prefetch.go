@@ -0,0 +1,166 @@
+package spf
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// prefetcher makes the DNS queries that the terms of a record will need in
+// parallel, ahead of the terms being evaluated. Evaluation itself is still
+// serial, and each query it makes is answered from a prefetched response
+// if there is one, so results, limits, hooks and traces are exactly as they
+// would be without prefetching.
+type prefetcher struct {
+	c       *Checker
+	ctx     context.Context
+	cancel  context.CancelFunc
+	mu      sync.Mutex
+	pending map[dns.Question]*prefetched
+}
+
+// prefetched is the response to a single prefetched query. done is closed
+// once m and err are set.
+type prefetched struct {
+	done chan struct{}
+	m    *dns.Msg
+	err  error
+}
+
+func newPrefetcher(ctx context.Context, c *Checker) *prefetcher {
+	ctx, cancel := context.WithCancel(ctx)
+	return &prefetcher{
+		c:       c,
+		ctx:     ctx,
+		cancel:  cancel,
+		pending: map[dns.Question]*prefetched{},
+	}
+}
+
+// stop abandons any queries that are still in flight.
+func (p *prefetcher) stop() {
+	p.cancel()
+}
+
+// query starts a query in the background, unless it's already been
+// started, and calls then with the response if there is one.
+func (p *prefetcher) query(name string, qtype uint16, then func(m *dns.Msg)) {
+	r := &dns.Msg{}
+	r.SetQuestion(dns.Fqdn(name), qtype)
+	q := r.Question[0]
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.pending[q]; ok {
+		return
+	}
+	f := &prefetched{done: make(chan struct{})}
+	p.pending[q] = f
+	go func() {
+		f.m, f.err = p.c.Resolver.Resolve(p.ctx, r)
+		// Start any follow-up queries before the response is used, so
+		// that evaluation finds them
+		if then != nil && f.err == nil {
+			then(f.m)
+		}
+		close(f.done)
+	}()
+}
+
+// take returns the prefetched response to a query, or nil if it wasn't
+// prefetched. Each response is only used once, so a query made twice during
+// evaluation is only answered from the prefetch the first time.
+func (p *prefetcher) take(r *dns.Msg) *prefetched {
+	if len(r.Question) != 1 {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f := p.pending[r.Question[0]]
+	delete(p.pending, r.Question[0])
+	return f
+}
+
+// wait waits for the response to arrive.
+func (f *prefetched) wait(ctx context.Context) (*dns.Msg, error) {
+	select {
+	case <-f.done:
+		return f.m, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// prefetched returns the prefetched response to a query, if prefetching is
+// enabled and it was prefetched.
+func (r *Result) prefetched(q *dns.Msg) *prefetched {
+	if r == nil || r.prefetch == nil {
+		return nil
+	}
+	return r.prefetch.take(q)
+}
+
+// record starts queries for the terms of a record that can be resolved
+// without a macro expansion, up to the number of DNS lookups the Checker
+// would allow.
+func (p *prefetcher) record(result *Result, domain string, record *SPFRecord) {
+	addressType := dns.TypeA
+	if result.ip.To4() == nil {
+		addressType = dns.TypeAAAA
+	}
+	target := func(domainSpec string) (string, bool) {
+		if domainSpec == "" {
+			return domain, true
+		}
+		if strings.Contains(domainSpec, "%") || !validDomainName(domainSpec) {
+			return "", false
+		}
+		return domainSpec, true
+	}
+
+	lookups := p.c.DNSLimit - result.DNSQueries
+	for _, mechanism := range record.Mechanisms {
+		if lookups <= 0 {
+			return
+		}
+		switch m := mechanism.(type) {
+		case MechanismInclude:
+			lookups--
+			if name, ok := target(m.DomainSpec); ok {
+				p.query(name, dns.TypeTXT, nil)
+			}
+		case MechanismA:
+			lookups--
+			if name, ok := target(m.DomainSpec); ok {
+				p.query(name, addressType, nil)
+			}
+		case MechanismMX:
+			lookups--
+			if name, ok := target(m.DomainSpec); ok {
+				p.query(name, dns.TypeMX, func(resp *dns.Msg) {
+					hosts := 0
+					for _, rr := range resp.Answer {
+						if mx, ok := rr.(*dns.MX); ok && hosts < p.c.MXAddressLimit {
+							hosts++
+							p.query(mx.Mx, addressType, nil)
+						}
+					}
+				})
+			}
+		case MechanismExists:
+			lookups--
+			if name, ok := target(m.DomainSpec); ok {
+				p.query(name, dns.TypeA, nil)
+			}
+		case MechanismPTR:
+			lookups--
+		}
+	}
+	if record.Redirect != "" && lookups > 0 {
+		if name, ok := target(record.Redirect); ok {
+			p.query(name, dns.TypeTXT, nil)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package spf_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/wttw/spf"
+	"github.com/wttw/spf/spftest"
+)
+
+// recordingHook records every hook call, to compare the order of evaluation
+type recordingHook struct {
+	calls []string
+}
+
+func (h *recordingHook) Dns(r *dns.Msg, m *dns.Msg, err error) {
+	rcode := -1
+	if m != nil {
+		rcode = m.Rcode
+	}
+	h.calls = append(h.calls, fmt.Sprintf("dns %s %d %v", r.Question[0].String(), rcode, err))
+}
+
+func (h *recordingHook) Record(record, domain string) {
+	h.calls = append(h.calls, "record "+domain+" "+record)
+}
+
+func (h *recordingHook) RecordResult(domain string, result *spf.Result) {
+	h.calls = append(h.calls, fmt.Sprintf("result %s %s %d %d", domain, result.Type, result.DNSQueries, result.VoidLookups))
+}
+
+func (h *recordingHook) Macro(before, after string, err error) {
+	h.calls = append(h.calls, fmt.Sprintf("macro %s %s %v", before, after, err))
+}
+
+func (h *recordingHook) Mechanism(domain string, index int, mechanism spf.Mechanism, result *spf.Result) {
+	h.calls = append(h.calls, fmt.Sprintf("mechanism %s %d %s %s", domain, index, mechanism, result.Type))
+}
+
+func (h *recordingHook) Redirect(target string) {
+	h.calls = append(h.calls, "redirect "+target)
+}
+
+func TestPrefetchSuites(t *testing.T) {
+	for _, filename := range []string{
+		"testdata/openspf/pyspf-tests.yml",
+		"testdata/openspf/rfc7208-tests.yml",
+	} {
+		suites, err := spftest.LoadSuites(filename)
+		if err != nil {
+			t.Fatalf("while reading %s: %v", filename, err)
+		}
+		for _, s := range suites {
+			resolver, err := s.Resolver()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, test := range s.Tests {
+				var results []string
+				var hooks [][]string
+				for _, prefetch := range []bool{false, true} {
+					hook := &recordingHook{}
+					c := spftest.NewChecker(resolver)
+					c.Hook = hook
+					c.Prefetch = prefetch
+					result := c.SPF(context.Background(), test.Host, test.MailFrom, test.Helo)
+					results = append(results, fmt.Sprintf("%s %q %v %d %d %s", result.Type, result.Explanation, result.Error, result.DNSQueries, result.VoidLookups, result.Mechanism))
+					hooks = append(hooks, hook.calls)
+				}
+				if results[0] != results[1] {
+					t.Errorf("%s: expected %s, got %s with prefetch", name, results[0], results[1])
+				}
+				if strings.Join(hooks[0], "\n") != strings.Join(hooks[1], "\n") {
+					t.Errorf("%s: hooks differ with prefetch\n%s\n\n%s", name, strings.Join(hooks[0], "\n"), strings.Join(hooks[1], "\n"))
+				}
+			}
+		}
+	}
+}
+
+// slowResolver delays every query, and records how many were in flight at
+// once
+type slowResolver struct {
+	spf.Resolver
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+func (r *slowResolver) Resolve(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	r.mu.Lock()
+	r.inFlight++
+	if r.inFlight > r.maxInFlight {
+		r.maxInFlight = r.inFlight
+	}
+	r.mu.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	r.mu.Lock()
+	r.inFlight--
+	r.mu.Unlock()
+	return r.Resolver.Resolve(ctx, m)
+}
+
+func TestPrefetch(t *testing.T) {
+	res := spftest.NewResolver().
+		TXT("example.com", "v=spf1 include:a.example.com include:b.example.com include:c.example.com mx -all").
+		TXT("a.example.com", "v=spf1 ip4:192.0.2.1 -all").
+		TXT("b.example.com", "v=spf1 ip4:192.0.2.2 -all").
+		TXT("c.example.com", "v=spf1 ip4:192.0.2.3 -all").
+		MX("example.com", 10, "mail.example.com").
+		A("mail.example.com", "198.51.100.1")
+	slow := &slowResolver{Resolver: res}
+	c := spftest.NewChecker(slow)
+	c.Prefetch = true
+
+	spftest.AssertResult(t, c, "198.51.100.1", "user@example.com", "", spf.Pass)
+	if slow.maxInFlight < 4 {
+		t.Errorf("expected at least 4 queries in parallel, got %d", slow.maxInFlight)
+	}
+	spftest.AssertResult(t, c, "192.0.2.2", "user@example.com", "", spf.Pass)
+	spftest.AssertResult(t, c, "203.0.113.1", "user@example.com", "", spf.Fail)
+}
@@ -2,7 +2,7 @@ package spf
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"github.com/miekg/dns"
 	"strings"
 )
@@ -65,7 +65,7 @@ func (m MechanismPTR) Evaluate(ctx context.Context, result *Result, domain strin
 	}
 	target = dns.Fqdn(target)
 	if !validDomainName(target) {
-		return Fail, fmt.Errorf("invalid hostname '%s'", target)
+		return Fail, &DomainError{Name: target}
 	}
 
 	rev, err := dns.ReverseAddr(result.ip.String())
@@ -73,6 +73,11 @@ func (m MechanismPTR) Evaluate(ctx context.Context, result *Result, domain strin
 		return Permerror, err
 	}
 	rrs, resultType, err := c.lookupDNS(ctx, rev, dns.TypePTR, result)
+	var dnsErr *DNSError
+	if errors.As(err, &dnsErr) && dnsErr.Err == nil {
+		// The server answered with an error, so there's nothing to match
+		return None, nil
+	}
 	if err != nil {
 		return resultType, err
 	}
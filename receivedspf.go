@@ -0,0 +1,230 @@
+package spf
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// ReceivedSPF is the value of an RFC 7208 Received-SPF header field.
+type ReceivedSPF struct {
+	Result       ResultType
+	Comment      string // supporting information for humans
+	ClientIP     net.IP
+	EnvelopeFrom string // the envelope sender mailbox
+	Helo         string // the host name given in HELO or EHLO
+	Mechanism    string // the mechanism that matched, or "default"
+	Problem      string // details of any error
+	Receiver     string // the host name of the SPF verifier
+	Identity     string // "mailfrom" or "helo"
+	Other        map[string]string
+}
+
+// NewReceivedSPF builds a Received-SPF header field from a Result. The
+// problem given for a failed DNS lookup names the query but not the
+// resolver's error, as in SMTPReply.
+func NewReceivedSPF(r Result) *ReceivedSPF {
+	ret := &ReceivedSPF{
+		Result:       r.Type,
+		ClientIP:     r.ip,
+		EnvelopeFrom: r.sender,
+		Helo:         r.helo,
+		Identity:     "mailfrom",
+		Mechanism:    "default",
+	}
+	if r.c != nil {
+		ret.Receiver = r.c.Hostname
+	}
+	sender := r.sender
+	// The sender of a HELO check is postmaster@<helo>, not the envelope
+	// sender, which is only known if the check was made by Checker.SPF.
+	if r.UsedHelo {
+		ret.Identity = "helo"
+		ret.EnvelopeFrom = r.mailFrom
+		sender = r.helo
+	}
+	if r.Mechanism != nil {
+		ret.Mechanism = r.Mechanism.String()
+	}
+	if (r.Type == Temperror || r.Type == Permerror) && r.Error != nil {
+		ret.Problem = publicError(r.Error)
+	}
+
+	// 9.1.  The Received-SPF Header Field (RFC 7208)
+	//
+	//	The header field SHOULD include a "(...)" style comment after the
+	//	result, conveying supporting information for the result, such as
+	//	<ip>, <sender>, and <domain>.
+	var comment string
+	switch r.Type {
+	case Pass:
+		comment = fmt.Sprintf("domain of %s designates %s as permitted sender", sender, r.ip)
+	case Fail:
+		comment = fmt.Sprintf("domain of %s does not designate %s as permitted sender", sender, r.ip)
+	case Softfail:
+		comment = fmt.Sprintf("domain of transitioning %s does not designate %s as permitted sender", sender, r.ip)
+	case Neutral:
+		comment = fmt.Sprintf("%s is neither permitted nor denied by domain of %s", r.ip, sender)
+	case None:
+		comment = fmt.Sprintf("domain of %s does not designate permitted sender hosts", sender)
+	case Temperror:
+		comment = fmt.Sprintf("error in processing during lookup of %s", sender)
+	case Permerror:
+		comment = fmt.Sprintf("permanent error in processing during lookup of %s", sender)
+	}
+	if ret.Receiver != "" {
+		comment = ret.Receiver + ": " + comment
+	}
+	ret.Comment = comment
+	return ret
+}
+
+// ReceivedSPF displays a Result as the value of an RFC 7208 Received-SPF:
+// header.
+func (r *Result) ReceivedSPF() string {
+	return NewReceivedSPF(*r).String()
+}
+
+// String returns the header field value, without the field name.
+//
+//	header-field     = "Received-SPF:" [CFWS] result FWS [comment FWS]
+//	                   [ key-value-list ] CRLF
+func (h *ReceivedSPF) String() string {
+	var sb strings.Builder
+	sb.WriteString(h.Result.String())
+	if h.Comment != "" {
+		sb.WriteString(" (")
+		sb.WriteString(escapeComment(h.Comment))
+		sb.WriteString(")")
+	}
+	var pairs []string
+	add := func(key, value string) {
+		if value != "" {
+			pairs = append(pairs, key+"="+dotAtomOrQuoted(value))
+		}
+	}
+	add("receiver", h.Receiver)
+	if h.ClientIP != nil {
+		add("client-ip", h.ClientIP.String())
+	}
+	add("envelope-from", h.EnvelopeFrom)
+	add("helo", h.Helo)
+	add("mechanism", h.Mechanism)
+	add("problem", h.Problem)
+	add("identity", h.Identity)
+	keys := make([]string, 0, len(h.Other))
+	for key := range h.Other {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		add(key, h.Other[key])
+	}
+	if len(pairs) > 0 {
+		sb.WriteString(" ")
+		sb.WriteString(strings.Join(pairs, "; "))
+		sb.WriteString(";")
+	}
+	return sb.String()
+}
+
+// dotAtomOrQuoted returns s unchanged if it's an RFC 5322 dot-atom,
+// otherwise as a quoted-string, with any control characters removed.
+//
+//	SPF verifiers MUST make sure that the Received-SPF header field does
+//	not contain invalid characters, is not excessively long (see
+//	[RFC5322], Section 2.1.1), and does not contain malicious data that
+//	has been provided by the sender.
+func dotAtomOrQuoted(s string) string {
+	if isDotAtom(s) {
+		return s
+	}
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, c := range []byte(s) {
+		switch {
+		case c < ' ' || c == 0x7f:
+			continue
+		case c == '"' || c == '\\':
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(c)
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// ParseReceivedSPF parses the value of a Received-SPF header field, with or
+// without the field name.
+func ParseReceivedSPF(s string) (*ReceivedSPF, error) {
+	if colon := strings.Index(s, ":"); colon != -1 && strings.EqualFold(strings.TrimSpace(s[:colon]), "Received-SPF") {
+		s = s[colon+1:]
+	}
+	tokens, err := lexHeader(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &headerParser{tokens: tokens}
+	ret := &ReceivedSPF{}
+
+	result, err := p.word("result")
+	if err != nil {
+		return nil, err
+	}
+	ret.Result, err = ResultTypeString(strings.ToLower(result))
+	if err != nil {
+		return nil, fmt.Errorf("unknown result '%s'", result)
+	}
+
+	for {
+		t := p.peek()
+		if t.kind == 0 {
+			break
+		}
+		key, err := p.word("key")
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect('='); err != nil {
+			return nil, fmt.Errorf("after %s: %w", key, err)
+		}
+		value, err := p.value()
+		if err != nil {
+			return nil, fmt.Errorf("in %s: %w", key, err)
+		}
+		switch strings.ToLower(key) {
+		case "client-ip":
+			ret.ClientIP = net.ParseIP(value)
+			if ret.ClientIP == nil {
+				return nil, fmt.Errorf("invalid client-ip '%s'", value)
+			}
+		case "envelope-from":
+			ret.EnvelopeFrom = value
+		case "helo":
+			ret.Helo = value
+		case "mechanism":
+			ret.Mechanism = value
+		case "problem":
+			ret.Problem = value
+		case "receiver":
+			ret.Receiver = value
+		case "identity":
+			ret.Identity = value
+		default:
+			if ret.Other == nil {
+				ret.Other = map[string]string{}
+			}
+			ret.Other[key] = value
+		}
+		t = p.next()
+		if t.kind == 0 {
+			break
+		}
+		if t.kind != ';' {
+			return nil, fmt.Errorf("expected ';' after %s", key)
+		}
+	}
+	ret.Comment = p.takeComments()
+	return ret, nil
+}
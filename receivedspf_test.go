@@ -0,0 +1,114 @@
+package spf_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/wttw/spf"
+	"github.com/wttw/spf/spftest"
+)
+
+func TestReceivedSPF(t *testing.T) {
+	res := spftest.NewResolver().
+		TXT("example.com", "v=spf1 include:_spf.example.com ip4:192.0.2.0/24 -all").
+		TXT("_spf.example.com", "v=spf1 ip4:198.51.100.0/24 -all").
+		TXT("neutral.example.com", "v=spf1 include:_spf.example.com").
+		TXT("broken.example.com", "v=spf1 foo:bar -all").
+		Timeout("timeout.example.com")
+	c := spftest.NewChecker(res)
+	c.Hostname = "mybox.example.org"
+	ctx := context.Background()
+
+	result := c.SPF(ctx, net.ParseIP("192.0.2.1"), "myname@example.com", "")
+	if result.Mechanism == nil || result.Mechanism.String() != "ip4:192.0.2.0/24" {
+		t.Errorf("expected ip4:192.0.2.0/24 to match, got %v", result.Mechanism)
+	}
+	want := `pass (mybox.example.org: domain of myname@example.com designates 192.0.2.1 as permitted sender) receiver=mybox.example.org; client-ip=192.0.2.1; envelope-from="myname@example.com"; mechanism="ip4:192.0.2.0/24"; identity=mailfrom;`
+	if got := result.ReceivedSPF(); got != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+
+	result = c.SPF(ctx, net.ParseIP("198.51.100.1"), "myname@example.com", "")
+	if result.Mechanism == nil || result.Mechanism.String() != "include:_spf.example.com" {
+		t.Errorf("expected include:_spf.example.com to match, got %v", result.Mechanism)
+	}
+
+	result = c.SPF(ctx, net.ParseIP("203.0.113.1"), "myname@neutral.example.com", "")
+	if result.Type != spf.Neutral || result.Mechanism != nil {
+		t.Errorf("expected neutral with no mechanism, got %s %v", result.Type, result.Mechanism)
+	}
+	h := spf.NewReceivedSPF(result)
+	if h.Mechanism != "default" {
+		t.Errorf("expected default mechanism, got %s", h.Mechanism)
+	}
+
+	result = c.SPF(ctx, net.ParseIP("2001:db8::1"), "myname@broken.example.com", "")
+	parsed, err := spf.ParseReceivedSPF("Received-SPF: " + result.ReceivedSPF())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Result != spf.Permerror || parsed.Problem == "" || !parsed.ClientIP.Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("unexpected parse %#v", parsed)
+	}
+	if parsed.String() != result.ReceivedSPF() {
+		t.Errorf("expected round trip of\n%s\ngot\n%s", result.ReceivedSPF(), parsed)
+	}
+
+	// The resolver's error isn't given away
+	result = c.SPF(ctx, net.ParseIP("192.0.2.1"), "myname@timeout.example.com", "")
+	if h := spf.NewReceivedSPF(result); h.Problem != "TXT lookup for timeout.example.com. failed" {
+		t.Errorf("unexpected problem %q", h.Problem)
+	}
+
+	// A HELO result gives the real envelope sender, not postmaster@<helo>
+	result = c.SPF(ctx, net.ParseIP("203.0.113.1"), "myname@neutral.example.com", "example.com")
+	if result.Type != spf.Fail || !result.UsedHelo {
+		t.Fatalf("expected a HELO fail, got %s", result.Type)
+	}
+	want = `fail (mybox.example.org: domain of example.com does not designate 203.0.113.1 as permitted sender) receiver=mybox.example.org; client-ip=203.0.113.1; envelope-from="myname@neutral.example.com"; helo=example.com; mechanism=-all; identity=helo;`
+	if got := result.ReceivedSPF(); got != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+	result = c.CheckHelo(ctx, net.ParseIP("203.0.113.1"), "example.com")
+	if h := spf.NewReceivedSPF(result); h.EnvelopeFrom != "" {
+		t.Errorf("expected no envelope-from for a HELO check alone, got %s", h.EnvelopeFrom)
+	}
+}
+
+func TestParseReceivedSPF(t *testing.T) {
+	// Example from RFC 7208 section 9.1
+	h, err := spf.ParseReceivedSPF(`Received-SPF: pass (mybox.example.org: domain of
+ myname@example.com designates 192.0.2.1 as permitted sender)
+    receiver=mybox.example.org; client-ip=192.0.2.1;
+    mechanism=ip4:192.0.2.1; envelope-from="myname@example.com";
+    helo=foo.example.com; x-extra=something;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := spf.ReceivedSPF{
+		Result:       spf.Pass,
+		Comment:      "mybox.example.org: domain of\n myname@example.com designates 192.0.2.1 as permitted sender",
+		ClientIP:     net.ParseIP("192.0.2.1"),
+		EnvelopeFrom: "myname@example.com",
+		Helo:         "foo.example.com",
+		Mechanism:    "ip4:192.0.2.1",
+		Receiver:     "mybox.example.org",
+	}
+	if h.Result != want.Result || h.Comment != want.Comment || !h.ClientIP.Equal(want.ClientIP) ||
+		h.EnvelopeFrom != want.EnvelopeFrom || h.Helo != want.Helo || h.Mechanism != want.Mechanism ||
+		h.Receiver != want.Receiver || h.Other["x-extra"] != "something" {
+		t.Errorf("expected %#v, got %#v", want, h)
+	}
+
+	for _, bad := range []string{
+		"",
+		"great (comment)",
+		"pass client-ip=not-an-ip",
+		"pass helo",
+	} {
+		if _, err := spf.ParseReceivedSPF(bad); err == nil {
+			t.Errorf("expected an error parsing %q", bad)
+		}
+	}
+}
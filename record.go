@@ -0,0 +1,223 @@
+package spf
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ErrNotRecorded is returned by a ReplayResolver for a question that isn't
+// in its recording.
+var ErrNotRecorded = errors.New("question not in recording")
+
+// recordedExchange is a single query and its response, one per line of a
+// recording. Answer is only there to make the file easier to read; the
+// response is replayed from the packed message.
+type recordedExchange struct {
+	Time     time.Time    `json:"time"`
+	Question questionJSON `json:"question"`
+	Rcode    string       `json:"rcode,omitempty"`
+	Answer   []string     `json:"answer,omitempty"`
+	Response []byte       `json:"response,omitempty"`
+	Error    string       `json:"error,omitempty"`
+}
+
+var _ Resolver = &RecordingResolver{}
+
+// RecordingResolver is a Resolver that passes queries on to another Resolver
+// and writes each question and its response, or error, with the time it was
+// answered, to a recording that a ReplayResolver can answer from later. The
+// recording has one JSON object per line.
+//
+// A RecordingResolver is safe for concurrent use by multiple goroutines.
+type RecordingResolver struct {
+	Resolver Resolver // resolver queries are sent to
+
+	mu  sync.Mutex
+	w   io.Writer
+	err error
+}
+
+// NewRecordingResolver creates a RecordingResolver that sends queries to
+// upstream and writes the recording to w.
+func NewRecordingResolver(upstream Resolver, w io.Writer) *RecordingResolver {
+	return &RecordingResolver{
+		Resolver: upstream,
+		w:        w,
+	}
+}
+
+// Resolve sends a query to the upstream Resolver and records the response.
+// Failing to write the recording doesn't fail the query; use Err to check
+// for that.
+func (res *RecordingResolver) Resolve(ctx context.Context, r *dns.Msg) (*dns.Msg, error) {
+	m, err := res.Resolver.Resolve(ctx, r)
+	if len(r.Question) != 1 {
+		return m, err
+	}
+	q := r.Question[0]
+	e := recordedExchange{
+		Time:     time.Now().UTC(),
+		Question: questionJSON{Name: q.Name, Type: dns.TypeToString[q.Qtype]},
+	}
+	if err != nil {
+		e.Error = err.Error()
+	} else {
+		e.Rcode = dns.RcodeToString[m.Rcode]
+		for _, rr := range m.Answer {
+			e.Answer = append(e.Answer, rr.String())
+		}
+		var packErr error
+		e.Response, packErr = m.Pack()
+		if packErr != nil {
+			res.fail(fmt.Errorf("failed to pack response to %s: %w", q.Name, packErr))
+			return m, err
+		}
+	}
+	line, jsonErr := json.Marshal(e)
+	if jsonErr != nil {
+		res.fail(jsonErr)
+		return m, err
+	}
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	if res.err == nil {
+		_, res.err = res.w.Write(append(line, '\n'))
+	}
+	return m, err
+}
+
+func (res *RecordingResolver) fail(err error) {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	if res.err == nil {
+		res.err = err
+	}
+}
+
+// Err returns the first error writing the recording, if any.
+func (res *RecordingResolver) Err() error {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	return res.err
+}
+
+var _ Resolver = &ReplayResolver{}
+
+// ReplayResolver is a Resolver that answers queries from a recording made
+// by a RecordingResolver, with no network access, so that a check can be
+// repeated exactly as it was made. A question asked several times gets the
+// recorded responses in the order they were recorded, then the last one
+// again. A question that isn't in the recording fails with ErrNotRecorded.
+//
+// A ReplayResolver is safe for concurrent use by multiple goroutines.
+type ReplayResolver struct {
+	mu        sync.Mutex
+	exchanges map[cacheKey][]recordedExchange
+	next      map[cacheKey]int
+	start     time.Time
+}
+
+// NewReplayResolver creates a ReplayResolver from a recording read from r.
+func NewReplayResolver(r io.Reader) (*ReplayResolver, error) {
+	res := &ReplayResolver{
+		exchanges: map[cacheKey][]recordedExchange{},
+		next:      map[cacheKey]int{},
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var e recordedExchange
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		qtype, ok := dns.StringToType[e.Question.Type]
+		if !ok {
+			return nil, fmt.Errorf("line %d: unknown DNS type '%s'", lineNumber, e.Question.Type)
+		}
+		if e.Error == "" {
+			if err := (&dns.Msg{}).Unpack(e.Response); err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+			}
+		}
+		key := cacheKey{name: strings.ToLower(dns.Fqdn(e.Question.Name)), qtype: qtype, qclass: dns.ClassINET}
+		res.exchanges[key] = append(res.exchanges[key], e)
+		if res.start.IsZero() || e.Time.Before(res.start) {
+			res.start = e.Time
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// LoadReplayResolver creates a ReplayResolver from a recording in a file.
+func LoadReplayResolver(filename string) (*ReplayResolver, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	res, err := NewReplayResolver(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return res, nil
+}
+
+// Recorded returns when the earliest response in the recording was
+// received.
+func (res *ReplayResolver) Recorded() time.Time {
+	return res.start
+}
+
+// Resolve answers a query from the recording.
+func (res *ReplayResolver) Resolve(ctx context.Context, r *dns.Msg) (*dns.Msg, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(r.Question) != 1 {
+		return nil, errors.New("ReplayResolver: expected exactly one question")
+	}
+	q := r.Question[0]
+	key := cacheKey{name: strings.ToLower(q.Name), qtype: q.Qtype, qclass: q.Qclass}
+
+	res.mu.Lock()
+	exchanges := res.exchanges[key]
+	i := res.next[key]
+	if i < len(exchanges)-1 {
+		res.next[key] = i + 1
+	}
+	res.mu.Unlock()
+	if len(exchanges) == 0 {
+		return nil, fmt.Errorf("%w: %s %s", ErrNotRecorded, dns.Type(q.Qtype), q.Name)
+	}
+
+	e := exchanges[i]
+	if e.Error != "" {
+		return nil, errors.New(e.Error)
+	}
+	m := &dns.Msg{}
+	if err := m.Unpack(e.Response); err != nil {
+		return nil, err
+	}
+	m.Id = r.Id
+	m.Question = []dns.Question{q}
+	return m, nil
+}
@@ -0,0 +1,60 @@
+package spf_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/wttw/spf"
+	"github.com/wttw/spf/spftest"
+)
+
+func TestRecordReplay(t *testing.T) {
+	res := spftest.NewResolver().
+		TXT("example.com", "v=spf1 include:_spf.example.com mx exists:%{i}.ex.example.com -all").
+		TXT("_spf.example.com", "v=spf1 ip4:192.0.2.0/24 -all").
+		MX("example.com", 10, "mail.example.com").
+		A("mail.example.com", "198.51.100.1").
+		Timeout("203.0.113.1.ex.example.com")
+
+	var recording bytes.Buffer
+	recorder := spf.NewRecordingResolver(res, &recording)
+	c := spftest.NewChecker(recorder)
+	var want []spf.Result
+	for _, ip := range []string{"192.0.2.1", "198.51.100.1", "203.0.113.1"} {
+		want = append(want, c.SPF(context.Background(), net.ParseIP(ip), "user@Example.COM", ""))
+	}
+	if err := recorder.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if want[2].Type != spf.Temperror {
+		t.Fatalf("expected a lookup failure, got %s", want[2].Type)
+	}
+
+	// The records change, but the replay doesn't see it
+	res.TXT("_spf.example.com", "v=spf1 -all")
+	replay, err := spf.NewReplayResolver(&recording)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = spftest.NewChecker(replay)
+	for i, ip := range []string{"192.0.2.1", "198.51.100.1", "203.0.113.1"} {
+		got := c.SPF(context.Background(), net.ParseIP(ip), "user@example.com", "")
+		if got.Type != want[i].Type || got.DNSQueries != want[i].DNSQueries || fmt.Sprint(got.Mechanism) != fmt.Sprint(want[i].Mechanism) {
+			t.Errorf("%s: recorded %s %d %s, replayed %s %d %s", ip, want[i].Type, want[i].DNSQueries, want[i].Mechanism, got.Type, got.DNSQueries, got.Mechanism)
+		}
+	}
+	if replay.Recorded().IsZero() {
+		t.Errorf("expected the time of the recording")
+	}
+
+	r := &dns.Msg{}
+	r.SetQuestion("other.example.com.", dns.TypeTXT)
+	if _, err := replay.Resolve(context.Background(), r); !errors.Is(err, spf.ErrNotRecorded) {
+		t.Errorf("expected ErrNotRecorded, got %v", err)
+	}
+}
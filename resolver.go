@@ -1,8 +1,16 @@
 package spf
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/miekg/dns"
 )
@@ -11,6 +19,10 @@ import (
 // configure DefaultResolver.
 var ResolvConf = "/etc/resolv.conf"
 
+// resolvConfCheckInterval is how often DefaultResolver checks whether
+// ResolvConf has changed
+const resolvConfCheckInterval = 5 * time.Second
+
 // Resolver is used for all DNS lookups during an SPF check
 type Resolver interface {
 	Resolve(ctx context.Context, r *dns.Msg) (*dns.Msg, error)
@@ -19,35 +31,170 @@ type Resolver interface {
 var _ Resolver = &DefaultResolver{}
 
 // DefaultResolver is the Resolver that will be used in default constructed Checkers.
+//
+// It sends queries to the nameservers listed in ResolvConf, honouring the
+// timeout, attempts and rotate options, and retries over TCP if a response
+// is truncated. Nameservers may be given as [address]:port to use a port
+// other than 53. ResolvConf is read on first use and read again if it's
+// changed, checking at most every few seconds; if it can no longer be read
+// the last configuration is kept.
+//
+// A DefaultResolver is safe for concurrent use by multiple goroutines.
 type DefaultResolver struct {
-	client  *dns.Client
-	servers []string
+	mu      sync.Mutex
+	config  *resolverConfig
+	path    string    // file config was read from
+	modTime time.Time // of the file when it was read
+	size    int64
+	checked time.Time // when the file was last checked for changes
+
+	next atomic.Uint32 // first server to try, with rotate
+}
+
+// resolverConfig is the configuration read from a resolv.conf file
+type resolverConfig struct {
+	servers  []string
+	timeout  time.Duration
+	attempts int
+	rotate   bool
+	udp      *dns.Client
+	tcp      *dns.Client
 }
 
 // Resolve performs a low level DNS lookup using miekg/dns format packet representation.
 func (res *DefaultResolver) Resolve(ctx context.Context, r *dns.Msg) (*dns.Msg, error) {
-	if res.client == nil {
-		clientConfig, err := dns.ClientConfigFromFile(ResolvConf)
-		if err != nil {
-			return nil, fmt.Errorf("Failed to load %s: %w", ResolvConf, err)
+	config, err := res.load(false)
+	if err != nil {
+		return nil, err
+	}
+	q := r.Copy()
+	if q.IsEdns0() == nil {
+		q.SetEdns0(4096, false)
+	}
+
+	// options rotate (resolv.conf(5))
+	//  Sets RES_ROTATE in _res.options, which causes round-robin selection
+	//  of nameservers from among those listed.
+	servers := config.servers
+	if config.rotate && len(servers) > 1 {
+		start := int(res.next.Add(1)-1) % len(servers)
+		servers = append(append([]string{}, servers[start:]...), servers[:start]...)
+	}
+	for attempt := 0; attempt < config.attempts; attempt++ {
+		for _, server := range servers {
+			var m *dns.Msg
+			m, err = config.exchange(ctx, q, server)
+			if err == nil {
+				m.Id = r.Id
+				return m, nil
+			}
+			if ctx.Err() != nil {
+				return nil, err
+			}
 		}
-		if len(clientConfig.Servers) == 0 {
-			return nil, fmt.Errorf("No nameservers configured in %s", ResolvConf)
+	}
+	return nil, err
+}
+
+// Reload reads ResolvConf again immediately, rather than waiting for the
+// next check for changes.
+func (res *DefaultResolver) Reload() error {
+	_, err := res.load(true)
+	return err
+}
+
+// load returns the current configuration, reading ResolvConf if it hasn't
+// been read yet or has changed since it was.
+func (res *DefaultResolver) load(force bool) (*resolverConfig, error) {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	path := ResolvConf
+	loaded := res.config != nil && res.path == path
+	now := time.Now()
+	if loaded && !force && now.Sub(res.checked) < resolvConfCheckInterval {
+		return res.config, nil
+	}
+	res.checked = now
+	fi, err := os.Stat(path)
+	if loaded && !force && err == nil && fi.ModTime().Equal(res.modTime) && fi.Size() == res.size {
+		return res.config, nil
+	}
+	if err == nil {
+		var config *resolverConfig
+		config, err = readResolvConf(path)
+		if err == nil {
+			res.config = config
+			res.path = path
+			res.modTime = fi.ModTime()
+			res.size = fi.Size()
+			return config, nil
 		}
-		res.servers = make([]string, len(clientConfig.Servers))
-		for i, server := range clientConfig.Servers {
-			res.servers[i] = fmt.Sprintf("%s:%s", server, clientConfig.Port)
+	}
+	if loaded && !force {
+		return res.config, nil
+	}
+	return nil, err
+}
+
+// readResolvConf reads the nameservers and options from a resolv.conf file
+func readResolvConf(path string) (*resolverConfig, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load %s: %w", path, err)
+	}
+	clientConfig, err := dns.ClientConfigFromReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load %s: %w", path, err)
+	}
+	if len(clientConfig.Servers) == 0 {
+		return nil, fmt.Errorf("No nameservers configured in %s", path)
+	}
+	config := &resolverConfig{
+		servers:  make([]string, len(clientConfig.Servers)),
+		timeout:  time.Duration(clientConfig.Timeout) * time.Second,
+		attempts: clientConfig.Attempts,
+	}
+	for i, server := range clientConfig.Servers {
+		if host, port, err := net.SplitHostPort(server); err == nil && strings.HasPrefix(server, "[") {
+			config.servers[i] = net.JoinHostPort(host, port)
+		} else {
+			config.servers[i] = net.JoinHostPort(strings.Trim(server, "[]"), clientConfig.Port)
 		}
-		res.client = new(dns.Client)
 	}
-	r.SetEdns0(4096, false)
-	var m *dns.Msg
-	var err error
-	for _, server := range res.servers {
-		m, _, err = res.client.ExchangeContext(ctx, r, server)
-		if err == nil {
-			return m, nil
+
+	// miekg/dns doesn't parse rotate
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] != "options" {
+			continue
+		}
+		for _, option := range fields[1:] {
+			if option == "rotate" {
+				config.rotate = true
+			}
 		}
 	}
-	return m, err
+
+	config.udp = &dns.Client{Net: "udp", Timeout: config.timeout}
+	config.tcp = &dns.Client{Net: "tcp", Timeout: config.timeout}
+	return config, nil
+}
+
+// exchange sends a single query to a server, over UDP and then TCP if the
+// response was truncated, waiting no longer than the configured timeout.
+func (config *resolverConfig) exchange(ctx context.Context, q *dns.Msg, server string) (*dns.Msg, error) {
+	ctx, cancel := context.WithTimeout(ctx, config.timeout)
+	defer cancel()
+	m, _, err := config.udp.ExchangeContext(ctx, q, server)
+	if err == nil && m.Truncated {
+		m, _, err = config.tcp.ExchangeContext(ctx, q, server)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !questionMatches(q, m) {
+		return nil, fmt.Errorf("response from %s doesn't match the question", server)
+	}
+	return m, nil
 }
@@ -0,0 +1,159 @@
+package spf_test
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/wttw/spf"
+)
+
+// startServer starts a DNS server listening on the same loopback port over
+// both UDP and TCP, returning its address.
+func startServer(t *testing.T, handler dns.HandlerFunc) string {
+	t.Helper()
+	for tries := 0; tries < 10; tries++ {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ln, err := net.Listen("tcp", pc.LocalAddr().String())
+		if err != nil {
+			pc.Close()
+			continue
+		}
+		for _, srv := range []*dns.Server{{PacketConn: pc, Handler: handler}, {Listener: ln, Handler: handler}} {
+			srv := srv
+			go func() {
+				_ = srv.ActivateAndServe()
+			}()
+			t.Cleanup(func() { _ = srv.Shutdown() })
+		}
+		return pc.LocalAddr().String()
+	}
+	t.Fatal("failed to listen on the same UDP and TCP port")
+	return ""
+}
+
+// nameserver is a resolv.conf line for a server on a non-standard port
+func nameserver(addr string) string {
+	host, port, _ := net.SplitHostPort(addr)
+	return "nameserver [" + host + "]:" + port + "\n"
+}
+
+func writeResolvConf(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDefaultResolver(t *testing.T) {
+	var mu sync.Mutex
+	queries := map[string]int{}
+	handler := func(name string) dns.HandlerFunc {
+		return func(w dns.ResponseWriter, r *dns.Msg) {
+			mu.Lock()
+			queries[name]++
+			mu.Unlock()
+			_, udp := w.RemoteAddr().(*net.UDPAddr)
+			switch r.Question[0].Name {
+			case "truncated.example.com.":
+				if udp {
+					m := &dns.Msg{}
+					m.SetReply(r)
+					m.Truncated = true
+					_ = w.WriteMsg(m)
+					return
+				}
+			case "wrong.example.com.":
+				q := r.Copy()
+				q.Question[0].Name = "example.com."
+				_ = w.WriteMsg(answerA(q))
+				return
+			}
+			_ = w.WriteMsg(answerA(r))
+		}
+	}
+	first := startServer(t, handler("first"))
+	second := startServer(t, handler("second"))
+
+	// A server that never answers
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	defer func(old string) { spf.ResolvConf = old }(spf.ResolvConf)
+	spf.ResolvConf = path
+	writeResolvConf(t, path, nameserver(silent.LocalAddr().String())+nameserver(first)+"options timeout:1 attempts:1\n")
+
+	res := &spf.DefaultResolver{}
+	query := func(name string) (*dns.Msg, error) {
+		r := &dns.Msg{}
+		r.SetQuestion(name, dns.TypeA)
+		return res.Resolve(context.Background(), r)
+	}
+
+	// The silent server times out after a second, then the next is tried
+	start := time.Now()
+	m, err := query("example.com.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Answer) != 1 {
+		t.Errorf("expected 1 answer, got %d", len(m.Answer))
+	}
+	if elapsed := time.Since(start); elapsed < time.Second || elapsed > 3*time.Second {
+		t.Errorf("expected to time out after a second, took %s", elapsed)
+	}
+
+	// Truncated UDP responses are retried over TCP
+	writeResolvConf(t, path, nameserver(first))
+	if err := res.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	m, err = query("truncated.example.com.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Truncated || len(m.Answer) != 1 {
+		t.Errorf("expected a full answer over TCP, got %v", m)
+	}
+
+	if _, err := query("wrong.example.com."); err == nil {
+		t.Errorf("expected an error for a response to a different question")
+	}
+
+	// rotate spreads queries between servers, from many goroutines at once
+	writeResolvConf(t, path, nameserver(first)+nameserver(second)+"options rotate\n")
+	if err := res.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	queries = map[string]int{}
+	mu.Unlock()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := query("example.com."); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	mu.Lock()
+	if queries["first"] != 10 || queries["second"] != 10 {
+		t.Errorf("expected queries to be shared between servers, got %v", queries)
+	}
+	mu.Unlock()
+}
@@ -1,11 +1,10 @@
 package spf
 
 import (
-	"fmt"
 	"net"
 )
 
-//go:generate enumer -type ResultType -transform=snake
+//go:generate enumer -type ResultType -transform=snake -text
 
 // Result types, from RFC 7208
 // 2.6.1.  None
@@ -69,21 +68,28 @@ type Result struct {
 	VoidLookups int
 	Explanation string
 	UsedHelo    bool
+	Mechanism   Mechanism  // the mechanism that matched, nil if none did
+	Trace       *TraceNode // how the result was reached, if Checker.Trace is set
 	ip          net.IP
 	sender      string
+	mailFrom    string // MAIL FROM given to Checker.SPF, when the HELO result was used
 	helo        string
 	c           *Checker
+	traceStack  []*TraceNode
+	prefetch    *prefetcher
 }
 
 func (r *Result) String() string {
 	return r.Type.String()
 }
 
-// AuthenticationResults displays a Result as an RFC 8601
-// Authentication-Results: header
+// AuthenticationResults displays a Result as the value of an RFC 8601
+// Authentication-Results: header. NewAuthenticationResults can combine
+// several Results in one header.
 func (r *Result) AuthenticationResults() string {
-	if r.UsedHelo {
-		return fmt.Sprintf("%s; spf=%s smtp.helo=%s", r.c.Hostname, r.Type.String(), r.helo)
+	var hostname string
+	if r.c != nil {
+		hostname = r.c.Hostname
 	}
-	return fmt.Sprintf("%s; spf=%s smtp.mailfrom=%s", r.c.Hostname, r.Type.String(), r.sender)
+	return NewAuthenticationResults(hostname, *r).String()
 }
@@ -0,0 +1,120 @@
+package spf
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// maxReplyText is the longest reply text SMTPReply will produce, leaving
+// room for the codes within the 512 octet limit on SMTP reply lines from
+// RFC 5321 section 4.5.3.1.5.
+const maxReplyText = 480
+
+// SMTPReply is a reply an SMTP server can send to the MAIL FROM command in
+// response to an SPF result.
+type SMTPReply struct {
+	Code     int    // basic status code, such as 550
+	Enhanced string // RFC 3463 enhanced status code, such as "5.7.23"
+	Text     string
+}
+
+// String returns the reply as a single line, without a trailing CRLF.
+func (r SMTPReply) String() string {
+	return fmt.Sprintf("%d %s %s", r.Code, r.Enhanced, r.Text)
+}
+
+// Accept returns whether the reply allows the message to be delivered.
+func (r SMTPReply) Accept() bool {
+	return r.Code < 400
+}
+
+// SMTPReply returns the SMTP reply for a Result, using the enhanced status
+// codes from RFC 7372.
+//
+// Fail results are rejected with 550 5.7.23, using the explanation from the
+// domain's "exp=" modifier if there is one and Checker.DefaultExplanation
+// otherwise. Temperror results are deferred with 451 4.7.24, permerror
+// results rejected with 550 5.7.24 and all other results accepted. The
+// reply text for a failed DNS lookup names the query but not the resolver's
+// error, which is only of interest to the receiver.
+//
+// 8.4.  Fail (RFC 7208)
+//
+//	If the checking software chooses to reject the mail during the SMTP
+//	transaction, then it SHOULD use an SMTP reply code of 550 (see
+//	[RFC5321]) and, if supported, the 5.7.1 enhanced status code (see
+//	[RFC3463], Section 3.8), in addition to an appropriate reply text.
+//
+// RFC 7372 section 3.2 replaces 5.7.1 with 5.7.23 for this, and 4.4.3 and
+// 5.5.2 with 4.7.24 and 5.7.24 for temperror and permerror.
+func (r *Result) SMTPReply(ctx context.Context) SMTPReply {
+	var ret SMTPReply
+	switch r.Type {
+	case Fail:
+		ret = SMTPReply{Code: 550, Enhanced: "5.7.23", Text: r.explanation(ctx)}
+	case Temperror:
+		ret = SMTPReply{Code: 451, Enhanced: "4.7.24", Text: "SPF validation error"}
+		if r.Error != nil {
+			ret.Text += ": " + publicError(r.Error)
+		}
+	case Permerror:
+		ret = SMTPReply{Code: 550, Enhanced: "5.7.24", Text: "SPF validation error"}
+		if r.Error != nil {
+			ret.Text += ": " + publicError(r.Error)
+		}
+	default:
+		ret = SMTPReply{Code: 250, Enhanced: "2.1.0", Text: "SPF " + r.Type.String()}
+	}
+	ret.Text = replyText(ret.Text)
+	return ret
+}
+
+// explanation returns the explanation for a fail result, expanding the
+// default explanation if the domain didn't provide one.
+func (r *Result) explanation(ctx context.Context) string {
+	if r.Explanation != "" {
+		return r.Explanation
+	}
+	const fallback = "SPF validation failed"
+	if r.c == nil {
+		return fallback
+	}
+	exp := r.c.DefaultExplanation
+	if exp == "" {
+		exp = DefaultExplanation
+	}
+	// %{d} is the domain of the identity that was checked
+	domain := r.sender[strings.LastIndex(r.sender, "@")+1:]
+	// Expand on a copy, so that building a reply doesn't add to the trace
+	// or call the Checker's hooks
+	c := *r.c
+	c.Trace = false
+	c.Hook = nil
+	scratch := *r
+	scratch.c = &c
+	scratch.Trace = nil
+	scratch.traceStack = nil
+	scratch.prefetch = nil
+	expanded, err := c.expandMacro(ctx, exp, &scratch, domain, true)
+	if err != nil || expanded == "" {
+		return fallback
+	}
+	return expanded
+}
+
+// replyText makes text safe to use in an SMTP reply, replacing anything
+// that isn't printable ASCII and truncating it if it's too long.
+func replyText(s string) string {
+	var sb strings.Builder
+	for _, c := range []byte(s) {
+		if c < ' ' || c > '~' {
+			c = '?'
+		}
+		sb.WriteByte(c)
+		if sb.Len() == maxReplyText {
+			break
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,60 @@
+package spf_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/wttw/spf/spftest"
+)
+
+func TestSMTPReply(t *testing.T) {
+	res := spftest.NewResolver().
+		TXT("example.com", "v=spf1 ip4:192.0.2.0/24 -all").
+		TXT("exp.example.com", "v=spf1 -all exp=why.example.com").
+		TXT("why.example.com", "%{i} may not send mail for %{d}").
+		TXT("broken.example.com", "v=spf1 foo:bar -all").
+		Timeout("timeout.example.com")
+	c := spftest.NewChecker(res)
+	ctx := context.Background()
+
+	tests := []struct {
+		ip       string
+		mailFrom string
+		want     string
+	}{
+		{"192.0.2.1", "user@example.com", "250 2.1.0 SPF pass"},
+		{"198.51.100.1", "user@example.com", "550 5.7.23 198.51.100.1 is not one of example.com's designated mail servers"},
+		{"198.51.100.1", "user@exp.example.com", "550 5.7.23 198.51.100.1 may not send mail for exp.example.com"},
+		{"198.51.100.1", "user@broken.example.com", "550 5.7.24 SPF validation error: In field 'foo:bar': unrecognized mechanism 'foo'"},
+		{"198.51.100.1", "user@timeout.example.com", "451 4.7.24 SPF validation error: TXT lookup for timeout.example.com. failed"},
+	}
+	for _, tst := range tests {
+		result := c.SPF(ctx, net.ParseIP(tst.ip), tst.mailFrom, "")
+		if got := result.SMTPReply(ctx).String(); got != tst.want {
+			t.Errorf("%s from %s: expected\n%s\ngot\n%s", tst.mailFrom, tst.ip, tst.want, got)
+		}
+	}
+
+	c.DefaultExplanation = "See https://%{d}/spf?ip=%{i}"
+	result := c.SPF(ctx, net.ParseIP("198.51.100.1"), "user@example.com", "")
+	reply := result.SMTPReply(ctx)
+	if reply.Accept() || reply.Text != "See https://example.com/spf?ip=198.51.100.1" {
+		t.Errorf("unexpected reply %s", reply)
+	}
+
+	// Building a reply leaves the Result alone and doesn't call hooks, even
+	// if the explanation needs a DNS query
+	hook := &recordingHook{}
+	c.Hook = hook
+	c.Trace = true
+	c.DefaultExplanation = "%{p} is not allowed"
+	result = c.SPF(ctx, net.ParseIP("198.51.100.1"), "user@example.com", "")
+	trace, calls := result.Trace.String(), len(hook.calls)
+	if reply := result.SMTPReply(ctx); reply.Text != "unknown is not allowed" {
+		t.Errorf("unexpected reply %s", reply)
+	}
+	if result.Trace.String() != trace || len(hook.calls) != calls {
+		t.Errorf("building a reply changed the trace to\n%s\nor called hooks %v", result.Trace, hook.calls[calls:])
+	}
+}
@@ -28,6 +28,11 @@ const DefaultVoidQueryLimit = 2
 // evaluating a "ptr" mechanism or a "%{p}" macro.
 const DefaultPtrAddressLimit = 10
 
+// DefaultExplanation is the explanation given for a fail result when the
+// domain doesn't provide one with an "exp=" modifier. It is macro expanded
+// like an explanation published in DNS.
+const DefaultExplanation = "%{i} is not one of %{d}'s designated mail servers"
+
 // Checker holds all the configuration and limits for checking SPF records.
 type Checker struct {
 	Resolver        Resolver // used to resolve all DNS queries
@@ -37,6 +42,18 @@ type Checker struct {
 	PtrAddressLimit int      // use only this many PTR responses
 	Hostname        string   // the hostname of the machine running the check
 	Hook            Hook     // instrumentation hooks
+	Trace           bool     // build an evaluation trace in each Result
+
+	// Prefetch looks up the DNS needed by each record's terms in parallel.
+	// Queries are then made from several goroutines at once, so the
+	// Resolver must be safe for concurrent use, as those in this package
+	// are.
+	Prefetch bool
+
+	// DefaultExplanation is used in SMTP replies for fail results with no
+	// explanation from DNS. If it's empty the DefaultExplanation constant
+	// is used.
+	DefaultExplanation string
 }
 
 // NewChecker creates a new Checker with sensible defaults.
@@ -72,36 +89,87 @@ func Check(ctx context.Context, ip net.IP, mailFrom string, helo string) (Result
 func (c *Checker) SPF(ctx context.Context, ip net.IP, mailFrom string, helo string) Result {
 	var result Result
 	if helo != "" {
-		result = Result{
-			Type:   None,
-			ip:     ip,
-			sender: mailFrom,
-			helo:   helo,
-			c:      c,
-		}
-		r := c.checkHost(ctx, &result, dns.Fqdn(helo), false, false)
-		result.Type = r
-		if r != None && r != Neutral {
-			result.UsedHelo = true
+		result = c.CheckHelo(ctx, ip, helo)
+		if result.Type != None && result.Type != Neutral {
+			result.mailFrom = mailFrom
 			return result
 		}
 	}
 	if mailFrom != "" {
-		result = Result{
-			Type:   None,
-			ip:     ip,
-			sender: mailFrom,
-			helo:   helo,
-			c:      c,
-		}
-		at := strings.LastIndex(mailFrom, "@")
-		r := c.checkHost(ctx, &result, dns.Fqdn(mailFrom[at+1:]), false, false)
-		result.Type = r
+		result = c.CheckMailFrom(ctx, ip, mailFrom, helo)
 	}
 	return result
 }
 
+// CheckHelo checks SPF policy for the HELO identity alone.
+//
+// 2.3.  The "HELO" Identity (RFC 7208)
+//
+//	When checking the "HELO" identity, the <sender> is "postmaster@"
+//	followed by the HELO domain name.
+//
+// An internationalized helo is converted to A-labels, as described in RFC
+// 8616, and if that fails the result is none.
+func (c *Checker) CheckHelo(ctx context.Context, ip net.IP, helo string) Result {
+	result := Result{
+		Type:     None,
+		ip:       ip,
+		sender:   "postmaster@" + helo,
+		helo:     helo,
+		c:        c,
+		UsedHelo: true,
+	}
+	helo, err := toASCII(helo)
+	if err != nil {
+		result.Error = err
+		return result
+	}
+	result.sender = "postmaster@" + helo
+	result.helo = helo
+	result.Type = c.checkHost(ctx, &result, dns.Fqdn(helo), false, false)
+	return result
+}
+
+// CheckMailFrom checks SPF policy for the MAIL FROM identity alone. The helo
+// domain is only used for expanding macros, and may be empty.
+//
+// 2.4.  The "MAIL FROM" Identity (RFC 7208)
+//
+//	When the reverse-path is null, this document defines the "MAIL FROM"
+//	identity to be the mailbox composed of the local-part "postmaster"
+//	and the "HELO" identity (which might or might not have been checked
+//	separately before).
+//
+// An internationalized domain in mailFrom is converted to A-labels, as
+// described in RFC 8616, and if that fails the result is none. The local-part
+// may contain UTF-8.
+func (c *Checker) CheckMailFrom(ctx context.Context, ip net.IP, mailFrom string, helo string) Result {
+	if mailFrom == "" {
+		mailFrom = "postmaster@" + helo
+	}
+	result := Result{
+		Type:   None,
+		ip:     ip,
+		sender: mailFrom,
+		helo:   helo,
+		c:      c,
+	}
+	if asciiHelo, err := toASCII(helo); err == nil {
+		result.helo = asciiHelo
+	}
+	mailFrom, err := senderToASCII(mailFrom)
+	if err != nil {
+		result.Error = err
+		return result
+	}
+	result.sender = mailFrom
+	at := strings.LastIndex(mailFrom, "@")
+	result.Type = c.checkHost(ctx, &result, dns.Fqdn(mailFrom[at+1:]), false, false)
+	return result
+}
+
 // CheckHost implements the SPF check_host() function for a given domain.
+// Any U-labels in domain or the domain of sender are converted to A-labels.
 func (c *Checker) CheckHost(ctx context.Context, ip net.IP, domain, sender string, helo string) Result {
 	result := Result{
 		Type:   None,
@@ -110,6 +178,15 @@ func (c *Checker) CheckHost(ctx context.Context, ip net.IP, domain, sender strin
 		helo:   helo,
 		c:      c,
 	}
+	var err error
+	domain, err = toASCII(domain)
+	if err == nil {
+		result.sender, err = senderToASCII(sender)
+	}
+	if err != nil {
+		result.Error = err
+		return result
+	}
 
 	result.Type = c.checkHost(ctx, &result, domain, false, false)
 	return result
@@ -119,7 +196,20 @@ func (c *Checker) CheckHost(ctx context.Context, ip net.IP, domain, sender strin
 var invalidCharRe = regexp.MustCompile(`[^ -~]`)
 
 func (c *Checker) checkHost(ctx context.Context, result *Result, domain string, include bool, redirect bool) ResultType {
+	if c.Prefetch && result.prefetch == nil {
+		result.prefetch = newPrefetcher(ctx, c)
+		defer func() {
+			result.prefetch.stop()
+			result.prefetch = nil
+		}()
+	}
+	node := result.traceBegin(&TraceNode{Kind: TraceRecord, Domain: domain})
 	r := c.checkHostCore(ctx, result, domain, include, redirect)
+	if r == Temperror || r == Permerror {
+		result.traceEnd(node, r, result.Error)
+	} else {
+		result.traceEnd(node, r, nil)
+	}
 	if c.Hook != nil {
 		c.Hook.RecordResult(domain, result)
 	}
@@ -136,12 +226,12 @@ func (c *Checker) checkHostCore(ctx context.Context, result *Result, domain stri
 	//  result "none".
 
 	if _, valid := dns.IsDomainName(domain); !valid {
-		result.Error = errors.New("invalid domain")
+		result.Error = &DomainError{Name: domain}
 		return None
 	}
 
 	if !dns.IsFqdn(domain) {
-		result.Error = errors.New("domain not fully qualified")
+		result.Error = &DomainError{Name: domain, Err: errors.New("not fully qualified")}
 		return None
 	}
 
@@ -166,20 +256,24 @@ func (c *Checker) checkHostCore(ctx context.Context, result *Result, domain stri
 	//  this limit is exceeded, the implementation MUST return "permerror".
 	result.DNSQueries++
 	if result.DNSQueries > c.DNSLimit {
-		result.Error = fmt.Errorf("limit of %d dns queries exceeded", c.DNSLimit)
+		result.Error = &LimitError{Err: ErrDNSLimit, Limit: c.DNSLimit, Location: Location{Domain: domain}}
 		return Permerror
 	}
-	record, resultType, err := c.getSPFRecord(ctx, domain)
+	record, resultType, err := c.getSPFRecord(ctx, result, domain)
 	if err != nil {
-		result.Error = err
+		result.Error = locate(err, domain, nil)
 		return resultType
 	}
+	if node := result.traceCurrent(); node != nil {
+		node.Record = record
+	}
 	if c.Hook != nil {
 		c.Hook.Record(record, domain)
 	}
 
 	if record == "" {
 		if redirect {
+			result.Error = &RecordError{Location: Location{Domain: domain}}
 			return Permerror
 		}
 		return resultType
@@ -187,48 +281,45 @@ func (c *Checker) checkHostCore(ctx context.Context, result *Result, domain stri
 
 	badChar := invalidCharRe.FindString(record)
 	if badChar != "" {
-		result.Error = fmt.Errorf("invalid character %q", badChar[0])
+		result.Error = &SyntaxError{Err: fmt.Errorf("invalid character %q", badChar[0]), Location: Location{Domain: domain}}
 		return Permerror
 	}
 
 	mechanisms, err := ParseSPF(record)
 	if err != nil {
-		result.Error = err
+		result.Error = locate(err, domain, nil)
 		return Permerror
 	}
+	if result.prefetch != nil {
+		result.prefetch.record(result, domain, mechanisms)
+	}
 	for i, mechanism := range mechanisms.Mechanisms {
+		node := result.traceBegin(&TraceNode{Kind: TraceMechanism, Domain: domain, Index: i, Mechanism: mechanism})
 		resultType, err = mechanism.Evaluate(ctx, result, domain)
+		err = locate(err, domain, mechanism)
+		result.traceEnd(node, resultType, err)
 		result.Type = resultType
 		if c.Hook != nil {
 			c.Hook.Mechanism(domain, i, mechanism, result)
 		}
 		if result.DNSQueries > c.DNSLimit {
-			result.Error = fmt.Errorf("limit of %d dns queries exceeded", c.DNSLimit)
+			result.Error = &LimitError{Err: ErrDNSLimit, Limit: c.DNSLimit, Location: Location{Domain: domain, Mechanism: mechanism}}
 			return Permerror
 		}
-		if resultType != None {
-			result.Error = err
-			if err == nil && !include && resultType == Fail && mechanisms.Exp != "" {
-				target, err := c.ExpandDomainSpec(ctx, mechanisms.Exp, result, domain, false)
-				if err != nil {
-					result.Error = err
-					return Permerror
-				}
-				if !validDomainName(target) {
-					return Permerror
-				}
-				r := &dns.Msg{}
-				r.SetQuestion(target, dns.TypeTXT)
-				m, err := c.resolve(ctx, r)
-				if err == nil && m.Rcode == dns.RcodeSuccess && len(m.Answer) == 1 {
-					txt, ok := m.Answer[0].(*dns.TXT)
-					if ok {
-						result.Explanation, _ = c.ExpandMacro(ctx, strings.Join(txt.Txt, ""), result, domain, true)
-					}
-				}
-			}
-			return resultType
+		if resultType == None {
+			// An include that didn't match may have left the mechanism
+			// that matched in the included record
+			result.Mechanism = nil
+			continue
+		}
+		result.Mechanism = mechanism
+		result.Error = err
+		if err == nil && !include && resultType == Fail && mechanisms.Exp != "" {
+			node := result.traceBegin(&TraceNode{Kind: TraceExplanation, Domain: domain})
+			resultType = c.explain(ctx, result, mechanisms.Exp, domain)
+			result.traceEnd(node, resultType, result.Error)
 		}
+		return resultType
 	}
 
 	// Fell off the end of the record
@@ -236,22 +327,70 @@ func (c *Checker) checkHostCore(ctx context.Context, result *Result, domain stri
 		if c.Hook != nil {
 			c.Hook.Redirect(mechanisms.Redirect)
 		}
-		target, err := c.ExpandDomainSpec(ctx, mechanisms.Redirect, result, domain, false)
-
-		if err != nil {
-			return Permerror
+		node := result.traceBegin(&TraceNode{Kind: TraceRedirect, Domain: domain})
+		r := c.redirect(ctx, result, mechanisms.Redirect, domain)
+		if r == Temperror || r == Permerror {
+			result.traceEnd(node, r, result.Error)
+		} else {
+			result.traceEnd(node, r, nil)
 		}
-		if !validDomainName(target) {
-			return Permerror
+		return r
+	}
+	return Neutral
+}
+
+// explain fetches and expands the explanation for a fail result
+func (c *Checker) explain(ctx context.Context, result *Result, exp string, domain string) ResultType {
+	target, err := c.ExpandDomainSpec(ctx, exp, result, domain, false)
+	if errors.Is(err, ErrNonASCIILocalPart) {
+		// There's no explanation to look up
+		return Fail
+	}
+	if err != nil {
+		result.Error = locate(err, domain, nil)
+		return Permerror
+	}
+	if !validDomainName(target) {
+		result.Error = &DomainError{Name: target, Location: Location{Domain: domain}}
+		return Permerror
+	}
+	r := &dns.Msg{}
+	r.SetQuestion(dns.Fqdn(target), dns.TypeTXT)
+	m, err := c.resolve(ctx, result, r)
+	if err == nil && m.Rcode == dns.RcodeSuccess && len(m.Answer) == 1 {
+		txt, ok := m.Answer[0].(*dns.TXT)
+		if ok {
+			result.Explanation, _ = c.ExpandMacro(ctx, strings.Join(txt.Txt, ""), result, domain, true)
 		}
+	}
+	return Fail
+}
 
-		return c.checkHost(ctx, result, dns.Fqdn(target), false, true)
+// redirect follows a redirect modifier
+func (c *Checker) redirect(ctx context.Context, result *Result, redirect string, domain string) ResultType {
+	target, err := c.ExpandDomainSpec(ctx, redirect, result, domain, false)
+
+	if err != nil {
+		result.Error = locate(err, domain, nil)
+		return Permerror
 	}
-	return Neutral
+	if !validDomainName(target) {
+		result.Error = &DomainError{Name: target, Location: Location{Domain: domain}}
+		return Permerror
+	}
+
+	return c.checkHost(ctx, result, dns.Fqdn(target), false, true)
 }
 
-func (c *Checker) resolve(ctx context.Context, r *dns.Msg) (*dns.Msg, error) {
-	m, err := c.Resolver.Resolve(ctx, r)
+func (c *Checker) resolve(ctx context.Context, result *Result, r *dns.Msg) (*dns.Msg, error) {
+	var m *dns.Msg
+	var err error
+	if f := result.prefetched(r); f != nil {
+		m, err = f.wait(ctx)
+	} else {
+		m, err = c.Resolver.Resolve(ctx, r)
+	}
+	result.traceDNS(r, m, err)
 	if c.Hook != nil {
 		c.Hook.Dns(r, m, err)
 	}
@@ -266,6 +405,22 @@ type SPFRecord struct {
 	OtherModifiers []string
 }
 
+// String returns the SPF record in its text form.
+func (r *SPFRecord) String() string {
+	terms := []string{"v=spf1"}
+	for _, m := range r.Mechanisms {
+		terms = append(terms, m.String())
+	}
+	if r.Redirect != "" {
+		terms = append(terms, "redirect="+r.Redirect)
+	}
+	if r.Exp != "" {
+		terms = append(terms, "exp="+r.Exp)
+	}
+	terms = append(terms, r.OtherModifiers...)
+	return strings.Join(terms, " ")
+}
+
 //   modifier         = redirect / explanation / unknown-modifier
 //   unknown-modifier = name "=" macro-string
 //                      ; where name is not any known modifier
@@ -273,54 +428,12 @@ type SPFRecord struct {
 //   name             = ALPHA *( ALPHA / DIGIT / "-" / "_" / "." )
 var modifierRe = regexp.MustCompile(`^((?i)[a-z][a-z0-9_.-]*)=(.*)`)
 
-// ParseSPF parses the text of an SPF record.
+// ParseSPF parses the text of an SPF record, returning a *SyntaxError for
+// the first term that can't be parsed. ParseSPFLenient reports every problem.
 func ParseSPF(s string) (*SPFRecord, error) {
-	record := &SPFRecord{}
-	fields := strings.Fields(s)
-	if len(fields) == 0 {
-		return nil, errors.New("empty record")
-	}
-	if strings.ToLower(fields[0]) != "v=spf1" {
-		return nil, errors.New("record doesn't begin with v=spf1")
-	}
-
-	for i, field := range fields {
-		if i == 0 {
-			continue
-		}
-		matches := modifierRe.FindStringSubmatch(field)
-		if matches != nil {
-			switch strings.ToLower(matches[1]) {
-			case "redirect":
-				if record.Redirect != "" {
-					return nil, errors.New("multiple redirect modifiers")
-				}
-				if !validDomainSpec(matches[2]) {
-					return nil, errors.New("invalid domain-spec in redirect")
-				}
-				record.Redirect = matches[2]
-			case "exp":
-				if record.Exp != "" {
-					return nil, errors.New("multiple exp modifiers")
-				}
-				if !validDomainSpec(matches[2]) {
-					return nil, errors.New("invalid domain-spec in exp")
-				}
-				record.Exp = matches[2]
-			default:
-				if !MacroIsValid(matches[2]) {
-					return nil, errors.New("invalid macro-string in modifier")
-				}
-				record.OtherModifiers = append(record.OtherModifiers, field)
-			}
-			continue
-		}
-		m, err := NewMechanism(field)
-		if err != nil {
-			return nil, fmt.Errorf("In field '%s': %w", field, err)
-		}
-		record.Mechanisms = append(record.Mechanisms, m)
+	p := ParseSPFLenient(s)
+	if d := p.firstError(); d != nil {
+		return nil, &SyntaxError{Term: d.Term, Err: d.err}
 	}
-
-	return record, nil
+	return p.Record, nil
 }
@@ -2,250 +2,20 @@ package spf_test
 
 import (
 	"context"
-	"fmt"
-	"github.com/miekg/dns"
-	"github.com/wttw/spf"
-	"io"
-	"net"
-	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
-	"gopkg.in/yaml.v2"
+	"github.com/wttw/spf"
+	"github.com/wttw/spf/spftest"
 )
 
-type Test struct {
-	Spec        interface{}
-	Description string
-	Helo        string
-	Host        net.IP
-	MailFrom    string
-	Result      interface{}
-	Explanation string
-}
-
-type Answer interface{}
-
-type Suite struct {
-	Description string `yaml:"description"`
-	Tests       map[string]Test
-	ZoneData    map[string][]Answer
-}
-
-func (e Test) ResultMatches(s string) bool {
-	acceptable := toSlice(e.Result)
-	for _, a := range acceptable {
-		if s == a {
-			return true
-		}
-	}
-	return false
-}
-
-func toSlice(i interface{}) []string {
-	switch v := i.(type) {
-	case string:
-		return []string{v}
-	case []string:
-		return v
-	case []interface{}:
-		ret := make([]string, len(v))
-		for j, k := range v {
-			ret[j] = k.(string)
-		}
-		return ret
-	default:
-		panic(fmt.Errorf("unexpected type in RR: %T, %#v", i, i))
-	}
-}
-
-type TestResolver map[string]map[uint16]*dns.Msg
-
-var _ spf.Resolver = TestResolver{}
-
-func (res TestResolver) Resolve(_ context.Context, r *dns.Msg) (*dns.Msg, error) {
-	m := &dns.Msg{}
-	m.SetReply(r)
-	hostRRs, ok := res[strings.ToLower(r.Question[0].Name)]
-	if !ok {
-		m.SetRcode(r, dns.RcodeNameError) // NXDOMAIN
-		return m, nil
-	}
-
-	response, ok := hostRRs[r.Question[0].Qtype]
-	if ok {
-		m = response.Copy()
-		m.SetReply(r)
-	} else {
-
-		_, ok = hostRRs[0]
-		if ok {
-			m.SetRcode(r, dns.RcodeServerFailure) // SERVFAIL
-			return m, nil
-		}
-	}
-
-	m.SetRcode(r, dns.RcodeSuccess)
-	return m, nil
-}
-
-func (s Suite) Zone(t *testing.T) TestResolver {
-	ret := TestResolver{}
-
-	// Our test vectors have a weird mix of RRs in their sample DNS data
-	// In some tests there are both SPF and TXT records, which should be used as-is
-	// In others there's just SPF, which should all be treated as TXT (or duplicated
-	// as TXT?)
-
-	for hostname, answers := range s.ZoneData {
-		hostname = strings.ToLower(dns.Fqdn(hostname))
-		_, ok := ret[hostname]
-		if !ok {
-			ret[hostname] = map[uint16]*dns.Msg{}
-		}
-
-		seenTXT := false
-		for _, answer := range answers {
-			switch v := answer.(type) {
-			case map[interface{}]interface{}:
-				for typeThing, _ := range v {
-					typeString, ok := typeThing.(string)
-					if ok && typeString == "TXT" /* && strings.HasPrefix(value.(string), "v=spf1")*/ {
-						seenTXT = true
-					}
-				}
-			}
-		}
-
-		for _, answer := range answers {
-			switch v := answer.(type) {
-			case string:
-				if v != "TIMEOUT" {
-					t.Fatalf("Unrecognized value '%s' in %s", v, hostname)
-				}
-				ret[hostname][0] = nil
-			case map[interface{}]interface{}:
-				for typeThing, value := range v {
-					typeString, ok := typeThing.(string)
-					if !ok {
-						t.Fatalf("Unrecognized RR key %T in %s", typeThing, hostname)
-					}
-					typeID, ok := dns.StringToType[typeString]
-					if !ok {
-						t.Fatalf("Unrecognized RR type '%s' in %s", typeString, hostname)
-					}
-
-					var rr dns.RR
-					hdr := dns.RR_Header{
-						Name:   hostname,
-						Rrtype: typeID,
-						Class:  dns.ClassINET,
-						Ttl:    30,
-					}
-					switch typeID {
-					case dns.TypeSPF:
-						rr = &dns.SPF{
-							Hdr: hdr,
-							Txt: toSlice(value),
-						}
-					case dns.TypeMX:
-						slice := value.([]interface{})
-						weight := slice[0].(int)
-						rr = &dns.MX{
-							Hdr:        hdr,
-							Preference: uint16(weight),
-							Mx:         dns.Fqdn(slice[1].(string)),
-						}
-					case dns.TypeTXT:
-						rr = &dns.TXT{
-							Hdr: hdr,
-							Txt: toSlice(value),
-						}
-					case dns.TypeA:
-						rr = &dns.A{
-							Hdr: hdr,
-							A:   net.ParseIP(value.(string)),
-						}
-					case dns.TypeAAAA:
-						rr = &dns.AAAA{
-							Hdr:  hdr,
-							AAAA: net.ParseIP(value.(string)),
-						}
-					case dns.TypePTR:
-						rr = &dns.PTR{
-							Hdr: hdr,
-							Ptr: dns.Fqdn(value.(string)),
-						}
-					case dns.TypeCNAME:
-						rr = &dns.CNAME{
-							Hdr:    hdr,
-							Target: value.(string),
-						}
-					default:
-						t.Fatalf("Unhandled RR type '%s' in %s", typeString, hostname)
-					}
-
-					if typeID == dns.TypeTXT && rr.(*dns.TXT).Txt[0] == "NONE" {
-						continue
-					}
-
-					m, ok := ret[hostname][typeID]
-					if !ok {
-						m = &dns.Msg{}
-					}
-					m.Answer = append(m.Answer, rr)
-					ret[hostname][typeID] = m
-
-					// Dupe the SPF record to TXT
-					if !seenTXT && typeID == dns.TypeSPF {
-						m, ok := ret[hostname][dns.TypeTXT]
-						if !ok {
-							m = &dns.Msg{}
-						}
-						m.Answer = append(m.Answer, &dns.TXT{
-							Hdr: dns.RR_Header{
-								Name:   hostname,
-								Rrtype: dns.TypeTXT,
-								Class:  dns.ClassINET,
-								Ttl:    30,
-							},
-							Txt: toSlice(value),
-						})
-						ret[hostname][dns.TypeTXT] = m
-					}
-				}
-			default:
-				t.Fatalf("Unexpected RR type %T, %#v  in %s", answer, answer, hostname)
-			}
-		}
-	}
-	return ret
-}
-
-func loadSuites(t *testing.T, filename string) []Suite {
-	suites := []Suite{}
-	f, err := os.Open(filename)
-	if err != nil {
-		t.Fatalf("failed to open %s: %v", filename, err)
-	}
-	decoder := yaml.NewDecoder(f)
-	for {
-		var s Suite
-		err = decoder.Decode(&s)
+func runSuite(s spftest.Suite) func(*testing.T) {
+	return func(t *testing.T) {
+		resolver, err := s.Resolver()
 		if err != nil {
-			if err == io.EOF {
-				return suites
-			}
-			t.Fatalf("while reading %s: %v", filename, err)
+			t.Fatal(err)
 		}
-		suites = append(suites, s)
-	}
-}
-
-func runSuite(s Suite) func(*testing.T) {
-	return func(t *testing.T) {
-		resolver := s.Zone(t)
 		checker := spf.NewChecker()
 		checker.Resolver = resolver
 		for name, test := range s.Tests {
@@ -254,6 +24,11 @@ func runSuite(s Suite) func(*testing.T) {
 				if !test.ResultMatches(actual.String()) {
 					t.Errorf("expected %v, actual %s", test.Result, actual.String())
 				}
+				// "DEFAULT" means an implementation defined explanation, and
+				// the case of hex digits in reversed IPv6 addresses varies
+				if test.Explanation != "" && test.Explanation != "DEFAULT" && actual.Type == spf.Fail && !strings.EqualFold(actual.Explanation, test.Explanation) {
+					t.Errorf("expected explanation %q, actual %q", test.Explanation, actual.Explanation)
+				}
 			})
 		}
 	}
@@ -263,9 +38,47 @@ func TestSPF(t *testing.T) {
 	for _, filename := range []string{
 		"testdata/openspf/pyspf-tests.yml",
 		"testdata/openspf/rfc7208-tests.yml",
+		"testdata/rfc8616-tests.yml",
 	} {
-		for _, s := range loadSuites(t, filename) {
+		suites, err := spftest.LoadSuites(filename)
+		if err != nil {
+			t.Fatalf("while reading %s: %v", filename, err)
+		}
+		for _, s := range suites {
 			t.Run(filepath.Base(filename)+"/"+s.Description, runSuite(s))
 		}
 	}
 }
+
+func TestSPFHelo(t *testing.T) {
+	res := spftest.NewResolver().
+		TXT("mail.example.com", "v=spf1 exists:%{l}.%{o}.check.example.com -all").
+		A("postmaster.mail.example.com.check.example.com", "127.0.0.2").
+		TXT("neutral.example.com", "v=spf1 ?all").
+		TXT("example.com", "v=spf1 -all")
+	c := spftest.NewChecker(res)
+
+	// The sender when checking the HELO identity is postmaster@<helo>
+	result := spftest.AssertResult(t, c, "192.0.2.1", "user@example.com", "mail.example.com", spf.Pass)
+	if !result.UsedHelo {
+		t.Error("expected a HELO pass to be used")
+	}
+
+	// With no MAIL FROM, the HELO result is used even if it's none or neutral
+	for _, tst := range []struct {
+		helo string
+		want spf.ResultType
+	}{
+		{"neutral.example.com", spf.Neutral},
+		{"missing.example.com", spf.None},
+	} {
+		result = spftest.AssertResult(t, c, "192.0.2.1", "", tst.helo, tst.want)
+		if !result.UsedHelo {
+			t.Errorf("%s: expected the HELO result to be used", tst.helo)
+		}
+		result = spftest.AssertResult(t, c, "192.0.2.1", "user@example.com", tst.helo, spf.Fail)
+		if result.UsedHelo {
+			t.Errorf("%s: expected the MAIL FROM result to be used", tst.helo)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package spftest
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/wttw/spf"
+)
+
+// Hostname is the Hostname of Checkers created by NewChecker.
+const Hostname = "spftest.invalid"
+
+// NewChecker creates a Checker with default limits that uses res for all DNS
+// lookups.
+func NewChecker(res spf.Resolver) *spf.Checker {
+	c := spf.NewChecker()
+	c.Resolver = res
+	c.Hostname = Hostname
+	return c
+}
+
+// AssertResult checks SPF for a message from ip, using both mailFrom and
+// helo as Checker.SPF does, and reports a test error if the result isn't
+// want. It returns the Result for further inspection.
+func AssertResult(t testing.TB, c *spf.Checker, ip string, mailFrom string, helo string, want spf.ResultType) spf.Result {
+	t.Helper()
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		t.Fatalf("invalid IP address %q", ip)
+	}
+	result := c.SPF(context.Background(), addr, mailFrom, helo)
+	if result.Type != want {
+		t.Errorf("SPF(%s, %q, %q): expected %s, got %s (error: %v)", ip, mailFrom, helo, want, result.Type, result.Error)
+	}
+	return result
+}
+
+// AssertCheckHost runs check_host() for a single domain and reports a test
+// error if the result isn't want. It returns the Result for further
+// inspection.
+func AssertCheckHost(t testing.TB, c *spf.Checker, ip string, domain string, sender string, want spf.ResultType) spf.Result {
+	t.Helper()
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		t.Fatalf("invalid IP address %q", ip)
+	}
+	result := c.CheckHost(context.Background(), addr, domain, sender, "")
+	if result.Type != want {
+		t.Errorf("CheckHost(%s, %q, %q): expected %s, got %s (error: %v)", ip, domain, sender, want, result.Type, result.Error)
+	}
+	return result
+}
+
+// AssertQueries reports a test error if res hasn't received exactly want
+// queries.
+func AssertQueries(t testing.TB, res *Resolver, want int) {
+	t.Helper()
+	if got := res.Queries(); got != want {
+		t.Errorf("expected %d DNS queries, got %d", want, got)
+	}
+}
@@ -0,0 +1,371 @@
+/*
+Package spftest provides tools for testing code that uses package spf without
+a network: a fake spf.Resolver that can be programmed with records and
+failures, assertion helpers, and a loader for the YAML SPF test suites used by
+the openspf and pyspf projects.
+
+	res := spftest.NewResolver().
+		TXT("example.com", "v=spf1 mx -all").
+		MX("example.com", 10, "mail.example.com").
+		A("mail.example.com", "192.0.2.1")
+	c := spftest.NewChecker(res)
+	spftest.AssertResult(t, c, "192.0.2.1", "user@example.com", "", spf.Pass)
+*/
+package spftest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+	"github.com/wttw/spf"
+)
+
+// ErrTimeout is returned by Resolver for queries set to time out.
+var ErrTimeout = errors.New("spftest: simulated DNS timeout")
+
+// DefaultTTL is the TTL of records added by the Resolver's helper methods.
+const DefaultTTL = 300
+
+// maxCNAMEChain is how many CNAMEs Resolver will follow before treating the
+// chain as a loop
+const maxCNAMEChain = 8
+
+var _ spf.Resolver = &Resolver{}
+
+type failure int
+
+const (
+	noFailure failure = iota
+	servFail
+	timeout
+)
+
+type node struct {
+	rrs      map[uint16][]dns.RR
+	failures map[uint16]failure // failures for specific types
+	failAll  failure            // failure for every type
+	fallback failure            // failure for types with no records
+}
+
+// Resolver is a fake spf.Resolver that answers from records added to it, and
+// counts the queries it receives. Names that have had nothing added to them
+// return NXDOMAIN, names that exist but have no records of the type asked for
+// return an empty answer. CNAMEs are followed.
+//
+// The methods that add records return the Resolver, so they can be chained.
+// They panic if given invalid data, as they're intended for use in tests.
+//
+// A Resolver is safe for concurrent use by multiple goroutines.
+type Resolver struct {
+	mu      sync.Mutex
+	names   map[string]*node
+	soas    map[string]*dns.SOA
+	queries map[dns.Question]int
+	total   int
+}
+
+// NewResolver creates an empty Resolver. The zero value is also ready to use.
+func NewResolver() *Resolver {
+	return &Resolver{
+		names:   map[string]*node{},
+		soas:    map[string]*dns.SOA{},
+		queries: map[dns.Question]int{},
+	}
+}
+
+func canonical(name string) string {
+	return strings.ToLower(dns.Fqdn(name))
+}
+
+func header(name string, rrtype uint16) dns.RR_Header {
+	return dns.RR_Header{
+		Name:   canonical(name),
+		Rrtype: rrtype,
+		Class:  dns.ClassINET,
+		Ttl:    DefaultTTL,
+	}
+}
+
+// node returns the entry for a name, creating it if needed. The caller must
+// hold res.mu.
+func (res *Resolver) node(name string) *node {
+	if res.names == nil {
+		res.names = map[string]*node{}
+	}
+	name = canonical(name)
+	n, ok := res.names[name]
+	if !ok {
+		n = &node{
+			rrs:      map[uint16][]dns.RR{},
+			failures: map[uint16]failure{},
+		}
+		res.names[name] = n
+	}
+	return n
+}
+
+// Add adds arbitrary resource records.
+func (res *Resolver) Add(rrs ...dns.RR) *Resolver {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	for _, rr := range rrs {
+		n := res.node(rr.Header().Name)
+		n.rrs[rr.Header().Rrtype] = append(n.rrs[rr.Header().Rrtype], rr)
+	}
+	return res
+}
+
+// RR adds resource records given in zone file format, e.g.
+// "example.com. 300 IN TXT \"v=spf1 -all\"".
+func (res *Resolver) RR(records ...string) *Resolver {
+	for _, record := range records {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			panic(fmt.Sprintf("spftest: invalid record %q: %v", record, err))
+		}
+		if rr == nil {
+			panic(fmt.Sprintf("spftest: no record in %q", record))
+		}
+		res.Add(rr)
+	}
+	return res
+}
+
+// TXT adds a TXT record for each string given, splitting them into 255
+// character chunks if needed.
+func (res *Resolver) TXT(name string, txts ...string) *Resolver {
+	for _, txt := range txts {
+		res.Add(&dns.TXT{
+			Hdr: header(name, dns.TypeTXT),
+			Txt: spf.TXTStrings(txt),
+		})
+	}
+	return res
+}
+
+// SPF adds a record of the obsolete SPF RR type for each string given.
+func (res *Resolver) SPF(name string, txts ...string) *Resolver {
+	for _, txt := range txts {
+		res.Add(&dns.SPF{
+			Hdr: header(name, dns.TypeSPF),
+			Txt: spf.TXTStrings(txt),
+		})
+	}
+	return res
+}
+
+// A adds an A record for each IPv4 address given.
+func (res *Resolver) A(name string, addresses ...string) *Resolver {
+	for _, address := range addresses {
+		ip := net.ParseIP(address)
+		if ip == nil || ip.To4() == nil {
+			panic(fmt.Sprintf("spftest: invalid IPv4 address %q", address))
+		}
+		res.Add(&dns.A{
+			Hdr: header(name, dns.TypeA),
+			A:   ip.To4(),
+		})
+	}
+	return res
+}
+
+// AAAA adds an AAAA record for each IPv6 address given.
+func (res *Resolver) AAAA(name string, addresses ...string) *Resolver {
+	for _, address := range addresses {
+		ip := net.ParseIP(address)
+		if ip == nil || ip.To4() != nil {
+			panic(fmt.Sprintf("spftest: invalid IPv6 address %q", address))
+		}
+		res.Add(&dns.AAAA{
+			Hdr:  header(name, dns.TypeAAAA),
+			AAAA: ip,
+		})
+	}
+	return res
+}
+
+// MX adds an MX record.
+func (res *Resolver) MX(name string, preference uint16, host string) *Resolver {
+	return res.Add(&dns.MX{
+		Hdr:        header(name, dns.TypeMX),
+		Preference: preference,
+		Mx:         dns.Fqdn(host),
+	})
+}
+
+// PTR adds a PTR record mapping an IP address to a hostname.
+func (res *Resolver) PTR(address string, host string) *Resolver {
+	rev, err := dns.ReverseAddr(address)
+	if err != nil {
+		panic(fmt.Sprintf("spftest: invalid IP address %q", address))
+	}
+	return res.Add(&dns.PTR{
+		Hdr: header(rev, dns.TypePTR),
+		Ptr: dns.Fqdn(host),
+	})
+}
+
+// CNAME adds a CNAME record.
+func (res *Resolver) CNAME(name string, target string) *Resolver {
+	return res.Add(&dns.CNAME{
+		Hdr:    header(name, dns.TypeCNAME),
+		Target: dns.Fqdn(target),
+	})
+}
+
+// SOA makes zone the apex of a zone, so that negative responses for names
+// within it include an SOA record.
+func (res *Resolver) SOA(zone string) *Resolver {
+	soa := &dns.SOA{
+		Hdr:     header(zone, dns.TypeSOA),
+		Ns:      "ns." + canonical(zone),
+		Mbox:    "hostmaster." + canonical(zone),
+		Serial:  1,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  DefaultTTL,
+	}
+	res.mu.Lock()
+	if res.soas == nil {
+		res.soas = map[string]*dns.SOA{}
+	}
+	res.soas[canonical(zone)] = soa
+	res.mu.Unlock()
+	return res.Add(soa)
+}
+
+// NoData makes a name exist, without adding any records to it.
+func (res *Resolver) NoData(name string) *Resolver {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	res.node(name)
+	return res
+}
+
+// ServFail makes queries for a name return SERVFAIL. If no types are given
+// every query for the name fails, otherwise only queries for those types.
+func (res *Resolver) ServFail(name string, qtypes ...uint16) *Resolver {
+	return res.fail(name, servFail, qtypes)
+}
+
+// Timeout makes queries for a name return ErrTimeout. If no types are given
+// every query for the name fails, otherwise only queries for those types.
+func (res *Resolver) Timeout(name string, qtypes ...uint16) *Resolver {
+	return res.fail(name, timeout, qtypes)
+}
+
+func (res *Resolver) fail(name string, f failure, qtypes []uint16) *Resolver {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	n := res.node(name)
+	if len(qtypes) == 0 {
+		n.failAll = f
+	}
+	for _, qtype := range qtypes {
+		n.failures[qtype] = f
+	}
+	return res
+}
+
+// Queries returns the total number of queries the Resolver has received.
+func (res *Resolver) Queries() int {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	return res.total
+}
+
+// QueryCount returns the number of queries received for a name and type.
+func (res *Resolver) QueryCount(name string, qtype uint16) int {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	return res.queries[dns.Question{Name: canonical(name), Qtype: qtype, Qclass: dns.ClassINET}]
+}
+
+// ResetQueries sets the query counts back to zero.
+func (res *Resolver) ResetQueries() {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	res.total = 0
+	res.queries = map[dns.Question]int{}
+}
+
+// Resolve answers a query from the records added to the Resolver.
+func (res *Resolver) Resolve(ctx context.Context, r *dns.Msg) (*dns.Msg, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(r.Question) != 1 {
+		return nil, errors.New("spftest: expected exactly one question")
+	}
+	q := r.Question[0]
+	name := canonical(q.Name)
+
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	if res.queries == nil {
+		res.queries = map[dns.Question]int{}
+	}
+	res.queries[dns.Question{Name: name, Qtype: q.Qtype, Qclass: q.Qclass}]++
+	res.total++
+
+	m := &dns.Msg{}
+	m.SetReply(r)
+	for chain := 0; chain <= maxCNAMEChain; chain++ {
+		n, ok := res.names[name]
+		if !ok {
+			m.Rcode = dns.RcodeNameError
+			res.addSOA(m, name)
+			return m, nil
+		}
+		rrs := n.rrs[q.Qtype]
+		f, ok := n.failures[q.Qtype]
+		if !ok {
+			f = n.failAll
+		}
+		if f == noFailure && len(rrs) == 0 {
+			f = n.fallback
+		}
+		switch f {
+		case servFail:
+			m.Rcode = dns.RcodeServerFailure
+			m.Answer = nil
+			return m, nil
+		case timeout:
+			return nil, ErrTimeout
+		}
+		if len(rrs) > 0 {
+			for _, rr := range rrs {
+				m.Answer = append(m.Answer, dns.Copy(rr))
+			}
+			return m, nil
+		}
+		cnames := n.rrs[dns.TypeCNAME]
+		if len(cnames) == 0 || q.Qtype == dns.TypeCNAME {
+			res.addSOA(m, name)
+			return m, nil
+		}
+		m.Answer = append(m.Answer, dns.Copy(cnames[0]))
+		name = canonical(cnames[0].(*dns.CNAME).Target)
+	}
+	// CNAME loop
+	m.Rcode = dns.RcodeServerFailure
+	m.Answer = nil
+	return m, nil
+}
+
+// addSOA adds the SOA of the zone containing name, if there is one, to a
+// negative response. The caller must hold res.mu.
+func (res *Resolver) addSOA(m *dns.Msg, name string) {
+	for off, end := 0, false; !end; off, end = dns.NextLabel(name, off) {
+		if soa, ok := res.soas[name[off:]]; ok {
+			m.Ns = append(m.Ns, dns.Copy(soa))
+			return
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package spftest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/wttw/spf"
+	"github.com/wttw/spf/spftest"
+)
+
+func TestResolver(t *testing.T) {
+	res := spftest.NewResolver().
+		SOA("example.com").
+		TXT("example.com", "v=spf1 mx include:_spf.example.com -all").
+		MX("example.com", 10, "mail.example.com").
+		CNAME("mail.example.com", "host.example.com").
+		A("host.example.com", "192.0.2.1").
+		AAAA("host.example.com", "2001:db8::1").
+		TXT("_spf.example.com", "v=spf1 ip4:198.51.100.0/24 -all").
+		ServFail("broken.example.com").
+		Timeout("slow.example.com", dns.TypeTXT).
+		TXT("slow.example.com", "v=spf1 -all")
+	c := spftest.NewChecker(res)
+
+	spftest.AssertResult(t, c, "192.0.2.1", "user@example.com", "", spf.Pass)
+	spftest.AssertResult(t, c, "2001:db8::1", "user@example.com", "", spf.Pass)
+	spftest.AssertResult(t, c, "198.51.100.1", "user@example.com", "", spf.Pass)
+	spftest.AssertResult(t, c, "203.0.113.1", "user@example.com", "", spf.Fail)
+	spftest.AssertResult(t, c, "192.0.2.1", "user@missing.example.com", "", spf.None)
+	spftest.AssertResult(t, c, "192.0.2.1", "user@broken.example.com", "", spf.Temperror)
+	result := spftest.AssertResult(t, c, "192.0.2.1", "user@slow.example.com", "", spf.Temperror)
+	if !errors.Is(result.Error, spftest.ErrTimeout) {
+		t.Errorf("expected a timeout error, got %v", result.Error)
+	}
+
+	if n := res.QueryCount("EXAMPLE.com", dns.TypeTXT); n != 4 {
+		t.Errorf("expected 4 TXT queries for example.com, got %d", n)
+	}
+	res.ResetQueries()
+	spftest.AssertQueries(t, res, 0)
+
+	r := &dns.Msg{}
+	r.SetQuestion("example.com.", dns.TypeAAAA)
+	m, err := res.Resolve(context.Background(), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Rcode != dns.RcodeSuccess || len(m.Answer) != 0 || len(m.Ns) != 1 {
+		t.Errorf("expected NODATA with an SOA, got %s", m)
+	}
+}
@@ -0,0 +1,234 @@
+package spftest
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+	"gopkg.in/yaml.v2"
+)
+
+// Test is a single test case from a YAML test suite.
+type Test struct {
+	Spec        interface{}
+	Description string
+	Helo        string
+	Host        net.IP
+	MailFrom    string
+	Result      interface{}
+	Explanation string
+}
+
+// Answer is a single entry in the zonedata of a YAML test suite.
+type Answer interface{}
+
+// Suite is a set of tests sharing the same DNS data, in the YAML format used
+// by the openspf and pyspf test suites.
+type Suite struct {
+	Description string `yaml:"description"`
+	Tests       map[string]Test
+	ZoneData    map[string][]Answer
+}
+
+// ResultMatches returns whether s is one of the acceptable results for the
+// test.
+func (e Test) ResultMatches(s string) bool {
+	acceptable, err := toSlice(e.Result)
+	if err != nil {
+		return false
+	}
+	for _, a := range acceptable {
+		if s == a {
+			return true
+		}
+	}
+	return false
+}
+
+func toSlice(i interface{}) ([]string, error) {
+	switch v := i.(type) {
+	case string:
+		return []string{v}, nil
+	case []string:
+		return v, nil
+	case []interface{}:
+		ret := make([]string, len(v))
+		for j, k := range v {
+			s, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("unexpected type in list: %T, %#v", k, k)
+			}
+			ret[j] = s
+		}
+		return ret, nil
+	default:
+		return nil, fmt.Errorf("unexpected type in RR: %T, %#v", i, i)
+	}
+}
+
+// Resolver builds a Resolver serving the suite's zonedata.
+//
+// A host listed as TIMEOUT returns SERVFAIL for any type it has no records
+// for. Where a host has records of the obsolete SPF type and no TXT records
+// the SPF records are also published as TXT.
+func (s Suite) Resolver() (*Resolver, error) {
+	ret := NewResolver()
+
+	for hostname, answers := range s.ZoneData {
+		hostname = strings.ToLower(dns.Fqdn(hostname))
+		ret.NoData(hostname)
+
+		// Our test vectors have a weird mix of RRs in their sample DNS data
+		// In some tests there are both SPF and TXT records, which should be used as-is
+		// In others there's just SPF, which should all be treated as TXT
+		seenTXT := false
+		for _, answer := range answers {
+			if v, ok := answer.(map[interface{}]interface{}); ok {
+				for typeThing := range v {
+					if typeString, ok := typeThing.(string); ok && typeString == "TXT" {
+						seenTXT = true
+					}
+				}
+			}
+		}
+
+		for _, answer := range answers {
+			switch v := answer.(type) {
+			case string:
+				if v != "TIMEOUT" {
+					return nil, fmt.Errorf("unrecognized value '%s' in %s", v, hostname)
+				}
+				ret.mu.Lock()
+				ret.node(hostname).fallback = servFail
+				ret.mu.Unlock()
+			case map[interface{}]interface{}:
+				for typeThing, value := range v {
+					typeString, ok := typeThing.(string)
+					if !ok {
+						return nil, fmt.Errorf("unrecognized RR key %T in %s", typeThing, hostname)
+					}
+					rrs, err := suiteRRs(hostname, typeString, value, seenTXT)
+					if err != nil {
+						return nil, fmt.Errorf("in %s: %w", hostname, err)
+					}
+					ret.Add(rrs...)
+				}
+			default:
+				return nil, fmt.Errorf("unexpected RR type %T, %#v in %s", answer, answer, hostname)
+			}
+		}
+	}
+	return ret, nil
+}
+
+// suiteRRs converts a single zonedata entry into resource records
+func suiteRRs(hostname string, typeString string, value interface{}, seenTXT bool) ([]dns.RR, error) {
+	typeID, ok := dns.StringToType[typeString]
+	if !ok {
+		return nil, fmt.Errorf("unrecognized RR type '%s'", typeString)
+	}
+	hdr := dns.RR_Header{
+		Name:   hostname,
+		Rrtype: typeID,
+		Class:  dns.ClassINET,
+		Ttl:    30,
+	}
+	str := func() (string, error) {
+		s, ok := value.(string)
+		if !ok {
+			return "", fmt.Errorf("unexpected value %#v for %s", value, typeString)
+		}
+		return s, nil
+	}
+
+	switch typeID {
+	case dns.TypeSPF:
+		txt, err := toSlice(value)
+		if err != nil {
+			return nil, err
+		}
+		ret := []dns.RR{&dns.SPF{Hdr: hdr, Txt: txt}}
+		// Dupe the SPF record to TXT
+		if !seenTXT {
+			hdr.Rrtype = dns.TypeTXT
+			ret = append(ret, &dns.TXT{Hdr: hdr, Txt: txt})
+		}
+		return ret, nil
+	case dns.TypeTXT:
+		txt, err := toSlice(value)
+		if err != nil {
+			return nil, err
+		}
+		if txt[0] == "NONE" {
+			return nil, nil
+		}
+		return []dns.RR{&dns.TXT{Hdr: hdr, Txt: txt}}, nil
+	case dns.TypeMX:
+		slice, ok := value.([]interface{})
+		if !ok || len(slice) != 2 {
+			return nil, fmt.Errorf("unexpected value %#v for MX", value)
+		}
+		weight, ok := slice[0].(int)
+		if !ok {
+			return nil, fmt.Errorf("unexpected preference %#v for MX", slice[0])
+		}
+		host, ok := slice[1].(string)
+		if !ok {
+			return nil, fmt.Errorf("unexpected exchange %#v for MX", slice[1])
+		}
+		return []dns.RR{&dns.MX{Hdr: hdr, Preference: uint16(weight), Mx: dns.Fqdn(host)}}, nil
+	case dns.TypeA, dns.TypeAAAA:
+		s, err := str()
+		if err != nil {
+			return nil, err
+		}
+		if typeID == dns.TypeA {
+			return []dns.RR{&dns.A{Hdr: hdr, A: net.ParseIP(s)}}, nil
+		}
+		return []dns.RR{&dns.AAAA{Hdr: hdr, AAAA: net.ParseIP(s)}}, nil
+	case dns.TypePTR:
+		s, err := str()
+		if err != nil {
+			return nil, err
+		}
+		return []dns.RR{&dns.PTR{Hdr: hdr, Ptr: dns.Fqdn(s)}}, nil
+	case dns.TypeCNAME:
+		s, err := str()
+		if err != nil {
+			return nil, err
+		}
+		return []dns.RR{&dns.CNAME{Hdr: hdr, Target: dns.Fqdn(s)}}, nil
+	default:
+		return nil, fmt.Errorf("unhandled RR type '%s'", typeString)
+	}
+}
+
+// LoadSuites reads all the test suites from a YAML file.
+func LoadSuites(filename string) ([]Suite, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadSuites(f)
+}
+
+// ReadSuites reads all the test suites from a YAML stream.
+func ReadSuites(r io.Reader) ([]Suite, error) {
+	suites := []Suite{}
+	decoder := yaml.NewDecoder(r)
+	for {
+		var s Suite
+		err := decoder.Decode(&s)
+		if err != nil {
+			if err == io.EOF {
+				return suites, nil
+			}
+			return nil, err
+		}
+		suites = append(suites, s)
+	}
+}
@@ -0,0 +1,266 @@
+package spf
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultMaxRecordLength is the longest SPF record SplitRecord will produce
+// by default. RFC 7208 section 3.4 suggests keeping the whole DNS response
+// within 512 bytes, and this leaves room for the rest of the response.
+const DefaultMaxRecordLength = 450
+
+// SplitPrefix is the first label of the names SplitRecord uses for the
+// records it creates, followed by a number.
+const SplitPrefix = "_spf"
+
+// PolicyRecord is one of the TXT records making up an SPF policy.
+type PolicyRecord struct {
+	Name   string // fully qualified owner name
+	Record *SPFRecord
+}
+
+// TXT returns the record as a TXT resource record.
+func (p PolicyRecord) TXT(ttl uint32) *dns.TXT {
+	return &dns.TXT{
+		Hdr: dns.RR_Header{
+			Name:   p.Name,
+			Rrtype: dns.TypeTXT,
+			Class:  dns.ClassINET,
+			Ttl:    ttl,
+		},
+		Txt: TXTStrings(p.Record.String()),
+	}
+}
+
+// ZoneLine returns the record as a line for a zone file.
+func (p PolicyRecord) ZoneLine(ttl uint32) string {
+	return p.TXT(ttl).String()
+}
+
+// TXTStrings splits text into strings of no more than 255 bytes, the
+// longest a single string in a TXT record can be.
+//
+// 3.3.  Multiple Strings in a Single DNS Record (RFC 7208)
+//
+//	As defined in [RFC1035], Sections 3.3 and 3.3.14, a single text DNS
+//	record can be composed of more than one string.  If a published
+//	record contains multiple character-strings, then the record MUST be
+//	treated as if those strings are concatenated together without adding
+//	spaces.
+func TXTStrings(s string) []string {
+	var ret []string
+	for len(s) > 255 {
+		ret = append(ret, s[:255])
+		s = s[255:]
+	}
+	return append(ret, s)
+}
+
+// ipRun is a sequence of consecutive ip4 and ip6 mechanisms sharing the same
+// qualifier, which can be moved into an included record without changing
+// the result of checking the policy.
+type ipRun struct {
+	start, end int // indexes of the mechanisms in the original record
+	qualifier  ResultType
+	length     int // length of the mechanisms as text
+}
+
+// SplitRecord divides an SPF record for domain that is too long to publish
+// into a chain of records, each no longer than maxLength. If maxLength is
+// zero DefaultMaxRecordLength is used. The first record returned is for
+// domain itself, the rest are named _spf1.domain, _spf2.domain and so on.
+//
+// Runs of ip4 and ip6 mechanisms with the same qualifier are moved into
+// records of their own, which are included with that qualifier, largest
+// first until the record for domain is short enough. Records that are
+// still too long include another record holding the rest of the run. Each
+// address gets the same result from the records returned as from the
+// original.
+//
+// It returns an error if the terms of the records returned would cause more
+// than DefaultDNSLimit DNS lookups, or if the record can't be made short
+// enough. Lookups caused by records the original includes aren't counted.
+func SplitRecord(domain string, record *SPFRecord, maxLength int) ([]PolicyRecord, error) {
+	if maxLength == 0 {
+		maxLength = DefaultMaxRecordLength
+	}
+	domain = dns.Fqdn(domain)
+	top := PolicyRecord{Name: domain, Record: record}
+	length := len(record.String())
+	if length <= maxLength {
+		return []PolicyRecord{top}, nil
+	}
+
+	// Find the runs of ip mechanisms, and move the largest ones out
+	// until the top level record is short enough
+	runs := ipRuns(record.Mechanisms)
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].length > runs[j].length
+	})
+	var moving []ipRun
+	for _, run := range runs {
+		if length <= maxLength {
+			break
+		}
+		moving = append(moving, run)
+		// the include replacing the run will be around this long
+		length += len(" -include:"+SplitPrefix+"00.") + len(domain) - run.length
+	}
+	sort.Slice(moving, func(i, j int) bool {
+		return moving[i].start < moving[j].start
+	})
+
+	// Build the chains of included records
+	var children []PolicyRecord
+	replacements := map[int]Mechanism{}
+	next := func() string {
+		return fmt.Sprintf("%s%d.%s", SplitPrefix, len(children)+1, domain)
+	}
+	for _, run := range moving {
+		name := next()
+		replacements[run.start] = MechanismInclude{Qualifier: run.qualifier, DomainSpec: name}
+		chain, err := splitRun(record.Mechanisms[run.start:run.end], name, maxLength, len(children)+1, domain)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, chain...)
+	}
+
+	topRecord := &SPFRecord{
+		Exp:            record.Exp,
+		Redirect:       record.Redirect,
+		OtherModifiers: record.OtherModifiers,
+	}
+	skip := map[int]bool{}
+	for _, run := range moving {
+		for i := run.start + 1; i < run.end; i++ {
+			skip[i] = true
+		}
+	}
+	for i, m := range record.Mechanisms {
+		if replacement, ok := replacements[i]; ok {
+			topRecord.Mechanisms = append(topRecord.Mechanisms, replacement)
+			continue
+		}
+		if !skip[i] {
+			topRecord.Mechanisms = append(topRecord.Mechanisms, m)
+		}
+	}
+	top.Record = topRecord
+	if l := len(topRecord.String()); l > maxLength {
+		return nil, fmt.Errorf("record for %s is %d bytes after splitting, more than %d", domain, l, maxLength)
+	}
+
+	ret := append([]PolicyRecord{top}, children...)
+	lookups := 0
+	for _, p := range ret {
+		lookups += lookupCount(p.Record)
+	}
+	if lookups > DefaultDNSLimit {
+		return nil, fmt.Errorf("split records for %s need %d DNS lookups, more than the limit of %d", domain, lookups, DefaultDNSLimit)
+	}
+	return ret, nil
+}
+
+// SplitIPs builds the records for a policy made up only of ip4 and ip6
+// mechanisms, with no "all". See SplitRecord.
+func SplitIPs(domain string, mechanisms []Mechanism, maxLength int) ([]PolicyRecord, error) {
+	return SplitRecord(domain, &SPFRecord{Mechanisms: mechanisms}, maxLength)
+}
+
+// splitRun builds a chain of records matching the mechanisms in a run,
+// each including the next. Their names are numbered from n.
+func splitRun(mechanisms []Mechanism, name string, maxLength int, n int, domain string) ([]PolicyRecord, error) {
+	var ret []PolicyRecord
+	for len(mechanisms) > 0 {
+		nextName := fmt.Sprintf("%s%d.%s", SplitPrefix, n+1, domain)
+		chain := MechanismInclude{Qualifier: Pass, DomainSpec: nextName}
+		end := MechanismAll{Qualifier: Fail}
+		r := &SPFRecord{}
+		for len(mechanisms) > 0 {
+			// Everything in the included record needs to pass for the
+			// include to match
+			m := passing(mechanisms[0])
+			r.Mechanisms = append(r.Mechanisms, m)
+			withChain := &SPFRecord{Mechanisms: append(append([]Mechanism{}, r.Mechanisms...), chain, end)}
+			if len(withChain.String()) > maxLength && len(mechanisms) > 1 {
+				r.Mechanisms = r.Mechanisms[:len(r.Mechanisms)-1]
+				break
+			}
+			mechanisms = mechanisms[1:]
+		}
+		if len(r.Mechanisms) == 0 {
+			return nil, fmt.Errorf("maximum record length %d is too short to split %s", maxLength, domain)
+		}
+		if len(mechanisms) > 0 {
+			r.Mechanisms = append(r.Mechanisms, chain)
+		}
+		r.Mechanisms = append(r.Mechanisms, end)
+		if l := len(r.String()); l > maxLength {
+			return nil, fmt.Errorf("maximum record length %d is too short to split %s", maxLength, domain)
+		}
+		ret = append(ret, PolicyRecord{Name: name, Record: r})
+		name = nextName
+		n++
+	}
+	return ret, nil
+}
+
+// ipRuns finds the runs of ip4 and ip6 mechanisms with the same qualifier.
+func ipRuns(mechanisms []Mechanism) []ipRun {
+	var ret []ipRun
+	var current *ipRun
+	for i, m := range mechanisms {
+		var qualifier ResultType
+		switch v := m.(type) {
+		case MechanismIp4:
+			qualifier = v.Qualifier
+		case MechanismIp6:
+			qualifier = v.Qualifier
+		default:
+			current = nil
+			continue
+		}
+		if current == nil || current.qualifier != qualifier {
+			ret = append(ret, ipRun{start: i, qualifier: qualifier})
+			current = &ret[len(ret)-1]
+		}
+		current.end = i + 1
+		current.length += len(m.String()) + 1
+	}
+	return ret
+}
+
+// passing returns an ip4 or ip6 mechanism with a pass qualifier.
+func passing(m Mechanism) Mechanism {
+	switch v := m.(type) {
+	case MechanismIp4:
+		v.Qualifier = Pass
+		return v
+	case MechanismIp6:
+		v.Qualifier = Pass
+		return v
+	}
+	return m
+}
+
+// lookupCount returns how many terms in a record cause DNS lookups.
+func lookupCount(record *SPFRecord) int {
+	count := 0
+	all := false
+	for _, m := range record.Mechanisms {
+		switch m.(type) {
+		case MechanismInclude, MechanismA, MechanismMX, MechanismPTR, MechanismExists:
+			count++
+		case MechanismAll:
+			all = true
+		}
+	}
+	if record.Redirect != "" && !all {
+		count++
+	}
+	return count
+}
@@ -0,0 +1,82 @@
+package spf_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/wttw/spf"
+	"github.com/wttw/spf/spftest"
+)
+
+func TestSplitRecord(t *testing.T) {
+	text := "v=spf1 mx"
+	for i := 0; i < 60; i++ {
+		text += fmt.Sprintf(" ip4:192.0.2.%d", i*4)
+	}
+	for i := 0; i < 10; i++ {
+		text += fmt.Sprintf(" ~ip6:2001:db8:%x::/48", i)
+	}
+	text += " ip4:198.51.100.0/24 -all"
+	record, err := spf.ParseSPF(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := spf.SplitRecord("example.com", record, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) < 2 {
+		t.Fatalf("expected the record to be split, got %d records", len(records))
+	}
+	if records[0].Name != "example.com." {
+		t.Errorf("expected the first record to be for example.com., got %s", records[0].Name)
+	}
+
+	res := spftest.NewResolver().
+		TXT("original.example.com", text).
+		MX("example.com", 10, "mx.example.com").
+		MX("original.example.com", 10, "mx.example.com").
+		A("mx.example.com", "203.0.113.1")
+	for _, r := range records {
+		if l := len(r.Record.String()); l > spf.DefaultMaxRecordLength {
+			t.Errorf("%s is %d bytes long", r.Name, l)
+		}
+		// Check the zone file line round trips
+		rr, err := dns.NewRR(r.ZoneLine(300))
+		if err != nil {
+			t.Fatalf("%s: %v", r.ZoneLine(300), err)
+		}
+		txt := rr.(*dns.TXT)
+		for _, s := range txt.Txt {
+			if len(s) > 255 {
+				t.Errorf("%s has a %d byte string", r.Name, len(s))
+			}
+		}
+		if strings.Join(txt.Txt, "") != r.Record.String() {
+			t.Errorf("%s doesn't round trip", r.Name)
+		}
+		res.Add(rr)
+	}
+
+	c := spftest.NewChecker(res)
+	for _, ip := range []string{"192.0.2.0", "192.0.2.1", "192.0.2.236", "2001:db8:9::1", "2001:db8:a::1", "198.51.100.7", "203.0.113.1", "203.0.113.2"} {
+		addr := net.ParseIP(ip)
+		original := c.CheckHost(context.Background(), addr, "original.example.com.", "user@example.com", "")
+		split := c.CheckHost(context.Background(), addr, "example.com.", "user@example.com", "")
+		if original.Type != split.Type {
+			t.Errorf("%s: original record gives %s, split gives %s", ip, original.Type, split.Type)
+		}
+		if split.Error != nil {
+			t.Errorf("%s: %v", ip, split.Error)
+		}
+	}
+
+	if _, err := spf.SplitRecord("example.com", record, 40); err == nil {
+		t.Errorf("expected an error splitting into very short records")
+	}
+}
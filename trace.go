@@ -0,0 +1,202 @@
+package spf
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// TraceKind identifies which step of an SPF evaluation a TraceNode records.
+type TraceKind int
+
+const (
+	TraceRecord      TraceKind = iota // check_host() evaluation of a domain
+	TraceMechanism                    // evaluation of a single mechanism
+	TraceRedirect                     // a redirect modifier being followed
+	TraceExplanation                  // an exp modifier being fetched and expanded
+	TraceDNS                          // a DNS query
+	TraceMacro                        // a macro expansion
+)
+
+var traceKindNames = map[TraceKind]string{
+	TraceRecord:      "record",
+	TraceMechanism:   "mechanism",
+	TraceRedirect:    "redirect",
+	TraceExplanation: "explanation",
+	TraceDNS:         "dns",
+	TraceMacro:       "macro",
+}
+
+func (k TraceKind) String() string {
+	name, ok := traceKindNames[k]
+	if !ok {
+		return fmt.Sprintf("TraceKind(%d)", int(k))
+	}
+	return name
+}
+
+// MarshalText implements the encoding.TextMarshaler interface for TraceKind.
+func (k TraceKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface for TraceKind.
+func (k *TraceKind) UnmarshalText(text []byte) error {
+	for kind, name := range traceKindNames {
+		if name == string(text) {
+			*k = kind
+			return nil
+		}
+	}
+	return fmt.Errorf("%s does not belong to TraceKind values", text)
+}
+
+// TraceNode is a single step in the evaluation of an SPF policy. The nodes
+// form a tree, rooted at the check_host() evaluation of the identity being
+// checked, with DNS queries, macro expansions and included or redirected
+// records as children of the step that caused them.
+//
+// Which fields are populated depends on Kind.
+type TraceNode struct {
+	Kind      TraceKind
+	Domain    string       // the domain whose record is being evaluated
+	Record    string       // SPF record text, for TraceRecord
+	Index     int          // position of the mechanism in its record, for TraceMechanism
+	Mechanism Mechanism    // the mechanism, for TraceMechanism
+	Target    string       // expanded domain-spec, without a trailing dot, or macro
+	Macro     string       // macro-string before expansion, for TraceMacro
+	Question  dns.Question // the question asked, for TraceDNS
+	Rcode     int          // response code, or -1 if the query failed, for TraceDNS
+	Answer    []dns.RR     // answer section of the response, for TraceDNS
+	Result    ResultType   // result of this step
+	Error     error        // any error returned by this step
+	Children  []*TraceNode // steps taken while evaluating this one
+}
+
+// String renders the trace as an indented tree, one step per line.
+func (n *TraceNode) String() string {
+	var sb strings.Builder
+	n.write(&sb, 0)
+	return sb.String()
+}
+
+func (n *TraceNode) write(sb *strings.Builder, depth int) {
+	sb.WriteString(strings.Repeat("  ", depth))
+	sb.WriteString(n.Kind.String())
+	sb.WriteString(" ")
+	switch n.Kind {
+	case TraceRecord:
+		fmt.Fprintf(sb, "%s %q => %s", n.Domain, n.Record, n.Result)
+	case TraceMechanism:
+		fmt.Fprintf(sb, "%d %s", n.Index+1, n.Mechanism)
+		if n.Target != "" {
+			fmt.Fprintf(sb, " (%s)", n.Target)
+		}
+		fmt.Fprintf(sb, " => %s", n.Result)
+	case TraceRedirect, TraceExplanation:
+		fmt.Fprintf(sb, "%s => %s", n.Target, n.Result)
+	case TraceDNS:
+		fmt.Fprintf(sb, "%s %s", dns.Type(n.Question.Qtype), n.Question.Name)
+		if n.Rcode >= 0 {
+			fmt.Fprintf(sb, " %s, %d answers", dns.RcodeToString[n.Rcode], len(n.Answer))
+		}
+	case TraceMacro:
+		fmt.Fprintf(sb, "%s => %s", n.Macro, n.Target)
+	}
+	if n.Error != nil {
+		fmt.Fprintf(sb, " (%s)", n.Error)
+	}
+	sb.WriteString("\n")
+	for _, child := range n.Children {
+		child.write(sb, depth+1)
+	}
+}
+
+// tracing returns whether an evaluation trace is being built for this result
+func (r *Result) tracing() bool {
+	return r != nil && r.c != nil && r.c.Trace
+}
+
+// traceBegin adds node to the trace and makes it the parent of subsequent nodes,
+// until traceEnd is called.
+func (r *Result) traceBegin(node *TraceNode) *TraceNode {
+	if !r.tracing() {
+		return nil
+	}
+	r.traceAdd(node)
+	r.traceStack = append(r.traceStack, node)
+	return node
+}
+
+// traceEnd records the outcome of a step started with traceBegin.
+func (r *Result) traceEnd(node *TraceNode, resultType ResultType, err error) {
+	if node == nil {
+		return
+	}
+	node.Result = resultType
+	node.Error = err
+	for i := len(r.traceStack) - 1; i >= 0; i-- {
+		if r.traceStack[i] == node {
+			r.traceStack = r.traceStack[:i]
+			return
+		}
+	}
+}
+
+// traceAdd adds a leaf node to the trace.
+func (r *Result) traceAdd(node *TraceNode) {
+	if !r.tracing() {
+		return
+	}
+	if len(r.traceStack) == 0 {
+		if r.Trace == nil {
+			r.Trace = node
+			return
+		}
+		r.Trace.Children = append(r.Trace.Children, node)
+		return
+	}
+	parent := r.traceStack[len(r.traceStack)-1]
+	parent.Children = append(parent.Children, node)
+}
+
+// traceCurrent returns the innermost step being traced, if any.
+func (r *Result) traceCurrent() *TraceNode {
+	if !r.tracing() || len(r.traceStack) == 0 {
+		return nil
+	}
+	return r.traceStack[len(r.traceStack)-1]
+}
+
+// traceTarget records the expanded domain-spec of the current step, without
+// any trailing dot, so that targets read the same whether they came from the
+// record or the domain being checked.
+func (r *Result) traceTarget(target string) {
+	node := r.traceCurrent()
+	if node == nil || node.Target != "" {
+		return
+	}
+	switch node.Kind {
+	case TraceMechanism, TraceRedirect, TraceExplanation:
+		node.Target = strings.TrimSuffix(target, ".")
+	}
+}
+
+// traceDNS records a DNS query and its response.
+func (r *Result) traceDNS(q *dns.Msg, m *dns.Msg, err error) {
+	if !r.tracing() || len(q.Question) == 0 {
+		return
+	}
+	node := &TraceNode{
+		Kind:     TraceDNS,
+		Question: q.Question[0],
+		Rcode:    -1,
+		Error:    err,
+	}
+	if m != nil {
+		node.Rcode = m.Rcode
+		node.Answer = m.Answer
+	}
+	r.traceAdd(node)
+}
@@ -0,0 +1,98 @@
+package spf_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/wttw/spf"
+	"github.com/wttw/spf/spftest"
+)
+
+func TestTrace(t *testing.T) {
+	res := spftest.NewResolver().
+		TXT("example.com", "v=spf1 a:%{l}.a.example.com include:inc.example.com mx -all exp=exp.example.com").
+		TXT("inc.example.com", "v=spf1 ip4:192.0.2.1 -all").
+		TXT("exp.example.com", "%{i} is not allowed").
+		MX("example.com", 10, "mail.example.com").
+		A("mail.example.com", "198.51.100.1").
+		TXT("redirect.example.com", "v=spf1 redirect=example.com").
+		TXT("temp.example.com", "v=spf1 include:timeout.example.com -all").
+		Timeout("timeout.example.com").
+		TXT("perm.example.com", "v=spf1 include:bad.example.com -all").
+		TXT("bad.example.com", "v=spf1 foo:bar -all")
+	c := spftest.NewChecker(res)
+	c.Trace = true
+
+	check := func(ip, mailFrom string, want spf.ResultType) spf.Result {
+		t.Helper()
+		result := c.SPF(context.Background(), net.ParseIP(ip), mailFrom, "")
+		if result.Type != want {
+			t.Fatalf("%s from %s: expected %s, got %s", mailFrom, ip, want, result.Type)
+		}
+		if result.Trace == nil {
+			t.Fatalf("%s from %s: no trace", mailFrom, ip)
+		}
+		return result
+	}
+
+	result := check("203.0.113.1", "user@example.com", spf.Fail)
+	want := `record example.com. "v=spf1 a:%{l}.a.example.com include:inc.example.com mx -all exp=exp.example.com" => fail
+  dns TXT example.com. NOERROR, 1 answers
+  mechanism 1 a:%{l}.a.example.com (user.a.example.com) => none
+    macro %{l}.a.example.com => user.a.example.com
+    dns A user.a.example.com. NXDOMAIN, 0 answers
+  mechanism 2 include:inc.example.com (inc.example.com) => none
+    record inc.example.com. "v=spf1 ip4:192.0.2.1 -all" => fail
+      dns TXT inc.example.com. NOERROR, 1 answers
+      mechanism 1 ip4:192.0.2.1/32 => none
+      mechanism 2 -all => fail
+  mechanism 3 mx (example.com) => none
+    dns MX example.com. NOERROR, 1 answers
+    dns A mail.example.com. NOERROR, 1 answers
+  mechanism 4 -all => fail
+  explanation exp.example.com => fail
+    dns TXT exp.example.com. NOERROR, 1 answers
+    macro %{i} is not allowed => 203.0.113.1 is not allowed
+`
+	if got := result.Trace.String(); got != want {
+		t.Errorf("expected trace\n%s\ngot\n%s", want, got)
+	}
+
+	result = check("192.0.2.1", "user@redirect.example.com", spf.Pass)
+	root := result.Trace
+	if len(root.Children) != 2 || root.Children[1].Kind != spf.TraceRedirect {
+		t.Fatalf("expected a TXT query and redirect under the record, got\n%s", root)
+	}
+	redirect := root.Children[1]
+	if redirect.Target != "example.com" || redirect.Result != spf.Pass {
+		t.Errorf("unexpected redirect node %+v", redirect)
+	}
+	if len(redirect.Children) != 1 || redirect.Children[0].Kind != spf.TraceRecord || redirect.Children[0].Domain != "example.com." {
+		t.Errorf("expected the redirected record under the redirect, got\n%s", root)
+	}
+
+	for _, tst := range []struct {
+		mailFrom string
+		want     spf.ResultType
+		err      error
+	}{
+		{"user@temp.example.com", spf.Temperror, spf.ErrDNS},
+		{"user@perm.example.com", spf.Permerror, spf.ErrSyntax},
+	} {
+		result = check("192.0.2.1", tst.mailFrom, tst.want)
+		root = result.Trace
+		if root.Result != tst.want || !errors.Is(root.Error, tst.err) {
+			t.Errorf("%s: expected the record to fail with %v, got %s %v", tst.mailFrom, tst.err, root.Result, root.Error)
+		}
+		include := root.Children[1]
+		if include.Kind != spf.TraceMechanism || include.Result != tst.want || !errors.Is(include.Error, tst.err) {
+			t.Errorf("%s: expected the include to fail with %v, got\n%s", tst.mailFrom, tst.err, root)
+		}
+		inner := include.Children[len(include.Children)-1]
+		if inner.Kind != spf.TraceRecord || inner.Result != tst.want || !errors.Is(inner.Error, tst.err) {
+			t.Errorf("%s: expected the included record to fail with %v, got\n%s", tst.mailFrom, tst.err, root)
+		}
+	}
+}
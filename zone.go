@@ -0,0 +1,219 @@
+package spf
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// maxCNAMEChain is the number of CNAMEs a ZoneResolver will follow
+const maxCNAMEChain = 8
+
+// zoneDefaultTTL is used for records in zone files that have no $TTL
+const zoneDefaultTTL = 3600
+
+var _ Resolver = &ZoneResolver{}
+
+// ZoneResolver is a Resolver that answers queries from RFC 1035 master
+// ("zone") files, with no network access.
+//
+// It answers authoritatively for each zone that has an SOA record loaded,
+// returning NXDOMAIN for names that don't exist and an empty answer (NODATA)
+// for names that exist but have no records of the type asked for, with the
+// SOA in the authority section. Queries for names outside those zones are
+// REFUSED. If no SOA records have been loaded at all it treats every name as
+// being in a single zone. CNAMEs and wildcards are followed.
+//
+// A ZoneResolver is safe for concurrent use by multiple goroutines.
+type ZoneResolver struct {
+	mu     sync.RWMutex
+	names  map[string]map[uint16][]dns.RR // records by owner name and type
+	exists map[string]bool                // owner names, and empty non-terminals
+	zones  map[string]*dns.SOA            // SOA records by zone apex
+}
+
+// NewZoneResolver creates an empty ZoneResolver. The zero value is also ready
+// to use.
+func NewZoneResolver() *ZoneResolver {
+	return &ZoneResolver{
+		names:  map[string]map[uint16][]dns.RR{},
+		exists: map[string]bool{},
+		zones:  map[string]*dns.SOA{},
+	}
+}
+
+// LoadZoneFile reads a zone file. If origin is empty the file must set it
+// with an $ORIGIN directive or use only fully qualified names. $INCLUDE
+// directives are allowed.
+func (z *ZoneResolver) LoadZoneFile(filename, origin string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return z.loadZone(f, origin, filename, true)
+}
+
+// LoadZone reads a zone file from r. The filename is used only in error
+// messages. $INCLUDE directives are not allowed.
+func (z *ZoneResolver) LoadZone(r io.Reader, origin, filename string) error {
+	return z.loadZone(r, origin, filename, false)
+}
+
+func (z *ZoneResolver) loadZone(r io.Reader, origin, filename string, includes bool) error {
+	zp := dns.NewZoneParser(r, origin, filename)
+	zp.SetDefaultTTL(zoneDefaultTTL)
+	zp.SetIncludeAllowed(includes)
+	var rrs []dns.RR
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		rrs = append(rrs, rr)
+	}
+	if err := zp.Err(); err != nil {
+		return err
+	}
+	z.Add(rrs...)
+	return nil
+}
+
+// Add adds records to the resolver. An SOA record makes its owner name the
+// apex of a zone.
+func (z *ZoneResolver) Add(rrs ...dns.RR) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if z.names == nil {
+		z.names = map[string]map[uint16][]dns.RR{}
+		z.exists = map[string]bool{}
+		z.zones = map[string]*dns.SOA{}
+	}
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		if hdr.Class != dns.ClassINET {
+			continue
+		}
+		name := strings.ToLower(dns.Fqdn(hdr.Name))
+		if soa, ok := rr.(*dns.SOA); ok {
+			z.zones[name] = soa
+		}
+		types, ok := z.names[name]
+		if !ok {
+			types = map[uint16][]dns.RR{}
+			z.names[name] = types
+		}
+		types[hdr.Rrtype] = append(types[hdr.Rrtype], rr)
+
+		// Mark the name and all its ancestors as existing, so that empty
+		// non-terminals return NODATA rather than NXDOMAIN
+		for off, end := 0, false; !end; off, end = dns.NextLabel(name, off) {
+			z.exists[name[off:]] = true
+		}
+	}
+}
+
+// Resolve answers a query from the loaded zone data.
+func (z *ZoneResolver) Resolve(_ context.Context, r *dns.Msg) (*dns.Msg, error) {
+	m := &dns.Msg{}
+	m.SetReply(r)
+	if len(r.Question) != 1 {
+		m.Rcode = dns.RcodeFormatError
+		return m, nil
+	}
+	q := r.Question[0]
+	if q.Qclass != dns.ClassINET {
+		m.Rcode = dns.RcodeRefused
+		return m, nil
+	}
+
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	name := strings.ToLower(q.Name)
+	if _, ok := z.zoneFor(name); !ok {
+		m.Rcode = dns.RcodeRefused
+		return m, nil
+	}
+	m.Authoritative = true
+
+	for chain := 0; chain <= maxCNAMEChain; chain++ {
+		types, ok := z.lookup(name)
+		if !ok {
+			m.Rcode = dns.RcodeNameError
+			z.addSOA(m, name)
+			return m, nil
+		}
+		if rrs := types[q.Qtype]; len(rrs) > 0 {
+			m.Answer = append(m.Answer, withOwner(rrs, name)...)
+			return m, nil
+		}
+		cnames := types[dns.TypeCNAME]
+		if len(cnames) == 0 || q.Qtype == dns.TypeCNAME {
+			z.addSOA(m, name)
+			return m, nil
+		}
+		m.Answer = append(m.Answer, withOwner(cnames[:1], name)...)
+		name = strings.ToLower(dns.Fqdn(cnames[0].(*dns.CNAME).Target))
+		if _, ok := z.zoneFor(name); !ok {
+			// The target is somewhere we don't have data for
+			return m, nil
+		}
+	}
+	m.Rcode = dns.RcodeServerFailure
+	m.Answer = nil
+	return m, nil
+}
+
+// zoneFor finds the zone a name is in.
+func (z *ZoneResolver) zoneFor(name string) (*dns.SOA, bool) {
+	if len(z.zones) == 0 {
+		return nil, true
+	}
+	for off, end := 0, false; !end; off, end = dns.NextLabel(name, off) {
+		if soa, ok := z.zones[name[off:]]; ok {
+			return soa, true
+		}
+	}
+	return nil, false
+}
+
+// lookup finds the records for a name, synthesizing them from a wildcard
+// (RFC 4592) if needed. It returns false if the name doesn't exist.
+func (z *ZoneResolver) lookup(name string) (map[uint16][]dns.RR, bool) {
+	if z.exists[name] {
+		return z.names[name], true
+	}
+	// Find the closest encloser, and see if it has a wildcard child
+	for off, end := dns.NextLabel(name, 0); !end; off, end = dns.NextLabel(name, off) {
+		encloser := name[off:]
+		if !z.exists[encloser] {
+			continue
+		}
+		types, ok := z.names["*."+encloser]
+		return types, ok
+	}
+	return nil, false
+}
+
+// addSOA adds the SOA for the zone containing name to the authority section
+// of a negative response.
+func (z *ZoneResolver) addSOA(m *dns.Msg, name string) {
+	soa, _ := z.zoneFor(name)
+	if soa != nil {
+		m.Ns = append(m.Ns, dns.Copy(soa))
+	}
+}
+
+// withOwner copies records, giving them the owner name that was asked for,
+// which will differ from the loaded one for wildcards.
+func withOwner(rrs []dns.RR, name string) []dns.RR {
+	ret := make([]dns.RR, len(rrs))
+	for i, rr := range rrs {
+		ret[i] = dns.Copy(rr)
+		if !strings.EqualFold(ret[i].Header().Name, name) {
+			ret[i].Header().Name = name
+		}
+	}
+	return ret
+}
@@ -0,0 +1,81 @@
+package spf_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/wttw/spf"
+)
+
+const testZone = `$ORIGIN example.com.
+$TTL 300
+@        IN SOA  ns.example.com. hostmaster.example.com. 1 3600 600 86400 60
+@        IN TXT  "v=spf1 a:mail.example.com include:_spf.example.com -all"
+mail     IN A    192.0.2.1
+_spf     IN CNAME spf.example.net.
+www      IN CNAME mail
+*.wild   IN TXT  "v=spf1 +all"
+a.b.c    IN TXT  "deep"
+`
+
+const testZone2 = `spf.example.net. 300 IN SOA ns.example.net. hostmaster.example.net. 1 3600 600 86400 60
+spf.example.net. 300 IN TXT "v=spf1 ip4:198.51.100.0/24 ip6:2001:db8::/32 -all"
+`
+
+func TestZoneResolver(t *testing.T) {
+	z := spf.NewZoneResolver()
+	if err := z.LoadZone(strings.NewReader(testZone), "", "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.LoadZone(strings.NewReader(testZone2), "", "example.net"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		qtype   uint16
+		rcode   int
+		answers int
+		soa     bool
+	}{
+		{"example.com.", dns.TypeTXT, dns.RcodeSuccess, 1, false},
+		{"EXAMPLE.com.", dns.TypeTXT, dns.RcodeSuccess, 1, false},
+		{"example.com.", dns.TypeA, dns.RcodeSuccess, 0, true},
+		{"missing.example.com.", dns.TypeA, dns.RcodeNameError, 0, true},
+		{"b.c.example.com.", dns.TypeTXT, dns.RcodeSuccess, 0, true}, // empty non-terminal
+		{"www.example.com.", dns.TypeA, dns.RcodeSuccess, 2, false},
+		{"_spf.example.com.", dns.TypeTXT, dns.RcodeSuccess, 2, false},
+		{"anything.wild.example.com.", dns.TypeTXT, dns.RcodeSuccess, 1, false},
+		{"example.org.", dns.TypeTXT, dns.RcodeRefused, 0, false},
+	}
+	for _, test := range tests {
+		r := &dns.Msg{}
+		r.SetQuestion(test.name, test.qtype)
+		m, err := z.Resolve(context.Background(), r)
+		if err != nil {
+			t.Fatalf("%s %s: %v", dns.Type(test.qtype), test.name, err)
+		}
+		if m.Rcode != test.rcode || len(m.Answer) != test.answers || (len(m.Ns) > 0) != test.soa {
+			t.Errorf("%s %s: expected %s with %d answers, got %s with %d answers and %d authority records",
+				dns.Type(test.qtype), test.name, dns.RcodeToString[test.rcode], test.answers,
+				dns.RcodeToString[m.Rcode], len(m.Answer), len(m.Ns))
+		}
+	}
+
+	c := spf.NewChecker()
+	c.Resolver = z
+	for ip, expected := range map[string]spf.ResultType{
+		"192.0.2.1":    spf.Pass,
+		"198.51.100.7": spf.Pass,
+		"2001:db8::1":  spf.Pass,
+		"203.0.113.1":  spf.Fail,
+	} {
+		result := c.CheckHost(context.Background(), net.ParseIP(ip), "example.com.", "user@example.com", "")
+		if result.Type != expected {
+			t.Errorf("%s: expected %s, got %s (%v)", ip, expected, result.Type, result.Error)
+		}
+	}
+}